@@ -0,0 +1,313 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+const (
+	KindQuota       = "quota"
+	KindTransfer    = "transfer"
+	KindUnreachable = "unreachable"
+)
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); nil != err {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if nil != err {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule describes a single alert condition. Which fields are used depends on Kind:
+// quota rules fire when a peer reaches Percent of its quota, transfer rules fire when
+// a peer transfers more than Bytes within Window, and unreachable rules fire when the
+// interface could not be read, or did not exist while its link is watched, for Ticks
+// consecutive ticks.
+type Rule struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Percent uint     `json:"percent,omitempty"`
+	Bytes   uint     `json:"bytes,omitempty"`
+	Window  Duration `json:"window,omitempty"`
+	Ticks   uint     `json:"ticks,omitempty"`
+}
+
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name cannot be empty")
+	}
+	switch r.Kind {
+	case KindQuota:
+		if r.Percent == 0 {
+			return fmt.Errorf("rule %q: percent must be greater than zero", r.Name)
+		}
+	case KindTransfer:
+		if r.Bytes == 0 {
+			return fmt.Errorf("rule %q: bytes must be greater than zero", r.Name)
+		}
+		if r.Window <= 0 {
+			return fmt.Errorf("rule %q: window must be greater than zero", r.Name)
+		}
+	case KindUnreachable:
+		if r.Ticks == 0 {
+			return fmt.Errorf("rule %q: ticks must be greater than zero", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown kind %q", r.Name, r.Kind)
+	}
+	return nil
+}
+
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks"`
+	// Quotas maps peer public keys to their total (upload + download) quota in bytes.
+	Quotas map[string]uint `json:"quotas"`
+	Rules  []Rule          `json:"rules"`
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	names := make(map[string]struct{}, len(c.Rules))
+	for _, r := range c.Rules {
+		if err := r.Validate(); nil != err {
+			errs = append(errs, err)
+			continue
+		}
+		if _, exists := names[r.Name]; exists {
+			errs = append(errs, fmt.Errorf("rule %q: duplicate rule name", r.Name))
+		}
+		names[r.Name] = struct{}{}
+	}
+	for i, w := range c.Webhooks {
+		if w.URL == "" {
+			errs = append(errs, fmt.Errorf("webhook #%d: url cannot be empty", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read alert rules file: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(content, &c); nil != err {
+		return nil, fmt.Errorf("failed to parse alert rules file: %w", err)
+	}
+	if err := c.Validate(); nil != err {
+		return nil, fmt.Errorf("invalid alert rules: %w", err)
+	}
+	return &c, nil
+}
+
+type Alert struct {
+	// ID is stable across delivery retries of the same firing, so receivers can deduplicate on it.
+	ID        string    `json:"id"`
+	Rule      string    `json:"rule"`
+	Kind      string    `json:"kind"`
+	Interface string    `json:"interface"`
+	PublicKey string    `json:"publicKey,omitempty"`
+	Value     uint      `json:"value"`
+	Threshold uint      `json:"threshold"`
+	At        time.Time `json:"at"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, a Alert)
+}
+
+type sample struct {
+	total uint
+	at    time.Time
+}
+
+// Evaluator checks the configured rules after each engine tick and notifies about each
+// firing once. A rule is re-armed for a peer only after its condition stops holding.
+type Evaluator struct {
+	interfaceName string
+	rules         []Rule
+	quotas        map[string]uint
+	notifier      Notifier
+	logger        zerolog.Logger
+
+	mu       sync.Mutex
+	firing   map[string]time.Time
+	history  map[string][]sample
+	failures uint
+}
+
+func NewEvaluator(interfaceName string, cfg Config, notifier Notifier, logger zerolog.Logger) *Evaluator {
+	return &Evaluator{
+		interfaceName: interfaceName,
+		rules:         cfg.Rules,
+		quotas:        cfg.Quotas,
+		notifier:      notifier,
+		logger:        logger,
+		firing:        make(map[string]time.Time),
+		history:       make(map[string][]sample),
+	}
+}
+
+func (e *Evaluator) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures = 0
+	e.record(peersUsage, gatheredAt)
+
+	for _, r := range e.rules {
+		switch r.Kind {
+		case KindQuota:
+			for _, p := range peersUsage {
+				quota, exists := e.quotas[p.PublicKey]
+				if !exists || quota == 0 {
+					continue
+				}
+				threshold := percentOf(quota, r.Percent)
+				total := p.Upload + p.Download
+				e.evaluate(ctx, r, p.PublicKey, total >= threshold, total, threshold, gatheredAt)
+			}
+		case KindTransfer:
+			for _, p := range peersUsage {
+				transferred := e.transferred(p.PublicKey, time.Duration(r.Window), gatheredAt)
+				e.evaluate(ctx, r, p.PublicKey, transferred > r.Bytes, transferred, r.Bytes, gatheredAt)
+			}
+		case KindUnreachable:
+			e.evaluate(ctx, r, "", false, 0, r.Ticks, gatheredAt)
+		}
+	}
+}
+
+func (e *Evaluator) UsageFailed(ctx context.Context, err error, failedAt time.Time) {
+	var stageErr *ingest.StageError
+	if errors.As(err, &stageErr) && stageErr.Stage != ingest.StageDevice {
+		return
+	}
+	e.unreachable(ctx, failedAt)
+}
+
+// UsageWaiting counts ticks skipped while the interface does not exist as unreachable.
+func (e *Evaluator) UsageWaiting(ctx context.Context, waitingAt time.Time) {
+	e.unreachable(ctx, waitingAt)
+}
+
+func (e *Evaluator) unreachable(ctx context.Context, at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures++
+	for _, r := range e.rules {
+		if r.Kind != KindUnreachable {
+			continue
+		}
+		e.evaluate(ctx, r, "", e.failures >= r.Ticks, e.failures, r.Ticks, at)
+	}
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, r Rule, publicKey string, holds bool, value, threshold uint, at time.Time) {
+	key := r.Name + "/" + publicKey
+	if !holds {
+		delete(e.firing, key)
+		return
+	}
+	if _, exists := e.firing[key]; exists {
+		return
+	}
+	e.firing[key] = at
+
+	a := Alert{
+		ID:        fmt.Sprintf("%s/%s/%s/%d", e.interfaceName, r.Name, publicKey, at.UnixMilli()),
+		Rule:      r.Name,
+		Kind:      r.Kind,
+		Interface: e.interfaceName,
+		PublicKey: publicKey,
+		Value:     value,
+		Threshold: threshold,
+		At:        at,
+	}
+	e.logger.Warn().Str("rule", r.Name).Str("public_key", publicKey).Uint("value", value).Uint("threshold", threshold).Msg("alert rule fired")
+	e.notifier.Notify(ctx, a)
+}
+
+func (e *Evaluator) record(peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	var window time.Duration
+	for _, r := range e.rules {
+		if r.Kind == KindTransfer && time.Duration(r.Window) > window {
+			window = time.Duration(r.Window)
+		}
+	}
+	if window == 0 {
+		return
+	}
+
+	seen := make(map[string]struct{}, len(peersUsage))
+	for _, p := range peersUsage {
+		seen[p.PublicKey] = struct{}{}
+		samples := append(e.history[p.PublicKey], sample{total: p.Upload + p.Download, at: gatheredAt})
+		// Keeps the newest sample older than the window, as it is the baseline of the window.
+		cut := 0
+		for i := 0; i < len(samples)-1 && !samples[i+1].at.After(gatheredAt.Add(-window)); i++ {
+			cut = i + 1
+		}
+		e.history[p.PublicKey] = samples[cut:]
+	}
+	for k := range e.history {
+		if _, exists := seen[k]; !exists {
+			delete(e.history, k)
+		}
+	}
+}
+
+// percentOf returns percent percent of quota, computing the product in 128 bits so that it cannot
+// overflow, and saturating at the largest uint if the result itself does not fit.
+func percentOf(quota, percent uint) uint {
+	hi, lo := bits.Mul(quota, percent)
+	if hi >= 100 {
+		return math.MaxUint
+	}
+	threshold, _ := bits.Div(hi, lo, 100)
+	return threshold
+}
+
+func (e *Evaluator) transferred(publicKey string, window time.Duration, now time.Time) uint {
+	samples := e.history[publicKey]
+	if len(samples) < 2 {
+		return 0
+	}
+	latest := samples[len(samples)-1]
+	base := samples[0]
+	for _, s := range samples {
+		if s.at.After(now.Add(-window)) {
+			break
+		}
+		base = s
+	}
+	if latest.total < base.total {
+		return latest.total
+	}
+	return latest.total - base.total
+}
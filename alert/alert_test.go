@@ -0,0 +1,258 @@
+package alert_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/alert"
+	"github.com/xeptore/wireuse/ingest"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []alert.Alert
+}
+
+func (r *recorder) Notify(ctx context.Context, a alert.Alert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+}
+
+func TestEvaluatorQuota(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rec := &recorder{}
+	cfg := alert.Config{
+		Quotas: map[string]uint{"xyz": 1000},
+		Rules: []alert.Rule{
+			{Name: "quota-80", Kind: alert.KindQuota, Percent: 80},
+			{Name: "quota-100", Kind: alert.KindQuota, Percent: 100},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+	e := alert.NewEvaluator("wg0", cfg, rec, zerolog.New(io.Discard))
+
+	now := time.Now()
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 100, Download: 600, PublicKey: "xyz"}, {Upload: 5000, PublicKey: "abc"}}, now)
+	require.Empty(t, rec.alerts)
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 200, Download: 600, PublicKey: "xyz"}}, now.Add(time.Second))
+	require.Len(t, rec.alerts, 1)
+	require.Equal(t, "quota-80", rec.alerts[0].Rule)
+	require.Equal(t, uint(800), rec.alerts[0].Value)
+	require.Equal(t, uint(800), rec.alerts[0].Threshold)
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 300, Download: 600, PublicKey: "xyz"}}, now.Add(2*time.Second))
+	require.Len(t, rec.alerts, 1, "expected firing rule not to be notified again")
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 400, Download: 600, PublicKey: "xyz"}}, now.Add(3*time.Second))
+	require.Len(t, rec.alerts, 2)
+	require.Equal(t, "quota-100", rec.alerts[1].Rule)
+}
+
+func TestEvaluatorQuotaNearMaxUint(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rec := &recorder{}
+	quota := uint(math.MaxUint - 99)
+	cfg := alert.Config{
+		Quotas: map[string]uint{"xyz": quota},
+		Rules: []alert.Rule{
+			{Name: "quota-80", Kind: alert.KindQuota, Percent: 80},
+			{Name: "quota-200", Kind: alert.KindQuota, Percent: 200},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+	e := alert.NewEvaluator("wg0", cfg, rec, zerolog.New(io.Discard))
+
+	now := time.Now()
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 1 << 20, PublicKey: "xyz"}}, now)
+	require.Empty(t, rec.alerts, "expected the threshold not to wrap around to a small value")
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: quota / 10 * 9, PublicKey: "xyz"}}, now.Add(time.Second))
+	require.Len(t, rec.alerts, 1)
+	require.Equal(t, "quota-80", rec.alerts[0].Rule)
+	require.Equal(t, quota/100*80+quota%100*80/100, rec.alerts[0].Threshold)
+}
+
+func TestEvaluatorTransfer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rec := &recorder{}
+	cfg := alert.Config{
+		Rules: []alert.Rule{{Name: "hourly", Kind: alert.KindTransfer, Bytes: 1000, Window: alert.Duration(time.Hour)}},
+	}
+	e := alert.NewEvaluator("wg0", cfg, rec, zerolog.New(io.Discard))
+
+	now := time.Now()
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 0, Download: 5000, PublicKey: "xyz"}}, now)
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 500, Download: 5000, PublicKey: "xyz"}}, now.Add(30*time.Minute))
+	require.Empty(t, rec.alerts)
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 1100, Download: 5000, PublicKey: "xyz"}}, now.Add(50*time.Minute))
+	require.Len(t, rec.alerts, 1)
+	require.Equal(t, uint(1100), rec.alerts[0].Value)
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 1200, Download: 5000, PublicKey: "xyz"}}, now.Add(100*time.Minute))
+	require.Len(t, rec.alerts, 1, "expected transfer within the last hour to be under threshold")
+
+	e.UsageIngested(ctx, []ingest.PeerUsage{{Upload: 2300, Download: 5000, PublicKey: "xyz"}}, now.Add(110*time.Minute))
+	require.Len(t, rec.alerts, 2, "expected rule to be re-armed after its condition cleared")
+}
+
+func TestEvaluatorUnreachable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rec := &recorder{}
+	cfg := alert.Config{Rules: []alert.Rule{{Name: "down", Kind: alert.KindUnreachable, Ticks: 3}}}
+	e := alert.NewEvaluator("wg0", cfg, rec, zerolog.New(io.Discard))
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		e.UsageFailed(ctx, &ingest.StageError{Stage: ingest.StageStore, Err: errors.New("connection refused")}, now)
+	}
+	e.UsageFailed(ctx, errors.New("no such device"), now)
+	e.UsageFailed(ctx, &ingest.StageError{Stage: ingest.StageDevice, Err: errors.New("no such device")}, now)
+	e.UsageIngested(ctx, nil, now)
+	e.UsageFailed(ctx, errors.New("no such device"), now)
+	e.UsageFailed(ctx, errors.New("no such device"), now)
+	require.Empty(t, rec.alerts)
+
+	e.UsageFailed(ctx, errors.New("no such device"), now)
+	e.UsageFailed(ctx, errors.New("no such device"), now)
+	require.Len(t, rec.alerts, 1)
+	require.Equal(t, uint(3), rec.alerts[0].Value)
+	require.Empty(t, rec.alerts[0].PublicKey)
+}
+
+func TestEvaluatorUnreachableWhileWaitingForInterface(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rec := &recorder{}
+	cfg := alert.Config{Rules: []alert.Rule{{Name: "down", Kind: alert.KindUnreachable, Ticks: 3}}}
+	e := alert.NewEvaluator("wg0", cfg, rec, zerolog.New(io.Discard))
+	var _ ingest.WaitObserver = e
+
+	now := time.Now()
+	e.UsageWaiting(ctx, now)
+	e.UsageWaiting(ctx, now.Add(time.Second))
+	require.Empty(t, rec.alerts)
+
+	e.UsageWaiting(ctx, now.Add(2*time.Second))
+	e.UsageWaiting(ctx, now.Add(3*time.Second))
+	require.Len(t, rec.alerts, 1)
+	require.Equal(t, uint(3), rec.alerts[0].Value)
+	require.Equal(t, now.Add(2*time.Second), rec.alerts[0].At)
+
+	// Waiting ticks and failed reads of the interface add up.
+	e.UsageIngested(ctx, nil, now.Add(4*time.Second))
+	e.UsageWaiting(ctx, now.Add(5*time.Second))
+	e.UsageFailed(ctx, errors.New("no such device"), now.Add(6*time.Second))
+	e.UsageWaiting(ctx, now.Add(7*time.Second))
+	require.Len(t, rec.alerts, 2)
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	cfg := alert.Config{
+		Webhooks: []alert.WebhookConfig{{}},
+		Rules: []alert.Rule{
+			{Name: "a", Kind: alert.KindQuota},
+			{Name: "b", Kind: "unknown"},
+			{Name: "c", Kind: alert.KindUnreachable, Ticks: 1},
+			{Name: "c", Kind: alert.KindUnreachable, Ticks: 2},
+		},
+	}
+	err := cfg.Validate()
+	require.ErrorContains(t, err, `rule "a": percent must be greater than zero`)
+	require.ErrorContains(t, err, `rule "b": unknown kind "unknown"`)
+	require.ErrorContains(t, err, `rule "c": duplicate rule name`)
+	require.ErrorContains(t, err, "webhook #0: url cannot be empty")
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	received := make(chan alert.Alert, 1)
+	keys := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		keys <- r.Header.Get("Idempotency-Key")
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var a alert.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); nil != err {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- a
+	}))
+	defer srv.Close()
+
+	wh := alert.NewWebhook(alert.WebhookConfig{URL: srv.URL, Backoff: alert.Duration(time.Millisecond)}, srv.Client(), zerolog.New(io.Discard))
+	go wh.Run(ctx)
+
+	wh.Notify(ctx, alert.Alert{ID: "wg0/down//1", Rule: "down", Kind: alert.KindUnreachable, Interface: "wg0", Value: 3, Threshold: 3})
+
+	select {
+	case a := <-received:
+		require.Equal(t, "wg0/down//1", a.ID)
+		require.Equal(t, uint(3), a.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert was not delivered")
+	}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "wg0/down//1", <-keys)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan struct{}, 5)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh := alert.NewWebhook(alert.WebhookConfig{URL: srv.URL, Backoff: alert.Duration(time.Millisecond)}, srv.Client(), zerolog.New(io.Discard))
+	go wh.Run(ctx)
+	wh.Notify(ctx, alert.Alert{ID: "1"})
+	wh.Notify(ctx, alert.Alert{ID: "2"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("alert was not delivered")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, requests)
+}
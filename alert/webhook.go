@@ -0,0 +1,133 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// MaxAttempts is the total number of delivery attempts per alert. Defaults to 5.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff is the delay before the first retry, doubled on each subsequent retry. Defaults to 1s.
+	Backoff Duration `json:"backoff,omitempty"`
+}
+
+// Webhook delivers alerts as JSON POST requests. Alerts are queued by Notify and sent
+// by Run, so a slow receiver never blocks an engine tick.
+type Webhook struct {
+	url         string
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+	queue       chan Alert
+	logger      zerolog.Logger
+}
+
+func NewWebhook(cfg WebhookConfig, client *http.Client, logger zerolog.Logger) *Webhook {
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	backoff := time.Duration(cfg.Backoff)
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	return &Webhook{
+		url:         cfg.URL,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		client:      client,
+		queue:       make(chan Alert, 256),
+		logger:      logger.With().Str("webhook", cfg.URL).Logger(),
+	}
+}
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) {
+	select {
+	case w.queue <- a:
+	default:
+		w.logger.Error().Str("alert_id", a.ID).Msg("webhook queue is full, dropping alert")
+	}
+}
+
+// Run delivers queued alerts until ctx is canceled.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-w.queue:
+			if err := w.deliver(ctx, a); nil != err {
+				w.logger.Error().Err(err).Str("alert_id", a.ID).Msg("failed to deliver alert")
+			}
+		}
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if nil != err {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := w.post(ctx, a.ID, body)
+		if nil == err {
+			return nil
+		}
+		if !retryable || attempt >= w.maxAttempts {
+			return fmt.Errorf("giving up after %d attempt(s): %w", attempt, err)
+		}
+		w.logger.Warn().Err(err).Str("alert_id", a.ID).Int("attempt", attempt).Msg("alert delivery failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, id string, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if nil != err {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", id)
+
+	res, err := w.client.Do(req)
+	if nil != err {
+		return true, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected response status: %s", res.Status)
+	default:
+		return false, fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+}
+
+// Notifiers fans alerts out to multiple notifiers.
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(ctx context.Context, a Alert) {
+	for _, v := range n {
+		v.Notify(ctx, a)
+	}
+}
@@ -0,0 +1,205 @@
+package anomaly
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+const (
+	KindSpike     = "spike"
+	KindSustained = "sustained"
+
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+type Config struct {
+	// Alpha is the EWMA smoothing factor in (0, 1]. Higher values adapt to new traffic levels faster.
+	Alpha float64
+	// SpikeSigma is how many standard deviations above the baseline a single tick's rate must be to be a spike.
+	SpikeSigma float64
+	// SustainedSigma and SustainedTicks flag rates staying that many standard deviations above
+	// the baseline for that many consecutive ticks.
+	SustainedSigma float64
+	SustainedTicks int
+	// Warmup is the number of samples a peer's baseline needs before it is evaluated.
+	Warmup int
+	// MinRate is the rate, in bytes per second, below which nothing is flagged, which keeps idle peers quiet.
+	MinRate float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Alpha:          0.05,
+		SpikeSigma:     6,
+		SustainedSigma: 3,
+		SustainedTicks: 12,
+		Warmup:         30,
+		MinRate:        128 * 1024,
+	}
+}
+
+// WithSigma returns c with SpikeSigma set to sigma, and SustainedSigma scaled along with it, in the
+// same proportion as the defaults, so that lower values flag more of both kinds.
+func (c Config) WithSigma(sigma float64) Config {
+	defaults := DefaultConfig()
+	c.SustainedSigma = sigma * defaults.SustainedSigma / defaults.SpikeSigma
+	c.SpikeSigma = sigma
+	return c
+}
+
+type Event struct {
+	Interface string    `json:"interface" bson:"interface"`
+	PublicKey string    `json:"publicKey" bson:"publicKey"`
+	Kind      string    `json:"kind" bson:"kind"`
+	Direction string    `json:"direction" bson:"direction"`
+	Rate      float64   `json:"rate" bson:"rate"`
+	Mean      float64   `json:"mean" bson:"mean"`
+	StdDev    float64   `json:"stdDev" bson:"stdDev"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+type Store interface {
+	InsertAnomalies(ctx context.Context, events []Event) error
+}
+
+// baseline is an exponentially weighted moving mean and variance of a rate.
+type baseline struct {
+	mean      float64
+	variance  float64
+	samples   int
+	sustained int
+}
+
+func (b *baseline) add(x, alpha float64) {
+	if b.samples == 0 {
+		b.mean = x
+	} else {
+		diff := x - b.mean
+		incr := alpha * diff
+		b.mean += incr
+		b.variance = (1 - alpha) * (b.variance + diff*incr)
+	}
+	b.samples++
+}
+
+type peerState struct {
+	upload   uint
+	download uint
+	at       time.Time
+	rates    [2]baseline
+}
+
+// Detector keeps a rolling baseline of each peer's upload and download rates from the deltas
+// between engine ticks, and records spikes and sustained abnormal rates as events.
+type Detector struct {
+	interfaceName string
+	cfg           Config
+	store         Store
+	logger        zerolog.Logger
+
+	mu    sync.Mutex
+	peers map[string]*peerState
+}
+
+func NewDetector(interfaceName string, cfg Config, store Store, logger zerolog.Logger) *Detector {
+	return &Detector{
+		interfaceName: interfaceName,
+		cfg:           cfg,
+		store:         store,
+		logger:        logger,
+		peers:         make(map[string]*peerState),
+	}
+}
+
+func (d *Detector) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var events []Event
+	seen := make(map[string]struct{}, len(peersUsage))
+	for _, p := range peersUsage {
+		seen[p.PublicKey] = struct{}{}
+		state, exists := d.peers[p.PublicKey]
+		if !exists {
+			d.peers[p.PublicKey] = &peerState{upload: p.Upload, download: p.Download, at: gatheredAt}
+			continue
+		}
+
+		elapsed := gatheredAt.Sub(state.at).Seconds()
+		// Counters going backwards mean an uncompensated reset, which is not traffic.
+		if elapsed > 0 && p.Upload >= state.upload && p.Download >= state.download {
+			rates := [2]float64{float64(p.Upload-state.upload) / elapsed, float64(p.Download-state.download) / elapsed}
+			for i, direction := range [2]string{DirectionUpload, DirectionDownload} {
+				if e := d.evaluate(&state.rates[i], rates[i]); nil != e {
+					e.Interface, e.PublicKey, e.Direction, e.At = d.interfaceName, p.PublicKey, direction, gatheredAt
+					events = append(events, *e)
+				}
+			}
+		}
+		state.upload, state.download, state.at = p.Upload, p.Download, gatheredAt
+	}
+	for k := range d.peers {
+		if _, exists := seen[k]; !exists {
+			delete(d.peers, k)
+		}
+	}
+
+	if len(events) == 0 {
+		return
+	}
+	for _, e := range events {
+		d.logger.Warn().
+			Str("public_key", e.PublicKey).
+			Str("kind", e.Kind).
+			Str("direction", e.Direction).
+			Float64("rate", e.Rate).
+			Float64("mean", e.Mean).
+			Float64("std_dev", e.StdDev).
+			Msg("abnormal peer traffic detected")
+	}
+	if err := d.store.InsertAnomalies(ctx, events); nil != err {
+		d.logger.Error().Err(err).Msg("failed to store traffic anomaly events")
+	}
+}
+
+func (d *Detector) UsageFailed(ctx context.Context, err error, failedAt time.Time) {}
+
+func (d *Detector) evaluate(b *baseline, rate float64) *Event {
+	if b.samples < d.cfg.Warmup {
+		b.add(rate, d.cfg.Alpha)
+		return nil
+	}
+
+	stdDev := math.Sqrt(b.variance)
+	if rate < d.cfg.MinRate || rate <= b.mean+d.cfg.SustainedSigma*stdDev {
+		b.sustained = 0
+		b.add(rate, d.cfg.Alpha)
+		return nil
+	}
+
+	// Abnormal rates are kept out of the baseline until they are reported as sustained,
+	// from then on the baseline adapts to the new traffic level.
+	e := &Event{Rate: rate, Mean: b.mean, StdDev: stdDev}
+	b.sustained++
+	if b.sustained >= d.cfg.SustainedTicks {
+		b.add(rate, d.cfg.Alpha)
+	}
+
+	switch {
+	case b.sustained == d.cfg.SustainedTicks:
+		e.Kind = KindSustained
+		return e
+	case b.sustained < d.cfg.SustainedTicks && rate > e.Mean+d.cfg.SpikeSigma*stdDev:
+		e.Kind = KindSpike
+		return e
+	default:
+		return nil
+	}
+}
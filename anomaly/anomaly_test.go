@@ -0,0 +1,152 @@
+package anomaly_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/anomaly"
+	"github.com/xeptore/wireuse/ingest"
+)
+
+type memoryStore struct {
+	events []anomaly.Event
+}
+
+func (m *memoryStore) InsertAnomalies(ctx context.Context, events []anomaly.Event) error {
+	m.events = append(m.events, events...)
+	return nil
+}
+
+type feeder struct {
+	d        *anomaly.Detector
+	at       time.Time
+	upload   uint
+	download uint
+}
+
+// tick advances the peer's counters by the given per-second rates over a 5 seconds tick.
+func (f *feeder) tick(uploadRate, downloadRate uint) {
+	f.at = f.at.Add(5 * time.Second)
+	f.upload += uploadRate * 5
+	f.download += downloadRate * 5
+	f.d.UsageIngested(context.Background(), []ingest.PeerUsage{{Upload: f.upload, Download: f.download, PublicKey: "xyz"}}, f.at)
+}
+
+func newFeeder(store anomaly.Store, cfg anomaly.Config) *feeder {
+	return &feeder{
+		d:  anomaly.NewDetector("wg0", cfg, store, zerolog.New(io.Discard)),
+		at: time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestDetectorSpike(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	f := newFeeder(store, anomaly.DefaultConfig())
+	for i := 0; i < 100; i++ {
+		f.tick(200_000+uint(i%5)*10_000, 1_000_000+uint(i%3)*50_000)
+	}
+	require.Empty(t, store.events)
+
+	f.tick(50_000_000, 1_000_000)
+	require.Len(t, store.events, 1)
+	e := store.events[0]
+	require.Equal(t, anomaly.KindSpike, e.Kind)
+	require.Equal(t, anomaly.DirectionUpload, e.Direction)
+	require.Equal(t, "xyz", e.PublicKey)
+	require.Equal(t, "wg0", e.Interface)
+	require.InDelta(t, 50_000_000, e.Rate, 1)
+	require.InDelta(t, 220_000, e.Mean, 20_000)
+
+	f.tick(200_000, 1_000_000)
+	require.Len(t, store.events, 1)
+}
+
+func TestDetectorSustained(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	cfg := anomaly.DefaultConfig()
+	cfg.SustainedTicks = 4
+	f := newFeeder(store, cfg)
+	for i := 0; i < 100; i++ {
+		f.tick(200_000+uint(i%5)*10_000, 0)
+	}
+
+	for i := 0; i < 4; i++ {
+		f.tick(285_000, 0)
+	}
+	require.Len(t, store.events, 1)
+	require.Equal(t, anomaly.KindSustained, store.events[0].Kind)
+
+	f.tick(285_000, 0)
+	require.Len(t, store.events, 1, "expected sustained anomaly to be reported once")
+}
+
+func TestDetectorWarmupAndIdle(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	f := newFeeder(store, anomaly.DefaultConfig())
+	for i := 0; i < 10; i++ {
+		f.tick(1_000, 0)
+	}
+	f.tick(10_000_000, 0)
+	require.Empty(t, store.events, "expected no events during warmup")
+
+	f = newFeeder(store, anomaly.DefaultConfig())
+	for i := 0; i < 100; i++ {
+		f.tick(10, 0)
+	}
+	f.tick(100_000, 0)
+	require.Empty(t, store.events, "expected rates below the minimum not to be flagged")
+}
+
+func TestDetectorIgnoresCounterResets(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	f := newFeeder(store, anomaly.DefaultConfig())
+	for i := 0; i < 100; i++ {
+		f.tick(200_000+uint(i%5)*10_000, 0)
+	}
+	f.upload = 0
+	f.tick(200_000, 0)
+	f.tick(200_000, 0)
+	require.Empty(t, store.events)
+}
+
+func TestDetectorLowerSigmaFlagsMore(t *testing.T) {
+	t.Parallel()
+
+	flagged := func(cfg anomaly.Config) []string {
+		store := &memoryStore{}
+		cfg.SustainedTicks = 4
+		f := newFeeder(store, cfg)
+		for i := 0; i < 100; i++ {
+			f.tick(200_000+uint(i%5)*10_000, 0)
+		}
+		f.tick(285_000, 0)
+		f.tick(220_000, 0)
+		for i := 0; i < 4; i++ {
+			f.tick(255_000, 0)
+		}
+		kinds := make([]string, 0, len(store.events))
+		for _, e := range store.events {
+			kinds = append(kinds, e.Kind)
+		}
+		return kinds
+	}
+
+	require.Equal(t, anomaly.DefaultConfig(), anomaly.DefaultConfig().WithSigma(6))
+	require.Empty(t, flagged(anomaly.DefaultConfig()))
+	kinds := flagged(anomaly.DefaultConfig().WithSigma(3))
+	require.Contains(t, kinds, anomaly.KindSpike)
+	require.Contains(t, kinds, anomaly.KindSustained)
+}
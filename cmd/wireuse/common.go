@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+
+	"github.com/xeptore/wireuse/health"
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/pkg/env"
+	"github.com/xeptore/wireuse/store"
+)
+
+// configFlags are the flags shared by the commands that need the configuration.
+type configFlags struct {
+	fs       *flag.FlagSet
+	fileName string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *configFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	cf := &configFlags{fs: fs}
+	fs.StringVar(&cf.fileName, "c", "", "config file name (optional, flags and environment variables override its values)")
+	return fs, cf
+}
+
+// parse parses args, and rejects non-flag arguments.
+func (cf *configFlags) parse(log zerolog.Logger, args []string) {
+	if err := cf.fs.Parse(args); nil != err {
+		log.Fatal().Err(err).Msg("failed to parse flags")
+	}
+	if nonFlagArgs := cf.fs.Args(); len(nonFlagArgs) > 0 {
+		log.Fatal().Msgf("expected no additional flags, got: %s", strings.Join(nonFlagArgs, ","))
+	}
+}
+
+func (cf *configFlags) isSet(name string) bool {
+	set := false
+	cf.fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// load loads the config file, if any, and overrides it with the environment variables, and
+// then with the flags applied by override. The result is not validated.
+func (cf *configFlags) load(override func(cfg *ingest.Config) error) (*ingest.Config, error) {
+	cfg := ingest.DefaultConfig()
+	if cf.fileName != "" {
+		loaded, err := ingest.LoadConfig(cf.fileName)
+		if nil != err {
+			return nil, err
+		}
+		cfg = *loaded
+	}
+	if err := cfg.ApplyEnv(env.DefaultResolver().Lookup); nil != err {
+		return nil, fmt.Errorf("invalid config environment variables: %w", err)
+	}
+	if nil != override {
+		if err := override(&cfg); nil != err {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// mustLoadDatabaseConfig loads the config of commands only accessing the database.
+func (cf *configFlags) mustLoadDatabaseConfig(log zerolog.Logger) *ingest.Config {
+	cfg, err := cf.load(nil)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+	if err := cfg.MongoDB.Validate(); nil != err {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	return cfg
+}
+
+// selectInterface returns the configured interface named name, or the only configured
+// interface if name is empty. Unconfigured interfaces are returned with only their name set.
+func selectInterface(cfg *ingest.Config, name string) (ingest.InterfaceConfig, error) {
+	if name == "" {
+		if len(cfg.Interfaces) != 1 {
+			return ingest.InterfaceConfig{}, fmt.Errorf("interface option is required when %d interfaces are configured", len(cfg.Interfaces))
+		}
+		return cfg.Interfaces[0], nil
+	}
+	for _, iface := range cfg.Interfaces {
+		if iface.Name == name {
+			return iface, nil
+		}
+	}
+	return ingest.InterfaceConfig{Name: name}, nil
+}
+
+func configureLogger(log zerolog.Logger, cfg ingest.LoggingConfig, out *os.File) zerolog.Logger {
+	level, _ := zerolog.ParseLevel(cfg.Level)
+	if cfg.Format == ingest.LogFormatConsole {
+		log = log.Output(zerolog.ConsoleWriter{Out: out})
+	}
+	return log.Level(level)
+}
+
+// connectMongo connects to the database and verifies its connectivity. The returned function
+// disconnects from the database.
+func connectMongo(ctx context.Context, cfg ingest.MongoDBConfig, log zerolog.Logger) (*mongo.Database, func(), error) {
+	client, err := mongo.Connect(
+		ctx,
+		options.Client().
+			ApplyURI(cfg.URI).
+			SetMaxConnIdleTime(cfg.MaxConnIdleTime).
+			SetMaxConnecting(cfg.MaxConnecting).
+			SetServerSelectionTimeout(cfg.ServerSelectionTimeout).
+			SetSocketTimeout(cfg.SocketTimeout).
+			SetRetryReads(cfg.Retries.Reads).
+			SetRetryWrites(cfg.Retries.Writes),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	disconnect := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Disconnect(ctx); err != nil {
+			log.Err(err).Msg("failed to disconnect from database")
+			return
+		}
+		log.Debug().Msg("successfully disconnected from database")
+	}
+	if err := client.Ping(ctx, readpref.Primary()); nil != err {
+		disconnect()
+		return nil, nil, fmt.Errorf("failed to verify database connectivity: %w", err)
+	}
+	cs, _ := connstring.Parse(cfg.URI)
+	return client.Database(cs.Database), disconnect, nil
+}
+
+// mongoReadiness returns the readiness check of the connection to db.
+func mongoReadiness(db *mongo.Database) health.Check {
+	return health.Check{Name: "mongodb", Func: func(ctx context.Context) error { return db.Client().Ping(ctx, readpref.Primary()) }}
+}
+
+// usageHost returns the host dimension of the shared layout usage.
+func usageHost(cfg ingest.MongoDBConfig) string {
+	if cfg.Host != "" {
+		return cfg.Host
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// sharedHost returns the host scoping the documents of hosts sharing the database in the shared
+// layout, or an empty string in the per-interface layout.
+func sharedHost(cfg ingest.MongoDBConfig) string {
+	if cfg.Layout != ingest.UsageLayoutShared {
+		return ""
+	}
+	return usageHost(cfg)
+}
+
+// usageStore returns the store of interfaceName usage in the configured layout.
+func usageStore(db *mongo.Database, cfg ingest.MongoDBConfig, interfaceName string) *store.Mongo {
+	var usage *store.Mongo
+	if cfg.Layout == ingest.UsageLayoutShared {
+		usage = store.NewSharedMongo(db.Collection(store.UsageCollectionName), usageHost(cfg), interfaceName)
+	} else {
+		usage = store.NewMongo(db.Collection(interfaceName))
+	}
+	if cfg.IngestBatchSize > 0 && cfg.IngestConcurrency > 0 {
+		usage.SetIngestBatches(cfg.IngestBatchSize, cfg.IngestConcurrency)
+	}
+	if cfg.Encoding == ingest.SampleEncodingDelta {
+		usage.SetDeltaEncoding(cfg.BucketSize)
+	}
+	return usage
+}
+
+// migrateSchema applies the pending schema migrations to the usage of the configured interfaces,
+// waiting for other processes migrating the same database.
+func migrateSchema(ctx context.Context, db *mongo.Database, cfg *ingest.Config, log zerolog.Logger) error {
+	usage := make([]*store.Mongo, 0, len(cfg.Interfaces))
+	for _, iface := range cfg.Interfaces {
+		usage = append(usage, usageStore(db, cfg.MongoDB, iface.Name))
+	}
+	migrator := store.NewMigrator(db, store.Migrations, usage, log)
+	applied, err := migrator.Migrate(ctx)
+	if nil != err {
+		return err
+	}
+	if len(applied) > 0 {
+		log.Info().Ints("versions", applied).Msg("successfully applied schema migrations")
+	}
+	return nil
+}
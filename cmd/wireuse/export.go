@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+)
+
+func runExport(log zerolog.Logger, args []string) {
+	ctx := context.Background()
+
+	fs, cf := newFlagSet("export")
+	var (
+		wgDeviceName string
+		month        string
+		from         string
+		to           string
+		format       string
+	)
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface (optional if only one interface is configured)")
+	fs.StringVar(&month, "m", "", "month to export in YYYY-MM format (defaults to the previous month, ignored if -from and -to are set)")
+	fs.StringVar(&from, "from", "", "inclusive start of the period in YYYY-MM-DD or RFC 3339 format (requires -to)")
+	fs.StringVar(&to, "to", "", "exclusive end of the period in YYYY-MM-DD or RFC 3339 format (requires -from)")
+	fs.StringVar(&format, "format", exportFormatCSV, "output format: csv or json")
+	cf.parse(log, args)
+	if format != exportFormatCSV && format != exportFormatJSON {
+		log.Fatal().Str("format", format).Msg("format option must be either csv or json")
+	}
+
+	var (
+		periodFrom, periodTo time.Time
+		err                  error
+	)
+	if from != "" || to != "" {
+		periodFrom, periodTo, err = parsePeriod(from, to)
+	} else {
+		periodFrom, periodTo, err = statementPeriod(month, time.Now())
+	}
+	if nil != err {
+		log.Fatal().Err(err).Msg("invalid export period")
+	}
+
+	cfg := cf.mustLoadDatabaseConfig(log)
+	iface, err := selectInterface(cfg, wgDeviceName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to select interface")
+	}
+
+	db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer disconnect()
+
+	usage, err := usageStore(db, cfg.MongoDB, iface.Name).UsageBetween(ctx, periodFrom, periodTo)
+	if nil != err {
+		log.Error().Err(err).Msg("failed to query peers usage")
+		return
+	}
+	if err := writeUsage(os.Stdout, format, usage); nil != err {
+		log.Error().Err(err).Msg("failed to write peers usage")
+		return
+	}
+	log.Debug().Int("peers", len(usage)).Time("from", periodFrom).Time("to", periodTo).Msg("exported peers usage")
+}
+
+// parsePeriod parses the [from, to) period bounds, each being either a date or an RFC 3339 time.
+func parsePeriod(from, to string) (time.Time, time.Time, error) {
+	if from == "" || to == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("both period bounds must be set")
+	}
+	parse := func(v string) (time.Time, error) {
+		if t, err := time.Parse("2006-01-02", v); nil == err {
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+	f, err := parse(from)
+	if nil != err {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period start: %w", err)
+	}
+	t, err := parse(to)
+	if nil != err {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period end: %w", err)
+	}
+	if !f.Before(t) {
+		return time.Time{}, time.Time{}, fmt.Errorf("period start must be before its end")
+	}
+	return f, t, nil
+}
+
+type exportedUsage struct {
+	PublicKey string `json:"publicKey"`
+	Upload    uint   `json:"upload"`
+	Download  uint   `json:"download"`
+}
+
+func writeUsage(w io.Writer, format string, usage []ingest.PeerUsage) error {
+	if format == exportFormatJSON {
+		out := make([]exportedUsage, 0, len(usage))
+		for _, u := range usage {
+			out = append(out, exportedUsage{PublicKey: u.PublicKey, Upload: u.Upload, Download: u.Download})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"public_key", "upload", "download"}); nil != err {
+		return err
+	}
+	for _, u := range usage {
+		if err := cw.Write([]string{u.PublicKey, strconv.FormatUint(uint64(u.Upload), 10), strconv.FormatUint(uint64(u.Download), 10)}); nil != err {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"golang.zx2c4.com/wireguard/wgctrl"
+
+	"github.com/xeptore/wireuse/anomaly"
+	"github.com/xeptore/wireuse/health"
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/live"
+	"github.com/xeptore/wireuse/pkg/env"
+)
+
+func runIngest(log zerolog.Logger, args []string) {
+	fs, cf := newFlagSet("ingest")
+	var (
+		restartMarkFileName string
+		wgDeviceName        string
+		alertRulesFileName  string
+		mailConfigFileName  string
+		trackPresence       bool
+		trackRoaming        bool
+		geoIPFileNames      string
+		detectAnomalies     bool
+		anomalySigma        float64
+		healthAddr          string
+		metricsAddr         string
+		liveAddr            string
+	)
+	fs.StringVar(&restartMarkFileName, "r", "", "restart-mark file name of the interface selected with -i, or of the only configured one")
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface to ingest, instead of all the configured ones")
+	fs.StringVar(&alertRulesFileName, "a", "", "alert rules file name (optional)")
+	fs.BoolVar(&trackPresence, "presence", false, "track peers online state and record their sessions")
+	fs.BoolVar(&trackRoaming, "roaming", false, "record peers endpoint changes")
+	fs.StringVar(&geoIPFileNames, "geoip", "", "comma-separated MaxMind-format database file names used to enrich endpoint changes (optional, requires -roaming)")
+	fs.BoolVar(&detectAnomalies, "anomaly", false, "detect abnormal peers traffic")
+	fs.Float64Var(&anomalySigma, "anomaly-sigma", anomaly.DefaultConfig().SpikeSigma, "standard deviations above a peer's baseline rate that are flagged as a traffic spike, while rates staying above half of it are flagged as sustained")
+	fs.StringVar(&mailConfigFileName, "m", "", "mail config file name for quota warnings to peer owners (optional, requires -a)")
+	fs.StringVar(&healthAddr, "health-addr", "", "address to serve /healthz and /readyz endpoints on (optional)")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "address to serve prometheus /metrics endpoint on (optional)")
+	fs.StringVar(&liveAddr, "live-addr", "", "address to serve live usage updates on /live/events and /live/ws endpoints (optional)")
+	cf.parse(log, args)
+
+	// loadConfig loads the config, overridden by the flags that are set.
+	loadConfig := func() (*ingest.Config, error) {
+		cfg, err := cf.load(func(cfg *ingest.Config) error {
+			if cf.isSet("i") || cf.isSet("r") {
+				// -i selects one of the configured interfaces, keeping its settings, and -r requires
+				// it unless a single interface is configured.
+				iface, err := selectInterface(cfg, wgDeviceName)
+				if nil != err {
+					return err
+				}
+				if cf.isSet("r") {
+					iface.RestartMarkFile = restartMarkFileName
+				}
+				cfg.Interfaces = []ingest.InterfaceConfig{iface}
+			}
+			if cf.isSet("a") {
+				cfg.Sinks.Alerts = alertRulesFileName
+			}
+			if cf.isSet("m") {
+				cfg.Sinks.Mail = mailConfigFileName
+			}
+			if cf.isSet("presence") {
+				cfg.Sinks.Presence = trackPresence
+			}
+			if cf.isSet("roaming") {
+				cfg.Sinks.Roaming.Enabled = trackRoaming
+			}
+			if cf.isSet("geoip") {
+				cfg.Sinks.Roaming.GeoIP = strings.Split(geoIPFileNames, ",")
+			}
+			if cf.isSet("anomaly") {
+				cfg.Sinks.Anomaly.Enabled = detectAnomalies
+			}
+			if cf.isSet("anomaly-sigma") {
+				cfg.Sinks.Anomaly.Sigma = anomalySigma
+			}
+			if cf.isSet("health-addr") {
+				cfg.HTTP.HealthAddr = healthAddr
+			}
+			if cf.isSet("metrics-addr") {
+				cfg.HTTP.MetricsAddr = metricsAddr
+			}
+			if cf.isSet("live-addr") {
+				cfg.HTTP.LiveAddr = liveAddr
+			}
+			return nil
+		})
+		if nil != err {
+			return nil, err
+		}
+		if err := cfg.Validate(); nil != err {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		return cfg, nil
+	}
+
+	cfg, err := loadConfig()
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+	log = configureLogger(log, cfg.Logging, os.Stdout)
+
+	// failed makes the process exit with a non-zero status, after the deferred calls stopping the
+	// engines and disconnecting from the database, so that supervisors restart it.
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
+	ctx := context.Background()
+	db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer func() { disconnect() }()
+
+	if err := migrateSchema(ctx, db, cfg, log); nil != err {
+		log.Fatal().Err(err).Msg("failed to migrate database schema")
+	}
+
+	wg, err := wgctrl.New()
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize wg control client")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopSignalErr := errors.New("stop signal received")
+
+	var promRegistry *prometheus.Registry
+	if cfg.HTTP.MetricsAddr != "" {
+		promRegistry = prometheus.NewRegistry()
+		promRegistry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+	var hub *live.Hub
+	if cfg.HTTP.LiveAddr != "" {
+		hub = live.NewHub(log)
+	}
+	r := newRunner(cancel, mongoStores(db, cfg.MongoDB), wg, promRegistry, cfg.HTTP.HealthAddr != "", hub, mongoReadiness(db), log)
+	if err := r.apply(*cfg); nil != err {
+		log.Fatal().Err(err).Msg("failed to start ingesting")
+	}
+	defer r.shutdown()
+
+	if nil != promRegistry {
+		serve(cfg.HTTP.MetricsAddr, "/metrics", promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{}), log)
+	}
+	if cfg.HTTP.HealthAddr != "" {
+		serve(cfg.HTTP.HealthAddr, "/", health.Handler(r.livenessChecks, r.readinessChecks, 3*time.Second), log)
+	}
+	if nil != hub {
+		serve(cfg.HTTP.LiveAddr, "/live/", live.Handler(hub, log), log)
+	}
+
+	reload := func() {
+		log.Info().Msg("reloading config")
+		cfg, err := loadConfig()
+		if nil != err {
+			log.Error().Err(err).Msg("failed to reload config, keeping the current one")
+			return
+		}
+		if err := r.apply(*cfg); nil != err {
+			log.Error().Err(err).Msg("failed to apply reloaded config, keeping the current one")
+			return
+		}
+		log.Info().Msg("config reloaded")
+	}
+
+	// reconnect connects to the database with uri, e.g., after the MONGODB_URI secret is rotated,
+	// and moves the engines and sinks over to the new connection before closing the current one.
+	// Other mongodb config changes are still only applied after a restart.
+	reconnect := func(uri string) {
+		mongoCfg := cfg.MongoDB
+		mongoCfg.URI = uri
+		newDB, newDisconnect, err := connectMongo(ctx, mongoCfg, log)
+		if nil != err {
+			log.Error().Err(err).Msg("failed to connect to database with the rotated secret, keeping the current connection")
+			return
+		}
+		if err := r.reconnect(mongoCfg, mongoStores(newDB, mongoCfg), mongoReadiness(newDB)); nil != err {
+			newDisconnect()
+			cancel(fmt.Errorf("failed to restart ingesting with the new database connection: %w", err))
+			return
+		}
+		disconnect()
+		disconnect = newDisconnect
+		log.Info().Msg("reconnected to database with the rotated secret")
+	}
+
+	rotations := make(chan string, 1)
+	go env.DefaultResolver().Watch(ctx, "MONGODB_URI", time.Minute, func(uri string) {
+		// Only the latest value matters if the previous one is still pending.
+		select {
+		case <-rotations:
+		default:
+		}
+		rotations <- uri
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for nil == ctx.Err() {
+		select {
+		case <-ctx.Done():
+		case uri := <-rotations:
+			log.Info().Msg("MONGODB_URI secret changed")
+			reconnect(uri)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			cancel(stopSignalErr)
+		}
+	}
+
+	if errors.Is(context.Cause(ctx), stopSignalErr) {
+		log.Info().Msg("root context was canceled due to receiving a stop signal")
+		return
+	}
+	log.Error().Err(context.Cause(ctx)).Msg("root context was canceled due to unexpected cause")
+	failed = true
+}
+
+func serve(addr, pattern string, handler http.Handler, log zerolog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); nil != err && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Str("addr", addr).Msg("failed to serve http endpoints")
+		}
+	}()
+	log.Info().Str("addr", addr).Str("pattern", pattern).Msg("serving http endpoints")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+)
+
+type command struct {
+	summary string
+	// daemon commands log to stdout, the rest log to stderr to keep stdout for their output.
+	daemon bool
+	run    func(log zerolog.Logger, args []string)
+}
+
+var commands = map[string]command{
+	"ingest":       {summary: "periodically ingest peers usage of the configured interfaces", daemon: true, run: runIngest},
+	"serve":        {summary: "serve the peer provisioning and sessions http api", daemon: true, run: runServe},
+	"report":       {summary: "send usage statements or expiry reminders to peer owners", run: runReport},
+	"mark-restart": {summary: "mark an interface as restarted, so its usage is carried over its counters reset", run: runMarkRestart},
+	"migrate":      {summary: "apply pending database schema migrations", run: runMigrate},
+	"export":       {summary: "export peers usage of a period as csv or json", run: runExport},
+	"peers":        {summary: "list the provisioned peers", run: runPeers},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, exists := commands[name]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	out := os.Stderr
+	if cmd.daemon {
+		out = os.Stdout
+	}
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMicro
+	log := zerolog.New(out).With().Timestamp().Str("command", name).Logger()
+
+	if err := godotenv.Load(); nil != err {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Fatal().Err(err).Msg("unexpected error while loading .env file")
+		}
+		log.Warn().Msg(".env file not found")
+	}
+
+	// Usage is aggregated on calendar boundaries, which must not depend on the host time zone.
+	time.Local = time.UTC
+
+	cmd.run(log, os.Args[2:])
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Usage: wireuse <command> [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-14s %s\n", name, commands[name].summary)
+	}
+	b.WriteString("\nRun 'wireuse <command> -h' for the flags of a command.\n")
+	io.WriteString(w, b.String())
+}
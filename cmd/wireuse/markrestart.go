@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+func runMarkRestart(log zerolog.Logger, args []string) {
+	fs, cf := newFlagSet("mark-restart")
+	var (
+		restartMarkFileName string
+		wgDeviceName        string
+		reason              string
+	)
+	fs.StringVar(&restartMarkFileName, "r", "", "restart-mark file name (defaults to restartMarkFile of the configured interface)")
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface (optional if only one interface is configured)")
+	fs.StringVar(&reason, "reason", "", "reason of the restart recorded in the mark, e.g., wg-quick")
+	cf.parse(log, args)
+
+	interfaceName := wgDeviceName
+	if restartMarkFileName == "" {
+		cfg, err := cf.load(nil)
+		if nil != err {
+			log.Fatal().Err(err).Msg("failed to load config")
+		}
+		iface, err := selectInterface(cfg, wgDeviceName)
+		if nil != err {
+			log.Fatal().Err(err).Msg("failed to select interface")
+		}
+		if iface.RestartMarkFile == "" {
+			log.Fatal().Str("interface", iface.Name).Msg("restart-mark file name option is required when the interface has no restartMarkFile configured")
+		}
+		restartMarkFileName = iface.RestartMarkFile
+		interfaceName = iface.Name
+	}
+
+	mark := ingest.RestartMark{Interface: interfaceName, At: time.Now().UTC(), Reason: reason}
+	if err := ingest.WriteRestartMark(restartMarkFileName, mark); nil != err {
+		log.Fatal().Err(err).Msg("failed to write restart-mark file")
+	}
+	log.Info().Str("file_name", restartMarkFileName).Str("interface", interfaceName).Str("reason", reason).Msg("marked interface as restarted")
+}
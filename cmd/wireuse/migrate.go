@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/store"
+)
+
+func runMigrate(log zerolog.Logger, args []string) {
+	ctx := context.Background()
+
+	fs, cf := newFlagSet("migrate")
+	var (
+		status   bool
+		toShared bool
+		compact  bool
+	)
+	fs.BoolVar(&status, "status", false, "only report the schema version instead of applying pending migrations")
+	fs.BoolVar(&toShared, "to-shared", false, "also copy the usage of the configured interfaces from their per-interface collections into the shared layout collection")
+	fs.BoolVar(&compact, "compact", false, "also pack the older usage samples of the configured interfaces into delta-encoded buckets (requires mongodb.encoding to be delta)")
+	cf.parse(log, args)
+
+	cfg := cf.mustLoadDatabaseConfig(log)
+	if toShared && len(cfg.Interfaces) == 0 {
+		log.Fatal().Msg("interfaces must be configured to copy their usage into the shared layout collection")
+	}
+	if compact && cfg.MongoDB.Encoding != ingest.SampleEncodingDelta {
+		log.Fatal().Msgf("mongodb.encoding must be %s to compact usage samples", ingest.SampleEncodingDelta)
+	}
+
+	db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer disconnect()
+
+	if status {
+		migrator := store.NewMigrator(db, store.Migrations, nil, log)
+		version, err := migrator.Version(ctx)
+		if nil != err {
+			log.Fatal().Err(err).Msg("failed to get schema version")
+		}
+		log.Info().Int("version", version).Int("latest", migrator.Latest()).Bool("pending", version < migrator.Latest()).Msg("database schema version")
+		return
+	}
+
+	if err := migrateSchema(ctx, db, cfg, log); nil != err {
+		log.Fatal().Err(err).Msg("failed to migrate database schema")
+	}
+	log.Info().Msg("database schema is up to date")
+
+	if toShared {
+		interfaces := make([]string, 0, len(cfg.Interfaces))
+		for _, iface := range cfg.Interfaces {
+			interfaces = append(interfaces, iface.Name)
+		}
+		host := usageHost(cfg.MongoDB)
+		if err := store.NewMigrator(db, store.Migrations, nil, log).ConvertToShared(ctx, host, interfaces); nil != err {
+			log.Fatal().Err(err).Msg("failed to copy usage into the shared layout collection")
+		}
+		log.Info().Str("host", host).Msgf("usage copied into the shared layout collection, set mongodb.layout to %s to use it", ingest.UsageLayoutShared)
+	}
+
+	if compact {
+		for _, iface := range cfg.Interfaces {
+			n, err := usageStore(db, cfg.MongoDB, iface.Name).Compact(ctx)
+			if nil != err {
+				log.Fatal().Err(err).Str("interface", iface.Name).Msg("failed to compact usage samples")
+			}
+			log.Info().Str("interface", iface.Name).Int("peers", n).Msg("compacted usage samples")
+		}
+	}
+}
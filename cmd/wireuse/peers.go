@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/store"
+)
+
+func runPeers(log zerolog.Logger, args []string) {
+	ctx := context.Background()
+
+	fs, cf := newFlagSet("peers")
+	var wgDeviceName string
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface (optional if only one interface is configured)")
+	cf.parse(log, args)
+
+	cfg := cf.mustLoadDatabaseConfig(log)
+	iface, err := selectInterface(cfg, wgDeviceName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to select interface")
+	}
+
+	db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer disconnect()
+
+	peers, err := store.NewMongoPeers(db.Collection(store.PeersCollectionName)).ListPeers(ctx, iface.Name)
+	if nil != err {
+		log.Error().Err(err).Msg("failed to list peers")
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(peers); nil != err {
+		log.Error().Err(err).Msg("failed to write peers")
+	}
+}
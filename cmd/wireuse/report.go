@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/mail"
+)
+
+func runReport(log zerolog.Logger, args []string) {
+	ctx := context.Background()
+
+	fs, cf := newFlagSet("report")
+	var (
+		mailConfigFileName string
+		wgDeviceName       string
+		kind               string
+		month              string
+		dryRun             bool
+	)
+	fs.StringVar(&mailConfigFileName, "mail", "", "mail config file name (defaults to sinks.mail of the config)")
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface (optional if only one interface is configured)")
+	fs.StringVar(&kind, "k", "", "kind of messages to send: statement or expiry")
+	fs.StringVar(&month, "m", "", "statement month in YYYY-MM format (defaults to the previous month)")
+	fs.BoolVar(&dryRun, "dry-run", false, "render messages to stdout instead of sending them")
+	cf.parse(log, args)
+
+	cfg, err := cf.load(nil)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+	if mailConfigFileName == "" {
+		mailConfigFileName = cfg.Sinks.Mail
+	}
+	if mailConfigFileName == "" {
+		log.Fatal().Msg("mail config file name option is required when sinks.mail is not configured")
+	}
+	iface, err := selectInterface(cfg, wgDeviceName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to select interface")
+	}
+	log = log.With().Str("interface", iface.Name).Logger()
+
+	mailCfg, err := mail.LoadConfig(mailConfigFileName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to load mail config")
+	}
+
+	var sender mail.Sender = mail.NewSMTPSender(mailCfg.SMTP)
+	if dryRun {
+		sender = mail.NewDryRunSender(os.Stdout)
+	}
+	mailer, err := mail.NewMailer(iface.Name, *mailCfg, sender, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize mailer")
+	}
+
+	switch kind {
+	case mail.KindExpiry:
+		if err := mailer.SendExpiryReminders(ctx, time.Now()); nil != err {
+			log.Fatal().Err(err).Msg("failed to send expiry reminders")
+		}
+	case mail.KindStatement:
+		from, to, err := statementPeriod(month, time.Now())
+		if nil != err {
+			log.Fatal().Err(err).Msg("invalid statement month")
+		}
+
+		if err := cfg.MongoDB.Validate(); nil != err {
+			log.Fatal().Err(err).Msg("invalid config")
+		}
+		db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+		if nil != err {
+			log.Fatal().Err(err).Msg("failed to initialize database")
+		}
+		defer disconnect()
+
+		if err := mailer.SendStatements(ctx, usageStore(db, cfg.MongoDB, iface.Name), from, to); nil != err {
+			log.Error().Err(err).Msg("failed to send usage statements")
+			return
+		}
+	default:
+		log.Fatal().Str("kind", kind).Msg("kind option must be either statement or expiry")
+	}
+}
+
+// statementPeriod returns the [from, to) period of month, or of the month before now if month
+// is empty.
+func statementPeriod(month string, now time.Time) (time.Time, time.Time, error) {
+	if month == "" {
+		to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return to.AddDate(0, -1, 0), to, nil
+	}
+	from, err := time.Parse("2006-01", month)
+	if nil != err {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
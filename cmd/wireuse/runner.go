@@ -0,0 +1,641 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/xeptore/wireuse/alert"
+	"github.com/xeptore/wireuse/anomaly"
+	"github.com/xeptore/wireuse/health"
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/linkwatch"
+	"github.com/xeptore/wireuse/live"
+	"github.com/xeptore/wireuse/mail"
+	"github.com/xeptore/wireuse/markwatch"
+	"github.com/xeptore/wireuse/metrics"
+	"github.com/xeptore/wireuse/presence"
+	"github.com/xeptore/wireuse/roaming"
+	"github.com/xeptore/wireuse/store"
+	"github.com/xeptore/wireuse/wgdevice"
+)
+
+// runner runs an engine per configured interface, and applies configuration changes to the
+// running engines without restarting the ones whose interface is still configured.
+type runner struct {
+	// ctx is used for I/O, and is only canceled when shutdown takes longer than its timeout.
+	ctx          context.Context
+	abort        context.CancelFunc
+	cancel       context.CancelCauseFunc
+	stores       runnerStores
+	wg           wgdevice.Client
+	promRegistry *prometheus.Registry
+	withHealth   bool
+	live         *live.Hub
+	log          zerolog.Logger
+
+	mu          sync.Mutex
+	cfg         ingest.Config
+	sinks       *sinks
+	instances   map[string]*instance
+	liveness    []health.Check
+	readiness   []health.Check
+	dbReadiness health.Check
+	// links and marks are started once an interface watches its link or restart-mark file.
+	links *linkwatch.Watcher
+	marks *markwatch.Watcher
+}
+
+// runnerStores are the stores of the interfaces and their sinks, which are backed by MongoDB
+// outside tests.
+type runnerStores struct {
+	// usage returns the usage store of an interface, in the layout configured on start.
+	usage     func(interfaceName string) interfaceUsage
+	sessions  presence.Store
+	endpoints roaming.Store
+	anomalies anomaly.Store
+}
+
+// interfaceUsage is the usage store of an interface, which is a *store.Mongo outside tests.
+type interfaceUsage interface {
+	ingest.Store
+	EnsureIndexes(ctx context.Context) ([]string, error)
+}
+
+// mongoStores returns the stores of the interfaces and their sinks kept in db with cfg.
+func mongoStores(db *mongo.Database, cfg ingest.MongoDBConfig) runnerStores {
+	return runnerStores{
+		usage:     func(interfaceName string) interfaceUsage { return usageStore(db, cfg, interfaceName) },
+		sessions:  store.NewMongoSessions(db.Collection(store.SessionsCollectionName), sharedHost(cfg)),
+		endpoints: store.NewMongoEndpoints(db.Collection(store.EndpointsCollectionName), sharedHost(cfg)),
+		anomalies: store.NewMongoAnomalies(db.Collection(store.AnomaliesCollectionName), sharedHost(cfg)),
+	}
+}
+
+// sinks holds the resources shared by the observers of all interfaces.
+type sinks struct {
+	cfg      ingest.SinksConfig
+	alerting *alerting
+	geoIP    *roaming.MaxMind
+}
+
+type alerting struct {
+	rules     *alert.Config
+	mail      *mail.Config
+	notifiers alert.Notifiers
+	// ctx is canceled by stop, stopping the notifiers deliveries.
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+type instance struct {
+	iface     ingest.InterfaceConfig
+	interval  time.Duration
+	engine    *ingest.Engine
+	marks     *restartMarks
+	ticker    *time.Ticker
+	stop      context.CancelFunc
+	done      chan struct{}
+	flush     bool
+	metrics   *metrics.Prometheus
+	progress  *health.Progress
+	observers observers
+}
+
+// newRunner creates a runner that calls cancel when an engine stops due to an unrecoverable error.
+func newRunner(cancel context.CancelCauseFunc, stores runnerStores, wg wgdevice.Client, promRegistry *prometheus.Registry, withHealth bool, hub *live.Hub, dbReadiness health.Check, log zerolog.Logger) *runner {
+	ctx, abort := context.WithCancel(context.Background())
+	return &runner{
+		ctx:          ctx,
+		abort:        abort,
+		cancel:       cancel,
+		stores:       stores,
+		wg:           wg,
+		promRegistry: promRegistry,
+		withHealth:   withHealth,
+		live:         hub,
+		dbReadiness:  dbReadiness,
+		log:          log,
+		instances:    make(map[string]*instance),
+	}
+}
+
+// apply starts, stops and updates engines to match cfg. Changes to sinks are applied from the
+// next tick of each engine. When it fails, the previously applied configuration stays in effect.
+func (r *runner) apply(cfg ingest.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(cfg)
+}
+
+// reconnect moves the engines and sinks over to stores, which are kept in the database cfg points
+// to, e.g., after its credentials are rotated. Each engine ingests a final sample into its current
+// store, and is started again with the new one, resuming from its baselines. dbReadiness checks the
+// new connection. When it fails, no engine is running.
+func (r *runner) reconnect(cfg ingest.MongoDBConfig, stores runnerStores, dbReadiness health.Check) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, inst := range r.instances {
+		r.stopInstance(inst, true)
+		delete(r.instances, name)
+	}
+	r.stores = stores
+	r.dbReadiness = dbReadiness
+	r.cfg.MongoDB = cfg
+	return r.update(r.cfg)
+}
+
+// update applies cfg while r.mu is held.
+func (r *runner) update(cfg ingest.Config) error {
+	if nil != r.sinks {
+		if !reflect.DeepEqual(r.cfg.MongoDB, cfg.MongoDB) || r.cfg.HTTP != cfg.HTTP || r.cfg.Logging != cfg.Logging {
+			r.log.Warn().Msg("mongodb, http and logging config changes are only applied after a restart")
+		}
+	}
+
+	newSinks, err := r.openSinks(cfg.Sinks)
+	if nil != err {
+		return err
+	}
+
+	type plan struct {
+		inst      *instance
+		iface     ingest.InterfaceConfig
+		isNew     bool
+		observers observers
+	}
+	plans := make([]plan, 0, len(cfg.Interfaces))
+	fail := func(err error) error {
+		for _, p := range plans {
+			if p.isNew && nil != p.inst.metrics {
+				p.inst.metrics.Unregister()
+			}
+		}
+		newSinks.close(r.sinks)
+		return err
+	}
+	for _, iface := range cfg.Interfaces {
+		if iface.WatchLink {
+			if err := r.watchLinks(); nil != err {
+				return fail(err)
+			}
+		}
+		if iface.WatchRestartMarkFile {
+			if err := r.watchMarks(); nil != err {
+				return fail(err)
+			}
+		}
+		inst, exists := r.instances[iface.Name]
+		if !exists {
+			inst, err = r.newInstance(iface)
+			if nil != err {
+				return fail(fmt.Errorf("failed to initialize interface %s: %w", iface.Name, err))
+			}
+		}
+		plans = append(plans, plan{inst: inst, iface: iface, isNew: !exists})
+		obs, err := r.observers(inst, newSinks)
+		if nil != err {
+			return fail(fmt.Errorf("failed to initialize sinks of interface %s: %w", iface.Name, err))
+		}
+		plans[len(plans)-1].observers = obs
+	}
+
+	current := make(map[string]struct{}, len(cfg.Interfaces))
+	for _, iface := range cfg.Interfaces {
+		current[iface.Name] = struct{}{}
+	}
+	for name, inst := range r.instances {
+		if _, exists := current[name]; !exists {
+			r.stopInstance(inst, true)
+			delete(r.instances, name)
+			r.log.Info().Str("interface", name).Msg("stopped ingesting interface")
+		}
+	}
+	for _, p := range plans {
+		inst := p.inst
+		inst.observers = p.observers
+		inst.engine.SetObservers(inst.observers.list(inst.progress)...)
+		switch {
+		case p.isNew:
+			r.instances[p.iface.Name] = inst
+			inst.interval = cfg.Polling.Interval
+			inst.ticker = time.NewTicker(inst.interval)
+			r.start(inst)
+			r.log.Info().Str("interface", p.iface.Name).Msg("started ingesting interface")
+		case inst.iface != p.iface:
+			// Only the restart-mark file name, and link and restart-mark file watching can differ.
+			// The engine keeps its restart compensation state across runs, so restarting its run
+			// loses nothing.
+			inst.stop()
+			<-inst.done
+			if inst.iface.WatchLink != p.iface.WatchLink {
+				inst.engine.WatchLink(r.link(p.iface))
+			}
+			if nil != inst.marks.watched {
+				inst.marks.watched.Close()
+			}
+			inst.marks.watched = r.watchedMarks(p.iface, inst.engine)
+			inst.iface = p.iface
+			r.start(inst)
+		}
+		if inst.interval != cfg.Polling.Interval {
+			inst.interval = cfg.Polling.Interval
+			inst.ticker.Reset(inst.interval)
+		}
+	}
+
+	if nil != r.sinks {
+		r.sinks.close(newSinks)
+	}
+	r.sinks = newSinks
+	r.cfg = cfg
+	r.updateChecks()
+	return nil
+}
+
+// openSinks loads sink resources for cfg, reusing the current ones that did not change.
+func (r *runner) openSinks(cfg ingest.SinksConfig) (*sinks, error) {
+	s := &sinks{cfg: cfg}
+	if cfg.Alerts != "" {
+		a := &alerting{}
+		rules, err := alert.LoadConfig(cfg.Alerts)
+		if nil != err {
+			return nil, fmt.Errorf("failed to load alert rules: %w", err)
+		}
+		a.rules = rules
+		if cfg.Mail != "" {
+			mailCfg, err := mail.LoadConfig(cfg.Mail)
+			if nil != err {
+				return nil, fmt.Errorf("failed to load mail config: %w", err)
+			}
+			a.mail = mailCfg
+		}
+
+		if nil != r.sinks && nil != r.sinks.alerting && reflect.DeepEqual(r.sinks.alerting.rules, a.rules) && reflect.DeepEqual(r.sinks.alerting.mail, a.mail) {
+			s.alerting = r.sinks.alerting
+		} else {
+			ctx, stop := context.WithCancel(r.ctx)
+			httpClient := &http.Client{Timeout: 10 * time.Second}
+			for _, whCfg := range a.rules.Webhooks {
+				wh := alert.NewWebhook(whCfg, httpClient, r.log)
+				go wh.Run(ctx)
+				a.notifiers = append(a.notifiers, wh)
+			}
+			a.ctx, a.stop = ctx, stop
+			s.alerting = a
+			r.log.Info().Int("rules", len(a.rules.Rules)).Int("webhooks", len(a.rules.Webhooks)).Msg("alert rules loaded")
+		}
+	}
+
+	if nil != r.sinks && reflect.DeepEqual(r.sinks.cfg.Roaming.GeoIP, cfg.Roaming.GeoIP) {
+		s.geoIP = r.sinks.geoIP
+	} else if len(cfg.Roaming.GeoIP) > 0 {
+		geoIP, err := roaming.OpenMaxMind(cfg.Roaming.GeoIP...)
+		if nil != err {
+			s.close(r.sinks)
+			return nil, fmt.Errorf("failed to open geoip databases: %w", err)
+		}
+		s.geoIP = geoIP
+	}
+	return s, nil
+}
+
+// close releases the resources of s that are not reused by next.
+func (s *sinks) close(next *sinks) {
+	if nil == next {
+		next = &sinks{}
+	}
+	if nil != s.alerting && s.alerting != next.alerting {
+		s.alerting.stop()
+	}
+	if nil != s.geoIP && s.geoIP != next.geoIP {
+		s.geoIP.Close()
+	}
+}
+
+func (r *runner) newInstance(iface ingest.InterfaceConfig) (*instance, error) {
+	ctx := r.ctx
+	log := r.log.With().Str("interface", iface.Name).Logger()
+
+	// Migrations only index the usage collections of the interfaces configured on start, so the
+	// ones added on reload are indexed here.
+	usage := r.stores.usage(iface.Name)
+	names, err := usage.EnsureIndexes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database indexes: %w", err)
+	}
+	log.Info().Strs("index_names", names).Msg("successfully inserted database indexes")
+
+	marks := &restartMarks{file: ingest.NewRestartMarkFile(iface.Name, log)}
+	engine := ingest.NewEngine(marks, wgdevice.NewPeers(r.wg, iface.Name), usage, log)
+	marks.watched = r.watchedMarks(iface, &engine)
+	engine.WatchLink(r.link(iface))
+	inst := &instance{iface: iface, engine: &engine, marks: marks}
+	if m, ok := usage.(*store.Mongo); ok && m.BucketSize() > 0 {
+		inst.observers.compactor = store.NewCompactor(m, log)
+	}
+	if nil != r.promRegistry {
+		m, err := metrics.NewPrometheus(iface.Name, r.promRegistry)
+		if nil != err {
+			return nil, fmt.Errorf("failed to register engine metrics: %w", err)
+		}
+		engine.Instrument(m)
+		inst.metrics = m
+	}
+	if r.withHealth {
+		inst.progress = health.NewProgress(time.Now(), time.Now)
+	}
+	return inst, nil
+}
+
+// watchLinks starts the link watcher unless it is already running. It stops the process if the
+// watcher fails, as the engines watching their links would otherwise miss interface restarts.
+func (r *runner) watchLinks() error {
+	if nil != r.links {
+		return nil
+	}
+	w, err := linkwatch.NewWatcher(r.log)
+	if nil != err {
+		return fmt.Errorf("failed to watch links: %w", err)
+	}
+	go func() {
+		if err := w.Run(r.ctx); nil != err && nil == r.ctx.Err() {
+			r.cancel(fmt.Errorf("link watcher stopped: %w", err))
+		}
+	}()
+	r.links = w
+	return nil
+}
+
+// link returns the link of iface for its engine to watch, or nil if it does not watch its link.
+func (r *runner) link(iface ingest.InterfaceConfig) ingest.Link {
+	if !iface.WatchLink {
+		return nil
+	}
+	return r.links.Link(iface.Name)
+}
+
+// watchMarks starts the restart-mark file watcher unless it is already running. It stops the
+// process if the watcher fails, as the engines reading their marks from it would miss them.
+func (r *runner) watchMarks() error {
+	if nil != r.marks {
+		return nil
+	}
+	w, err := markwatch.NewWatcher(r.log)
+	if nil != err {
+		return fmt.Errorf("failed to watch restart-mark files: %w", err)
+	}
+	go func() {
+		if err := w.Run(r.ctx); nil != err && nil == r.ctx.Err() {
+			r.cancel(fmt.Errorf("restart-mark file watcher stopped: %w", err))
+		}
+	}()
+	r.marks = w
+	return nil
+}
+
+// watchedMarks returns the restart marks of iface read from the watcher, which pushes them to
+// engine as soon as they are written, or nil if it does not watch its restart-mark file.
+func (r *runner) watchedMarks(iface ingest.InterfaceConfig, engine *ingest.Engine) *markwatch.Marks {
+	if !iface.WatchRestartMarkFile {
+		return nil
+	}
+	return r.marks.Marks(iface.Name, engine.MarkRestarted, r.log.With().Str("interface", iface.Name).Logger())
+}
+
+// restartMarks reads the restart marks of an interface from its file on every tick, or from the
+// restart-mark file watcher if watched is set, which is only changed while its engine is stopped.
+type restartMarks struct {
+	file    *ingest.RestartMarkFile
+	watched *markwatch.Marks
+}
+
+func (m *restartMarks) Read(filename string) ([1]byte, error) {
+	if nil != m.watched {
+		return m.watched.Read(filename)
+	}
+	return m.file.Read(filename)
+}
+
+func (m *restartMarks) Remove(filename string) error {
+	if nil != m.watched {
+		return m.watched.Remove(filename)
+	}
+	return m.file.Remove(filename)
+}
+
+// observers returns the observers of the enabled sinks for inst, reusing the current ones, along
+// with their in-memory state, whose configuration did not change.
+func (r *runner) observers(inst *instance, s *sinks) (observers, error) {
+	ctx := r.ctx
+	name := inst.iface.Name
+	log := r.log.With().Str("interface", name).Logger()
+	prev := r.sinks
+	if nil == prev {
+		prev = &sinks{}
+	}
+
+	out := inst.observers
+	if nil != r.live && nil == out.live {
+		out.live = r.live.Observer(name)
+	}
+	if !s.cfg.Presence {
+		out.presence = nil
+	} else if nil == out.presence {
+		tracker := presence.NewTracker(name, presence.DefaultOnlineThreshold, r.stores.sessions, log)
+		if err := tracker.Load(ctx); nil != err {
+			return observers{}, fmt.Errorf("failed to resume peer sessions: %w", err)
+		}
+		out.presence = tracker
+	}
+	if !s.cfg.Roaming.Enabled {
+		out.roaming = nil
+	} else if nil == out.roaming || s.geoIP != prev.geoIP {
+		var enricher roaming.Enricher
+		if nil != s.geoIP {
+			enricher = s.geoIP
+		}
+		tracker := roaming.NewTracker(name, enricher, r.stores.endpoints, log)
+		if err := tracker.Load(ctx); nil != err {
+			return observers{}, fmt.Errorf("failed to load peers last endpoints: %w", err)
+		}
+		out.roaming = tracker
+	}
+	if !s.cfg.Anomaly.Enabled {
+		out.anomaly = nil
+	} else if nil == out.anomaly || s.cfg.Anomaly != prev.cfg.Anomaly {
+		anomalyCfg := anomaly.DefaultConfig().WithSigma(s.cfg.Anomaly.Sigma)
+		out.anomaly = anomaly.NewDetector(name, anomalyCfg, r.stores.anomalies, log)
+	}
+	if nil == s.alerting {
+		out.alerts = nil
+	} else if nil == out.alerts || s.alerting != prev.alerting {
+		a := s.alerting
+		notifiers := a.notifiers[:len(a.notifiers):len(a.notifiers)]
+		if nil != a.mail {
+			mailer, err := mail.NewMailer(name, *a.mail, mail.NewSMTPSender(a.mail.SMTP), log)
+			if nil != err {
+				return observers{}, fmt.Errorf("failed to initialize mailer: %w", err)
+			}
+			go mailer.Run(a.ctx)
+			notifiers = append(notifiers, mailer)
+		}
+		out.alerts = alert.NewEvaluator(name, *a.rules, notifiers, log)
+	}
+	return out, nil
+}
+
+// observers are the sink observers of an interface.
+type observers struct {
+	presence  *presence.Tracker
+	roaming   *roaming.Tracker
+	anomaly   *anomaly.Detector
+	alerts    *alert.Evaluator
+	live      ingest.Observer
+	compactor *store.Compactor
+}
+
+func (o observers) list(progress *health.Progress) []ingest.Observer {
+	var out []ingest.Observer
+	if nil != progress {
+		out = append(out, progress)
+	}
+	if nil != o.presence {
+		out = append(out, o.presence)
+	}
+	if nil != o.roaming {
+		out = append(out, o.roaming)
+	}
+	if nil != o.anomaly {
+		out = append(out, o.anomaly)
+	}
+	if nil != o.alerts {
+		out = append(out, o.alerts)
+	}
+	if nil != o.live {
+		out = append(out, o.live)
+	}
+	if nil != o.compactor {
+		out = append(out, o.compactor)
+	}
+	return out
+}
+
+// start runs inst's engine until inst.stop is called, after which the in-flight tick is allowed
+// to finish, and a final sample is ingested if inst.flush is set.
+func (r *runner) start(inst *instance) {
+	ctx, stop := context.WithCancel(r.ctx)
+	inst.stop = stop
+	inst.flush = false
+	inst.done = make(chan struct{})
+	go func(iface ingest.InterfaceConfig, done chan<- struct{}) {
+		defer close(done)
+		log := r.log.With().Str("interface", iface.Name).Logger()
+		if err := inst.engine.Run(r.ctx, inst.engine.Ticks(ctx, inst.ticker.C), iface.RestartMarkFile); nil != err {
+			if nil == r.ctx.Err() {
+				r.cancel(fmt.Errorf("engine of interface %s stopped: %w", iface.Name, err))
+			}
+			return
+		}
+		if inst.flush {
+			if err := inst.engine.Flush(r.ctx, iface.RestartMarkFile); nil != err {
+				log.Error().Err(err).Msg("failed to ingest final peers usage sample")
+				return
+			}
+			log.Info().Msg("ingested final peers usage sample")
+		}
+	}(inst.iface, inst.done)
+}
+
+// stopInstance stops inst's engine, after ingesting a final sample if flush is set.
+func (r *runner) stopInstance(inst *instance, flush bool) {
+	inst.flush = flush
+	inst.stop()
+	<-inst.done
+	inst.ticker.Stop()
+	if nil != inst.metrics {
+		inst.metrics.Unregister()
+	}
+	if nil != inst.marks.watched {
+		inst.marks.watched.Close()
+	}
+}
+
+// shutdown stops all engines after ingesting their final samples, and releases the sinks
+// resources. In-flight I/O is canceled when it takes longer than the configured timeout.
+func (r *runner) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.abort()
+
+	timer := time.AfterFunc(r.cfg.Shutdown.Timeout, func() {
+		r.log.Warn().Msg("shutdown timeout exceeded, canceling in-flight operations")
+		r.abort()
+	})
+	defer timer.Stop()
+
+	var wg sync.WaitGroup
+	for name, inst := range r.instances {
+		wg.Add(1)
+		go func(inst *instance) {
+			defer wg.Done()
+			r.stopInstance(inst, true)
+		}(inst)
+		delete(r.instances, name)
+	}
+	wg.Wait()
+	if nil != r.sinks {
+		r.sinks.close(nil)
+		r.sinks = nil
+	}
+}
+
+func (r *runner) updateChecks() {
+	if !r.withHealth {
+		return
+	}
+	// Allow a few failed or slow ticks before reporting the engine as stuck.
+	maxAge := 3 * r.cfg.Polling.Interval
+	if maxAge < time.Minute {
+		maxAge = time.Minute
+	}
+	r.liveness = r.liveness[:0:0]
+	r.readiness = []health.Check{r.dbReadiness}
+	for _, iface := range r.cfg.Interfaces {
+		inst := r.instances[iface.Name]
+		deviceName := iface.Name
+		r.liveness = append(r.liveness, named(deviceName, inst.progress.TickCheck(maxAge)))
+		r.readiness = append(r.readiness,
+			named(deviceName, health.Check{Name: "device", Func: func(ctx context.Context) error {
+				_, err := r.wg.Device(deviceName)
+				return err
+			}}),
+			named(deviceName, inst.progress.IngestCheck(maxAge)),
+		)
+	}
+}
+
+func (r *runner) livenessChecks() []health.Check {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.liveness
+}
+
+func (r *runner) readinessChecks() []health.Check {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readiness
+}
+
+func named(interfaceName string, c health.Check) health.Check {
+	c.Name = interfaceName + "/" + c.Name
+	return c
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/xeptore/wireuse/anomaly"
+	"github.com/xeptore/wireuse/health"
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/presence"
+	"github.com/xeptore/wireuse/roaming"
+	"github.com/xeptore/wireuse/store"
+)
+
+type fakeUsage struct {
+	*store.Memory
+	ingested atomic.Int32
+}
+
+func (u *fakeUsage) EnsureIndexes(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (u *fakeUsage) IngestUsage(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) error {
+	u.ingested.Add(1)
+	return u.Memory.IngestUsage(ctx, peersUsage, gatheredAt)
+}
+
+type fakeSinkStores struct{}
+
+func (fakeSinkStores) OpenSessions(ctx context.Context, interfaceName string) ([]presence.Session, error) {
+	return nil, nil
+}
+
+func (fakeSinkStores) StartSessions(ctx context.Context, sessions []presence.Session) error {
+	return nil
+}
+
+func (fakeSinkStores) EndSessions(ctx context.Context, sessions []presence.Session) error {
+	return nil
+}
+
+func (fakeSinkStores) LastEndpoints(ctx context.Context, interfaceName string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (fakeSinkStores) InsertEndpointEvents(ctx context.Context, events []roaming.Event) error {
+	return nil
+}
+
+func (fakeSinkStores) InsertAnomalies(ctx context.Context, events []anomaly.Event) error {
+	return nil
+}
+
+type fakeDevices struct {
+	peer wgtypes.Key
+}
+
+func (d fakeDevices) Device(name string) (*wgtypes.Device, error) {
+	return &wgtypes.Device{Name: name, Peers: []wgtypes.Peer{{PublicKey: d.peer, TransmitBytes: 10, ReceiveBytes: 20}}}, nil
+}
+
+// newTestRunner returns a runner of fake devices and stores, along with the usage store of each
+// interface it ingested.
+func newTestRunner(t *testing.T) (*runner, func(interfaceName string) *fakeUsage) {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	usage := make(map[string]*fakeUsage)
+	sinkStores := fakeSinkStores{}
+	stores := runnerStores{
+		usage: func(interfaceName string) interfaceUsage {
+			mu.Lock()
+			defer mu.Unlock()
+			u := &fakeUsage{Memory: store.NewMemory()}
+			usage[interfaceName] = u
+			return u
+		},
+		sessions:  sinkStores,
+		endpoints: sinkStores,
+		anomalies: sinkStores,
+	}
+	dbReadiness := health.Check{Name: "mongodb", Func: func(ctx context.Context) error { return nil }}
+	cancel := func(err error) { t.Errorf("runner stopped: %v", err) }
+	r := newRunner(cancel, stores, fakeDevices{peer: key.PublicKey()}, prometheus.NewRegistry(), true, nil, dbReadiness, zerolog.Nop())
+	return r, func(interfaceName string) *fakeUsage {
+		mu.Lock()
+		defer mu.Unlock()
+		return usage[interfaceName]
+	}
+}
+
+func testConfig(sinks ingest.SinksConfig, interfaces ...string) ingest.Config {
+	cfg := ingest.DefaultConfig()
+	cfg.Polling.Interval = time.Hour
+	cfg.Sinks = sinks
+	for _, name := range interfaces {
+		cfg.Interfaces = append(cfg.Interfaces, ingest.InterfaceConfig{Name: name})
+	}
+	return cfg
+}
+
+func TestRunnerApply(t *testing.T) {
+	t.Parallel()
+
+	alertsFile := filepath.Join(t.TempDir(), "alerts.json")
+	require.NoError(t, os.WriteFile(alertsFile, []byte(`{"rules":[{"name":"down","kind":"unreachable","ticks":3}]}`), 0o644))
+	allSinks := ingest.SinksConfig{
+		Alerts:   alertsFile,
+		Presence: true,
+		Roaming:  ingest.RoamingConfig{Enabled: true},
+		Anomaly:  ingest.AnomalyConfig{Enabled: true, Sigma: 6},
+	}
+	renamedMarkFile := testConfig(ingest.SinksConfig{}, "wg0")
+	renamedMarkFile.Interfaces[0].RestartMarkFile = filepath.Join(t.TempDir(), "wg0.restart")
+	fasterPolling := testConfig(ingest.SinksConfig{}, "wg0")
+	fasterPolling.Polling.Interval = time.Minute
+	changedSigma := allSinks
+	changedSigma.Anomaly.Sigma = 4
+	missingAlerts := allSinks
+	missingAlerts.Alerts = filepath.Join(t.TempDir(), "missing.json")
+
+	// before are the instances and their observers as of the first config.
+	type before struct {
+		instances map[string]*instance
+		observers map[string]observers
+		sinks     *sinks
+	}
+	tests := []struct {
+		name  string
+		from  ingest.Config
+		to    ingest.Config
+		err   string
+		check func(t *testing.T, r *runner, before before, usage func(interfaceName string) *fakeUsage)
+	}{
+		{
+			name: "adds interface",
+			from: testConfig(ingest.SinksConfig{}, "wg0"),
+			to:   testConfig(ingest.SinksConfig{}, "wg0", "wg1"),
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Len(t, r.instances, 2)
+				require.Same(t, before.instances["wg0"], r.instances["wg0"])
+				require.Equal(t, "wg1", r.instances["wg1"].iface.Name)
+				require.Len(t, r.liveness, 2)
+				require.Len(t, r.readiness, 5)
+			},
+		},
+		{
+			name: "removes interface",
+			from: testConfig(ingest.SinksConfig{}, "wg0", "wg1"),
+			to:   testConfig(ingest.SinksConfig{}, "wg0"),
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Len(t, r.instances, 1)
+				require.Same(t, before.instances["wg0"], r.instances["wg0"])
+				require.Positive(t, usage("wg1").ingested.Load(), "a final sample of removed interfaces must be ingested")
+				require.Len(t, r.liveness, 1)
+				// Adding it back registers its metrics again, which fails unless they were unregistered.
+				require.NoError(t, r.apply(testConfig(ingest.SinksConfig{}, "wg0", "wg1")))
+				require.NotSame(t, before.instances["wg1"], r.instances["wg1"])
+			},
+		},
+		{
+			name: "changes interface restart-mark file",
+			from: testConfig(ingest.SinksConfig{}, "wg0"),
+			to:   renamedMarkFile,
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Same(t, before.instances["wg0"], r.instances["wg0"])
+				require.Equal(t, renamedMarkFile.Interfaces[0], r.instances["wg0"].iface)
+			},
+		},
+		{
+			name: "changes polling interval",
+			from: testConfig(ingest.SinksConfig{}, "wg0"),
+			to:   fasterPolling,
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Same(t, before.instances["wg0"], r.instances["wg0"])
+				require.Equal(t, time.Minute, r.instances["wg0"].interval)
+			},
+		},
+		{
+			name: "adds sinks",
+			from: testConfig(ingest.SinksConfig{}, "wg0"),
+			to:   testConfig(allSinks, "wg0"),
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				obs := r.instances["wg0"].observers
+				require.NotNil(t, obs.presence)
+				require.NotNil(t, obs.roaming)
+				require.NotNil(t, obs.anomaly)
+				require.NotNil(t, obs.alerts)
+				require.NotNil(t, r.sinks.alerting)
+			},
+		},
+		{
+			name: "removes sinks",
+			from: testConfig(allSinks, "wg0"),
+			to:   testConfig(ingest.SinksConfig{}, "wg0"),
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Equal(t, observers{}, r.instances["wg0"].observers)
+				require.Nil(t, r.sinks.alerting)
+				require.Error(t, before.sinks.alerting.ctx.Err(), "removed alerting must be stopped")
+			},
+		},
+		{
+			name: "changes sinks",
+			from: testConfig(allSinks, "wg0"),
+			to:   testConfig(changedSigma, "wg0"),
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				obs, prev := r.instances["wg0"].observers, before.observers["wg0"]
+				require.NotSame(t, prev.anomaly, obs.anomaly)
+				require.Same(t, prev.presence, obs.presence)
+				require.Same(t, prev.roaming, obs.roaming)
+				require.Same(t, prev.alerts, obs.alerts)
+				require.Same(t, before.sinks.alerting, r.sinks.alerting)
+				require.NoError(t, r.sinks.alerting.ctx.Err())
+			},
+		},
+		{
+			name: "keeps the applied config on failure",
+			from: testConfig(allSinks, "wg0"),
+			to:   testConfig(missingAlerts, "wg0", "wg1"),
+			err:  "failed to load alert rules",
+			check: func(t *testing.T, r *runner, before before, usage func(string) *fakeUsage) {
+				require.Equal(t, testConfig(allSinks, "wg0"), r.cfg)
+				require.Len(t, r.instances, 1)
+				require.Equal(t, before.observers["wg0"], r.instances["wg0"].observers)
+				require.Same(t, before.sinks, r.sinks)
+				require.NoError(t, r.sinks.alerting.ctx.Err())
+			},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, usage := newTestRunner(t)
+			require.NoError(t, r.apply(tc.from))
+			defer r.shutdown()
+			b := before{instances: make(map[string]*instance), observers: make(map[string]observers), sinks: r.sinks}
+			for name, inst := range r.instances {
+				b.instances[name] = inst
+				b.observers[name] = inst.observers
+			}
+
+			err := r.apply(tc.to)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.to, r.cfg)
+			}
+			tc.check(t, r, b, usage)
+		})
+	}
+}
+
+func TestRunnerReconnect(t *testing.T) {
+	t.Parallel()
+
+	r, usage := newTestRunner(t)
+	require.NoError(t, r.apply(testConfig(ingest.SinksConfig{Presence: true}, "wg0")))
+	defer r.shutdown()
+	prevUsage, prevInst := usage("wg0"), r.instances["wg0"]
+
+	cfg := r.cfg.MongoDB
+	cfg.URI = "mongodb://rotated@localhost:27017/wireuse"
+	require.NoError(t, r.reconnect(cfg, r.stores, r.dbReadiness))
+	require.Positive(t, prevUsage.ingested.Load(), "a final sample must be ingested into the previous store")
+	require.NotSame(t, prevUsage, usage("wg0"))
+	inst := r.instances["wg0"]
+	require.NotSame(t, prevInst, inst)
+	require.NotSame(t, prevInst.observers.presence, inst.observers.presence)
+	require.Equal(t, cfg, r.cfg.MongoDB)
+	require.Len(t, r.liveness, 1)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.zx2c4.com/wireguard/wgctrl"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/live"
+	"github.com/xeptore/wireuse/presence"
+	"github.com/xeptore/wireuse/provision"
+	"github.com/xeptore/wireuse/store"
+)
+
+func runServe(log zerolog.Logger, args []string) {
+	ctx := context.Background()
+
+	fs, cf := newFlagSet("serve")
+	var (
+		provisionConfigFileName string
+		wgDeviceName            string
+		listenAddr              string
+		reconcileEvery          time.Duration
+		dryRun                  bool
+		removeAll               bool
+		streamLive              bool
+	)
+	fs.StringVar(&provisionConfigFileName, "p", "", "provisioning config file name")
+	fs.StringVar(&wgDeviceName, "i", "", "wireguard interface (optional if only one interface is configured)")
+	fs.StringVar(&listenAddr, "l", "127.0.0.1:8080", "http listen address")
+	fs.DurationVar(&reconcileEvery, "reconcile-interval", 0, "interval of applying stored peers to the interface (disabled if zero)")
+	fs.BoolVar(&dryRun, "dry-run", false, "only log reconciliation changes instead of applying them")
+	fs.BoolVar(&removeAll, "reconcile-remove-all", false, "let reconciliation remove all peers of the interface when no peer is stored")
+	fs.BoolVar(&streamLive, "live", false, "serve live usage updates of the interface from a database change stream on /live/events and /live/ws (requires a replica set)")
+	cf.parse(log, args)
+	if provisionConfigFileName == "" {
+		log.Fatal().Msg("provisioning config file name option is required and cannot be empty")
+	}
+
+	cfg := cf.mustLoadDatabaseConfig(log)
+	iface, err := selectInterface(cfg, wgDeviceName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to select interface")
+	}
+	log = log.With().Str("interface", iface.Name).Logger()
+
+	provisionCfg, err := provision.LoadConfig(provisionConfigFileName)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to load provisioning config")
+	}
+
+	db, disconnect, err := connectMongo(ctx, cfg.MongoDB, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer disconnect()
+
+	if err := migrateSchema(ctx, db, cfg, log); nil != err {
+		log.Fatal().Err(err).Msg("failed to migrate database schema")
+	}
+
+	peers := store.NewMongoPeers(db.Collection(store.PeersCollectionName))
+
+	wg, err := wgctrl.New()
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize wg control client")
+	}
+	defer wg.Close()
+
+	provisioner, err := provision.NewProvisioner(iface.Name, *provisionCfg, wg, peers, log)
+	if nil != err {
+		log.Fatal().Err(err).Msg("failed to initialize provisioner")
+	}
+
+	if reconcileEvery > 0 {
+		reconciler := provisioner.Reconciler(dryRun)
+		if removeAll {
+			reconciler.AllowRemovingAll()
+		}
+		reconcileTicker := make(chan struct{})
+		go func() {
+			reconcileTicker <- struct{}{}
+			for range time.Tick(reconcileEvery) {
+				reconcileTicker <- struct{}{}
+			}
+		}()
+		go func() {
+			if err := reconciler.Run(ctx, reconcileTicker); nil != err {
+				log.Error().Err(err).Msg("reconciler stopped")
+			}
+		}()
+		log.Info().Dur("interval", reconcileEvery).Bool("dry_run", dryRun).Msg("reconciling peers from database")
+	}
+
+	sessions := store.NewMongoSessions(db.Collection(store.SessionsCollectionName), sharedHost(cfg.MongoDB))
+	mux := http.NewServeMux()
+	mux.Handle("/peers", provision.Handler(provisioner, log))
+	mux.Handle("/sessions/", presence.Handler(sessions, iface.Name, log))
+	if streamLive {
+		hub := live.NewHub(log)
+		usage := usageStore(db, cfg.MongoDB, iface.Name)
+		go usage.WatchUsage(ctx, func(peerUsage ingest.PeerUsage, gatheredAt time.Time) {
+			hub.Publish(iface.Name, []ingest.PeerUsage{peerUsage}, gatheredAt)
+		}, log)
+		mux.Handle("/live/", live.Handler(hub, log))
+	}
+
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Shutdown.Timeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); nil != err {
+			log.Error().Err(err).Msg("failed to gracefully shutdown http server")
+		}
+	}()
+
+	log.Info().Str("address", listenAddr).Msg("serving provisioning api")
+	if err := srv.ListenAndServe(); nil != err && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("http server failed")
+	}
+}
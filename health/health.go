@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Check is a named probe that reports a failure by returning an error.
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Static returns checks as a list of checks that does not change.
+func Static(checks ...Check) func() []Check {
+	return func() []Check {
+		return checks
+	}
+}
+
+type result struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler serves /healthz from the liveness checks and /readyz from the readiness checks, which
+// are called on every request so the checks can change over time. Both respond with 200 when all
+// of their checks pass and 503 otherwise, each check being given at most timeout to complete.
+func Handler(liveness, readiness func() []Check, timeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", checksHandler(liveness, timeout))
+	mux.Handle("/readyz", checksHandler(readiness, timeout))
+	return mux
+}
+
+func checksHandler(list func() []Check, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		checks := list()
+		res := result{Status: "ok", Checks: make(map[string]string, len(checks))}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range checks {
+			wg.Add(1)
+			go func(c Check) {
+				defer wg.Done()
+				status := "ok"
+				if err := c.Func(ctx); nil != err {
+					status = err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if status != "ok" {
+					res.Status = "fail"
+				}
+				res.Checks[c.Name] = status
+			}(c)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if res.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// Progress observes engine ticks to tell whether the engine is still making progress.
+type Progress struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	lastTick   time.Time
+	lastIngest time.Time
+}
+
+// NewProgress creates a Progress that treats start as the last tick and ingest, which gives
+// the engine a grace period after startup.
+func NewProgress(start time.Time, now func() time.Time) *Progress {
+	return &Progress{now: now, lastTick: start, lastIngest: start}
+}
+
+func (p *Progress) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	p.lastTick, p.lastIngest = now, now
+}
+
+func (p *Progress) UsageFailed(ctx context.Context, err error, failedAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastTick = p.now()
+}
+
+// UsageWaiting counts ticks skipped while waiting for the interface as engine progress, so that
+// the process is not restarted while the interface is missing.
+func (p *Progress) UsageWaiting(ctx context.Context, waitingAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastTick = p.now()
+}
+
+// TickCheck fails when the engine has not finished a tick, successfully or not, or skipped one
+// while waiting for the interface, within maxAge.
+func (p *Progress) TickCheck(maxAge time.Duration) Check {
+	return Check{Name: "engine_tick", Func: func(ctx context.Context) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if age := p.now().Sub(p.lastTick); age > maxAge {
+			return fmt.Errorf("no engine tick finished for %s", age.Truncate(time.Second))
+		}
+		return nil
+	}}
+}
+
+// IngestCheck fails when usage has not been successfully ingested within maxAge.
+func (p *Progress) IngestCheck(maxAge time.Duration) Check {
+	return Check{Name: "last_ingest", Func: func(ctx context.Context) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if age := p.now().Sub(p.lastIngest); age > maxAge {
+			return fmt.Errorf("no usage ingested for %s", age.Truncate(time.Second))
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,87 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/health"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, response) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var res response
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	return rec.Code, res
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	c := &clock{now: time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)}
+	progress := health.NewProgress(c.Now(), c.Now)
+	deviceErr := errors.New("no such device")
+	h := health.Handler(
+		health.Static(progress.TickCheck(time.Minute)),
+		health.Static(
+			health.Check{Name: "device", Func: func(ctx context.Context) error { return deviceErr }},
+			progress.IngestCheck(time.Minute),
+		),
+		time.Second,
+	)
+
+	code, res := get(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, response{Status: "ok", Checks: map[string]string{"engine_tick": "ok"}}, res)
+
+	code, res = get(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, response{Status: "fail", Checks: map[string]string{"device": "no such device", "last_ingest": "ok"}}, res)
+
+	c.Advance(50 * time.Second)
+	progress.UsageFailed(ctx, deviceErr, c.Now())
+	c.Advance(50 * time.Second)
+	code, res = get(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code, "expected failed ticks to count as engine progress")
+	_, res = get(t, h, "/readyz")
+	require.Equal(t, "no usage ingested for 1m40s", res.Checks["last_ingest"])
+
+	progress.UsageIngested(ctx, nil, c.Now())
+	c.Advance(2 * time.Minute)
+	code, res = get(t, h, "/healthz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "no engine tick finished for 2m0s", res.Checks["engine_tick"])
+}
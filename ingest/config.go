@@ -0,0 +1,305 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the ingest process configuration, usually loaded from a YAML file with LoadConfig.
+type Config struct {
+	MongoDB    MongoDBConfig     `yaml:"mongodb"`
+	Interfaces []InterfaceConfig `yaml:"interfaces"`
+	Polling    PollingConfig     `yaml:"polling"`
+	Shutdown   ShutdownConfig    `yaml:"shutdown"`
+	Sinks      SinksConfig       `yaml:"sinks"`
+	HTTP       HTTPConfig        `yaml:"http"`
+	Logging    LoggingConfig     `yaml:"logging"`
+}
+
+type MongoDBConfig struct {
+	URI                    string        `yaml:"uri"`
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout"`
+	SocketTimeout          time.Duration `yaml:"socketTimeout"`
+	MaxConnIdleTime        time.Duration `yaml:"maxConnIdleTime"`
+	MaxConnecting          uint64        `yaml:"maxConnecting"`
+	Retries                RetriesConfig `yaml:"retries"`
+	// Layout is either UsageLayoutPerInterface, storing each interface usage in a collection named
+	// after it, or UsageLayoutShared, storing the usage of every host and interface in one collection.
+	Layout string `yaml:"layout"`
+	// Host identifies this host in the shared layout, scoping its usage, sessions, endpoint events
+	// and anomalies, and defaults to the host name.
+	Host string `yaml:"host"`
+	// IngestBatchSize is the maximum number of peers written by each bulk write of a tick, and
+	// IngestConcurrency the maximum number of bulk writes of a tick in flight.
+	IngestBatchSize   int `yaml:"ingestBatchSize"`
+	IngestConcurrency int `yaml:"ingestConcurrency"`
+	// Encoding is either SampleEncodingPlain, storing each sample as a document, or
+	// SampleEncodingDelta, also packing the older samples of each peer into delta-encoded buckets
+	// of BucketSize samples.
+	Encoding   string `yaml:"encoding"`
+	BucketSize int    `yaml:"bucketSize"`
+}
+
+type RetriesConfig struct {
+	Reads  bool `yaml:"reads"`
+	Writes bool `yaml:"writes"`
+}
+
+type InterfaceConfig struct {
+	Name            string `yaml:"name"`
+	RestartMarkFile string `yaml:"restartMarkFile"`
+	// WatchLink detects the interface being recreated from the kernel link events instead of a
+	// restart-mark file, and waits for the interface while it does not exist.
+	WatchLink bool `yaml:"watchLink"`
+	// WatchRestartMarkFile watches the restart-mark file with inotify instead of reading it on
+	// every tick, and pushes marks to the engine as soon as they are written, so that the next tick
+	// resets the baselines before it reads the device.
+	WatchRestartMarkFile bool `yaml:"watchRestartMarkFile"`
+}
+
+type PollingConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds how long the in-flight ticks and the final samples can take on shutdown.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SinksConfig enables the consumers of ingested usage besides the usage store, for every interface.
+type SinksConfig struct {
+	// Alerts is the alert rules file name, and Mail the optional mail config file name used to warn
+	// peer owners about their quotas.
+	Alerts   string        `yaml:"alerts"`
+	Mail     string        `yaml:"mail"`
+	Presence bool          `yaml:"presence"`
+	Roaming  RoamingConfig `yaml:"roaming"`
+	Anomaly  AnomalyConfig `yaml:"anomaly"`
+}
+
+type RoamingConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	GeoIP   []string `yaml:"geoip"`
+}
+
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Sigma is how many standard deviations above a peer's baseline rate a tick's rate is flagged
+	// as a spike, while rates staying above half of it are flagged as sustained.
+	Sigma float64 `yaml:"sigma"`
+}
+
+type HTTPConfig struct {
+	HealthAddr  string `yaml:"healthAddr"`
+	MetricsAddr string `yaml:"metricsAddr"`
+	LiveAddr    string `yaml:"liveAddr"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+const (
+	UsageLayoutPerInterface = "perInterface"
+	UsageLayoutShared       = "shared"
+)
+
+const (
+	SampleEncodingPlain = "plain"
+	SampleEncodingDelta = "delta"
+)
+
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// DefaultConfig returns the configuration values used for settings missing from the config file.
+func DefaultConfig() Config {
+	return Config{
+		MongoDB: MongoDBConfig{
+			ServerSelectionTimeout: 5 * time.Second,
+			SocketTimeout:          3 * time.Second,
+			MaxConnIdleTime:        time.Minute,
+			MaxConnecting:          4,
+			Retries:                RetriesConfig{Reads: true, Writes: true},
+			Layout:                 UsageLayoutPerInterface,
+			IngestBatchSize:        1000,
+			IngestConcurrency:      4,
+			Encoding:               SampleEncodingPlain,
+			BucketSize:             720,
+		},
+		Polling:  PollingConfig{Interval: 5 * time.Second},
+		Shutdown: ShutdownConfig{Timeout: 10 * time.Second},
+		Sinks:    SinksConfig{Anomaly: AnomalyConfig{Sigma: 6}},
+		Logging:  LoggingConfig{Level: zerolog.LevelInfoValue, Format: LogFormatJSON},
+	}
+}
+
+// LoadConfig reads filename on top of DefaultConfig. It does not validate the result, so it can
+// still be overridden before validation.
+func LoadConfig(filename string) (*Config, error) {
+	c := DefaultConfig()
+	content, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); nil != err {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return &c, nil
+}
+
+// ApplyEnv overrides config values with the environment variables that are set, as resolved by lookup:
+//   - MONGODB_URI
+//   - WIREUSE_POLL_INTERVAL
+//   - WIREUSE_LOG_LEVEL
+//   - WIREUSE_LOG_FORMAT
+//   - WIREUSE_HEALTH_ADDR
+//   - WIREUSE_METRICS_ADDR
+//   - WIREUSE_LIVE_ADDR
+func (c *Config) ApplyEnv(lookup func(key string) (string, bool, error)) error {
+	var errs []error
+	get := func(key string) (string, bool) {
+		v, ok, err := lookup(key)
+		if nil != err {
+			errs = append(errs, err)
+		}
+		return v, ok
+	}
+	if v, ok := get("MONGODB_URI"); ok {
+		c.MongoDB.URI = v
+	}
+	if v, ok := get("WIREUSE_POLL_INTERVAL"); ok {
+		d, err := time.ParseDuration(v)
+		if nil != err {
+			errs = append(errs, fmt.Errorf("invalid WIREUSE_POLL_INTERVAL environment variable: %w", err))
+		}
+		c.Polling.Interval = d
+	}
+	if v, ok := get("WIREUSE_LOG_LEVEL"); ok {
+		c.Logging.Level = v
+	}
+	if v, ok := get("WIREUSE_LOG_FORMAT"); ok {
+		c.Logging.Format = v
+	}
+	if v, ok := get("WIREUSE_HEALTH_ADDR"); ok {
+		c.HTTP.HealthAddr = v
+	}
+	if v, ok := get("WIREUSE_METRICS_ADDR"); ok {
+		c.HTTP.MetricsAddr = v
+	}
+	if v, ok := get("WIREUSE_LIVE_ADDR"); ok {
+		c.HTTP.LiveAddr = v
+	}
+	return errors.Join(errs...)
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if err := c.MongoDB.Validate(); nil != err {
+		errs = append(errs, err)
+	}
+
+	if len(c.Interfaces) == 0 {
+		errs = append(errs, errors.New("at least one interface must be configured"))
+	}
+	names := make(map[string]struct{}, len(c.Interfaces))
+	for i, iface := range c.Interfaces {
+		if iface.Name == "" {
+			errs = append(errs, fmt.Errorf("interfaces[%d].name cannot be empty", i))
+		} else if _, exists := names[iface.Name]; exists {
+			errs = append(errs, fmt.Errorf("interfaces[%d]: duplicate interface %q", i, iface.Name))
+		}
+		names[iface.Name] = struct{}{}
+		switch {
+		case iface.WatchLink && iface.RestartMarkFile != "":
+			// A mark written after the recreated interface was already noticed would reset its
+			// baselines again, counting the usage in between twice.
+			errs = append(errs, fmt.Errorf("interfaces[%d].restartMarkFile cannot be set along with watchLink", i))
+		case !iface.WatchLink && iface.RestartMarkFile == "":
+			errs = append(errs, fmt.Errorf("interfaces[%d].restartMarkFile cannot be empty unless watchLink is enabled", i))
+		case iface.WatchRestartMarkFile && iface.RestartMarkFile == "":
+			errs = append(errs, fmt.Errorf("interfaces[%d].watchRestartMarkFile requires restartMarkFile", i))
+		}
+	}
+
+	if c.Polling.Interval <= 0 {
+		errs = append(errs, errors.New("polling.interval must be greater than zero"))
+	}
+	if c.Shutdown.Timeout <= 0 {
+		errs = append(errs, errors.New("shutdown.timeout must be greater than zero"))
+	}
+
+	if c.Sinks.Mail != "" && c.Sinks.Alerts == "" {
+		errs = append(errs, errors.New("sinks.mail requires sinks.alerts"))
+	}
+	if len(c.Sinks.Roaming.GeoIP) > 0 && !c.Sinks.Roaming.Enabled {
+		errs = append(errs, errors.New("sinks.roaming.geoip requires sinks.roaming.enabled"))
+	}
+	if c.Sinks.Anomaly.Enabled && c.Sinks.Anomaly.Sigma <= 0 {
+		errs = append(errs, errors.New("sinks.anomaly.sigma must be greater than zero"))
+	}
+
+	if err := c.Logging.Validate(); nil != err {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate validates the database settings, which is all that commands only accessing the
+// database need.
+func (c MongoDBConfig) Validate() error {
+	var errs []error
+	if c.URI == "" {
+		errs = append(errs, errors.New("mongodb.uri cannot be empty"))
+	} else if err := options.Client().ApplyURI(c.URI).Validate(); nil != err {
+		errs = append(errs, fmt.Errorf("invalid mongodb.uri: %w", err))
+	}
+	if c.ServerSelectionTimeout <= 0 {
+		errs = append(errs, errors.New("mongodb.serverSelectionTimeout must be greater than zero"))
+	}
+	if c.SocketTimeout < 0 {
+		errs = append(errs, errors.New("mongodb.socketTimeout cannot be negative"))
+	}
+	if c.MaxConnIdleTime < 0 {
+		errs = append(errs, errors.New("mongodb.maxConnIdleTime cannot be negative"))
+	}
+	if c.IngestBatchSize <= 0 {
+		errs = append(errs, errors.New("mongodb.ingestBatchSize must be greater than zero"))
+	}
+	if c.IngestConcurrency <= 0 {
+		errs = append(errs, errors.New("mongodb.ingestConcurrency must be greater than zero"))
+	}
+	if c.Layout != UsageLayoutPerInterface && c.Layout != UsageLayoutShared {
+		errs = append(errs, fmt.Errorf("mongodb.layout must be either %s or %s", strconv.Quote(UsageLayoutPerInterface), strconv.Quote(UsageLayoutShared)))
+	}
+	if c.Encoding != SampleEncodingPlain && c.Encoding != SampleEncodingDelta {
+		errs = append(errs, fmt.Errorf("mongodb.encoding must be either %s or %s", strconv.Quote(SampleEncodingPlain), strconv.Quote(SampleEncodingDelta)))
+	} else if c.Encoding == SampleEncodingDelta && c.BucketSize <= 0 {
+		errs = append(errs, errors.New("mongodb.bucketSize must be greater than zero"))
+	}
+	return errors.Join(errs...)
+}
+
+func (c LoggingConfig) Validate() error {
+	var errs []error
+	if _, err := zerolog.ParseLevel(c.Level); nil != err {
+		errs = append(errs, fmt.Errorf("invalid logging.level: %w", err))
+	}
+	if c.Format != LogFormatJSON && c.Format != LogFormatConsole {
+		errs = append(errs, fmt.Errorf("logging.format must be either %s or %s", strconv.Quote(LogFormatJSON), strconv.Quote(LogFormatConsole)))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,86 @@
+package ingest_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	c, err := ingest.LoadConfig("config.example.yaml")
+	require.NoError(t, err)
+	require.NoError(t, c.Validate())
+	require.Equal(t, ingest.DefaultConfig().MongoDB, ingest.MongoDBConfig{
+		ServerSelectionTimeout: c.MongoDB.ServerSelectionTimeout,
+		SocketTimeout:          c.MongoDB.SocketTimeout,
+		MaxConnIdleTime:        c.MongoDB.MaxConnIdleTime,
+		MaxConnecting:          c.MongoDB.MaxConnecting,
+		Retries:                c.MongoDB.Retries,
+		Layout:                 c.MongoDB.Layout,
+		IngestBatchSize:        c.MongoDB.IngestBatchSize,
+		IngestConcurrency:      c.MongoDB.IngestConcurrency,
+		Encoding:               c.MongoDB.Encoding,
+		BucketSize:             c.MongoDB.BucketSize,
+	}, "expected example config to document default values")
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`
+mongodb:
+  uri: mongodb://localhost:27017/wireuse
+interfaces:
+  - name: wg0
+    restartMarkFile: /tmp/wg0
+polling:
+  interval: 10s
+`), 0o600))
+	c, err = ingest.LoadConfig(filename)
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, c.Polling.Interval)
+	require.Equal(t, 3*time.Second, c.MongoDB.SocketTimeout, "expected missing values to be defaulted")
+
+	env := map[string]string{"MONGODB_URI": "mongodb://db:27017/usage", "WIREUSE_LOG_LEVEL": "debug"}
+	require.NoError(t, c.ApplyEnv(func(key string) (string, bool, error) {
+		v, ok := env[key]
+		return v, ok, nil
+	}))
+	require.Equal(t, "mongodb://db:27017/usage", c.MongoDB.URI)
+	require.Equal(t, "debug", c.Logging.Level)
+	require.Equal(t, 10*time.Second, c.Polling.Interval)
+
+	require.NoError(t, os.WriteFile(filename, []byte("polling:\n  intervall: 10s\n"), 0o600))
+	_, err = ingest.LoadConfig(filename)
+	require.ErrorContains(t, err, "field intervall not found")
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	c := ingest.DefaultConfig()
+	c.Interfaces = []ingest.InterfaceConfig{{Name: "wg0", RestartMarkFile: "/tmp/wg0"}, {Name: "wg0"}, {Name: "wg1", WatchLink: true}, {Name: "wg2", RestartMarkFile: "/tmp/wg2", WatchLink: true}, {Name: "wg3", WatchLink: true, WatchRestartMarkFile: true}}
+	c.Polling.Interval = 0
+	c.Sinks.Mail = "mail.json"
+	c.Logging.Format = "text"
+	c.MongoDB.Layout = "single"
+	c.MongoDB.IngestConcurrency = 0
+	c.MongoDB.Encoding = "gorilla"
+	err := c.Validate()
+	require.ErrorContains(t, err, "mongodb.uri cannot be empty")
+	require.ErrorContains(t, err, `interfaces[1]: duplicate interface "wg0"`)
+	require.ErrorContains(t, err, "interfaces[1].restartMarkFile cannot be empty unless watchLink is enabled")
+	require.NotContains(t, err.Error(), "interfaces[2]")
+	require.ErrorContains(t, err, "interfaces[3].restartMarkFile cannot be set along with watchLink")
+	require.ErrorContains(t, err, "interfaces[4].watchRestartMarkFile requires restartMarkFile")
+	require.ErrorContains(t, err, "polling.interval must be greater than zero")
+	require.ErrorContains(t, err, "sinks.mail requires sinks.alerts")
+	require.ErrorContains(t, err, "mongodb.ingestConcurrency must be greater than zero")
+	require.ErrorContains(t, err, `mongodb.encoding must be either "plain" or "delta"`)
+	require.ErrorContains(t, err, `mongodb.layout must be either "perInterface" or "shared"`)
+	require.ErrorContains(t, err, `logging.format must be either "json" or "console"`)
+}
@@ -5,19 +5,46 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
 type PeerUsage struct {
-	Upload    uint
-	Download  uint
-	PublicKey string
+	Upload          uint
+	Download        uint
+	PublicKey       string
+	LatestHandshake time.Time
+	// Endpoint is the peer's latest remote address in host:port form, or empty if it has never connected.
+	Endpoint string
+}
+
+// Sub returns u with v's upload and download subtracted. Each counter is floored at zero, as a
+// total usage never decreases, and one lower than v's is inconsistent rather than a negative usage.
+func (u PeerUsage) Sub(v PeerUsage) PeerUsage {
+	u.Upload = subtract(u.Upload, v.Upload)
+	u.Download = subtract(u.Download, v.Download)
+	return u
+}
+
+// Baseline is a peer's stored usage state the engine resumes from.
+type Baseline struct {
+	// Offset is the peer's total usage as of the latest reset of its counters, which is added to
+	// its counters to get its total usage.
+	Offset PeerUsage
+	// Last is the peer's last ingested total usage.
+	Last PeerUsage
 }
 
 type Store interface {
-	LoadBeforeRestartUsage(ctx context.Context) (map[string]PeerUsage, error)
+	// LoadBaselines returns the baseline of each known peer.
+	LoadBaselines(ctx context.Context) (map[string]Baseline, error)
+	// ResetBaselines sets the offset of the peers with publicKeys, or of every known peer if
+	// publicKeys is empty, to their last ingested total usage, and returns their new offsets.
+	ResetBaselines(ctx context.Context, publicKeys []string) (map[string]PeerUsage, error)
 	IngestUsage(ctx context.Context, peersUsage []PeerUsage, gatheredAt time.Time) error
 }
 
@@ -30,11 +57,106 @@ type RestartMarkFileReadRemover interface {
 	Remove(filename string) error
 }
 
+// Link reports the state of the interface from the kernel link events.
+type Link interface {
+	// State returns whether the interface exists, and its generation, which changes every time the
+	// interface is created again, or its index changes.
+	State() (exists bool, generation uint64)
+}
+
+const (
+	StageDevice   = "device"
+	StageBaseline = "baseline"
+	StageStore    = "store"
+)
+
+// StageError is reported to observers when a tick fails, identifying the stage that failed.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+type Observer interface {
+	UsageIngested(ctx context.Context, peersUsage []PeerUsage, gatheredAt time.Time)
+	UsageFailed(ctx context.Context, err error, failedAt time.Time)
+}
+
+// WaitObserver is implemented by observers that are also notified of the ticks skipped while the
+// engine waits for the missing interface to be created.
+type WaitObserver interface {
+	UsageWaiting(ctx context.Context, waitingAt time.Time)
+}
+
+// Metrics records the engine's own measurements.
+type Metrics interface {
+	TickFinished(took time.Duration)
+	DeviceRead(took time.Duration)
+	StoreWritten(took time.Duration)
+	TickFailed(stage string)
+	PeersGathered(n int)
+	RestartCompensated(peers int)
+	TickDropped()
+}
+
+type noMetrics struct{}
+
+func (noMetrics) TickFinished(took time.Duration) {}
+func (noMetrics) DeviceRead(took time.Duration)   {}
+func (noMetrics) StoreWritten(took time.Duration) {}
+func (noMetrics) TickFailed(stage string)         {}
+func (noMetrics) PeersGathered(n int)             {}
+func (noMetrics) RestartCompensated(peers int)    {}
+func (noMetrics) TickDropped()                    {}
+
+// peerCounters are a peer's counters as of its last ingested usage, and the value of
+// Engine.ingested when they were ingested.
+type peerCounters struct {
+	PeerUsage
+	ingested uint64
+}
+
+type observers struct {
+	mu   sync.RWMutex
+	list []Observer
+}
+
 type Engine struct {
 	restartMarkFile RestartMarkFileReadRemover
 	wgPeers         WgPeers
 	store           Store
+	observers       *observers
+	metrics         Metrics
 	logger          zerolog.Logger
+	// offsets are the peers baseline offsets added to their counters, loaded on the first tick and
+	// kept across Run calls.
+	offsets map[string]PeerUsage
+	// counters are the peers counters as of their last ingested usage, used to detect peers whose
+	// counters were reset, e.g., by being removed from the interface and added back.
+	counters map[string]peerCounters
+	// ingested counts the ticks that ingested usage.
+	ingested uint64
+	// peersReset are the peers whose baselines were reset on their own since the last restart of
+	// the interface.
+	peersReset map[string]struct{}
+	// scratch keeps the raw counters of the current tick, reused across ticks.
+	scratch []PeerUsage
+	link    Link
+	// linkGeneration is the link generation as of the last ingested usage, and linkMissing whether
+	// the interface was missing on the last tick.
+	linkGeneration uint64
+	linkMissing    bool
+	// marked counts the restart marks pushed by MarkRestarted, and markedHandled is its value as of
+	// the last ingested usage.
+	marked        *atomic.Uint64
+	markedHandled uint64
 }
 
 func NewEngine(
@@ -47,59 +169,355 @@ func NewEngine(
 		restartMarkFile: restartMarkFile,
 		wgPeers:         wgPeers,
 		store:           store,
+		observers:       &observers{},
+		metrics:         noMetrics{},
 		logger:          logger,
+		marked:          &atomic.Uint64{},
+	}
+}
+
+// Instrument makes the engine record its measurements to m. It must be called before Run.
+func (e *Engine) Instrument(m Metrics) {
+	e.metrics = m
+}
+
+// WatchLink makes the engine skip ticks while the interface does not exist, instead of failing to
+// read it, notifying the observers implementing WaitObserver of each skipped tick, and treat the
+// interface being recreated as its counters being reset, the same as a restart mark. A nil l stops
+// watching. It must not be called while Run is running.
+func (e *Engine) WatchLink(l Link) {
+	e.link = l
+	e.linkMissing = false
+	if nil != l {
+		_, e.linkGeneration = l.State()
 	}
 }
 
+// MarkRestarted tells the engine that a restart mark was written, e.g., by a watcher of the
+// restart-mark file, so that its next tick resets the peers baselines even if the mark is not read
+// yet. It is checked before the device is read, and is safe to call while Run is running.
+func (e *Engine) MarkRestarted() {
+	e.marked.Add(1)
+}
+
+// Ticks forwards ticks from in to the returned channel until ctx is done. Ticks arriving while
+// the engine is still busy with a previous one are dropped, and recorded as such.
+func (e *Engine) Ticks(ctx context.Context, in <-chan time.Time) <-chan struct{} {
+	out := make(chan struct{})
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-in:
+				select {
+				case out <- struct{}{}:
+				default:
+					e.logger.Warn().Msg("dropped engine tick as the previous one is still in progress")
+					e.metrics.TickDropped()
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Observe registers o to be notified after each engine tick.
+func (e *Engine) Observe(o Observer) {
+	e.observers.mu.Lock()
+	defer e.observers.mu.Unlock()
+	e.observers.list = append(e.observers.list, o)
+}
+
+// SetObservers replaces the registered observers with obs. It is safe to call while the engine
+// is running, and waits for the replaced observers to finish handling the current tick.
+func (e *Engine) SetObservers(obs ...Observer) {
+	e.observers.mu.Lock()
+	defer e.observers.mu.Unlock()
+	e.observers.list = append([]Observer(nil), obs...)
+}
+
+func (e *Engine) notify(f func(o Observer)) {
+	e.observers.mu.RLock()
+	defer e.observers.mu.RUnlock()
+	for _, o := range e.observers.list {
+		f(o)
+	}
+}
+
+// Run ingests usage on every tick until tick is closed or an unrecoverable error occurs. It can
+// be called again after it returns, e.g., with another restart-mark file name, carrying over the
+// peers baselines. An empty restartMarkFileName disables reading a restart mark.
 func (e *Engine) Run(ctx context.Context, tick <-chan struct{}, restartMarkFileName string) error {
-	var previousPeersUsage map[string]PeerUsage
 	for range tick {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			peersUsage, gatheredAt, err := e.wgPeers.Usage(ctx)
-			if nil != err {
-				e.logger.Error().Err(err).Msg("failed to get wireguard peers usage data")
-				continue
+			if _, err := e.tick(ctx, restartMarkFileName); nil != err {
+				return err
 			}
+		}
+	}
 
-			mustDeleteRestartMarkFile := false
-			content, err := e.restartMarkFile.Read(restartMarkFileName)
-			if nil != err && !errors.Is(err, os.ErrNotExist) {
-				return fmt.Errorf("failed to read restart-mark file: %w", err)
-			} else if content == [1]byte{1} {
-				previousPeersUsage, err = e.store.LoadBeforeRestartUsage(ctx)
-				if nil != err {
-					e.logger.Error().Err(err).Msg("failed to load before restart peers usage data")
-					continue
-				}
-				mustDeleteRestartMarkFile = true
-			}
+	return nil
+}
 
-			if nil != previousPeersUsage {
-				for i := 0; i < len(peersUsage); i++ {
-					if prevUsage, exists := previousPeersUsage[peersUsage[i].PublicKey]; exists {
-						peersUsage[i].Download += prevUsage.Download
-						peersUsage[i].Upload += prevUsage.Upload
-					}
-				}
-			}
+// Flush takes a final sample and ingests it, e.g., before the process exits after Run returned.
+// It must not be called concurrently with Run. A failed stage is returned as a *StageError.
+func (e *Engine) Flush(ctx context.Context, restartMarkFileName string) error {
+	failure, err := e.tick(ctx, restartMarkFileName)
+	if nil != err {
+		return err
+	}
+	if nil != failure {
+		return failure
+	}
+	return nil
+}
 
-			if len(peersUsage) > 0 {
-				if err := e.store.IngestUsage(ctx, peersUsage, gatheredAt); nil != err {
-					e.logger.Error().Err(err).Msg("failed to ingest peers usage data")
-					continue
+// tick returns the stage failure reported to observers, if any, and an error only when the
+// engine cannot continue.
+func (e *Engine) tick(ctx context.Context, restartMarkFileName string) (*StageError, error) {
+	var linkGeneration uint64
+	if nil != e.link {
+		exists, generation := e.link.State()
+		if !exists {
+			if !e.linkMissing {
+				e.logger.Warn().Msg("waiting for the missing interface to be created")
+				e.linkMissing = true
+			}
+			now := time.Now()
+			e.notify(func(o Observer) {
+				if w, ok := o.(WaitObserver); ok {
+					w.UsageWaiting(ctx, now)
 				}
+			})
+			return nil, nil
+		}
+		if e.linkMissing {
+			e.logger.Info().Msg("interface was created")
+			e.linkMissing = false
+		}
+		linkGeneration = generation
+	}
+
+	// Marks pushed from now on may be of a restart that happened after the device is read.
+	marked := e.marked.Load()
+
+	start := time.Now()
+	defer func() {
+		e.metrics.TickFinished(time.Since(start))
+	}()
+
+	peersUsage, gatheredAt, err := e.wgPeers.Usage(ctx)
+	e.metrics.DeviceRead(time.Since(start))
+	if nil != err {
+		e.logger.Error().Err(err).Msg("failed to get wireguard peers usage data")
+		return e.failed(ctx, StageDevice, err, gatheredAt), nil
+	}
+	e.metrics.PeersGathered(len(peersUsage))
+
+	if nil == e.offsets {
+		baselines, err := e.store.LoadBaselines(ctx)
+		if nil != err {
+			e.logger.Error().Err(err).Msg("failed to load peers baselines")
+			return e.failed(ctx, StageBaseline, err, gatheredAt), nil
+		}
+		e.offsets = make(map[string]PeerUsage, len(baselines))
+		e.counters = make(map[string]peerCounters, len(baselines))
+		for publicKey, b := range baselines {
+			e.offsets[publicKey] = b.Offset
+			e.counters[publicKey] = peerCounters{
+				PeerUsage: PeerUsage{
+					Upload:    b.Last.Upload,
+					Download:  b.Last.Download,
+					PublicKey: publicKey,
+				}.Sub(b.Offset),
+				ingested: e.ingested,
 			}
+		}
+	}
 
-			if mustDeleteRestartMarkFile {
-				if err := e.restartMarkFile.Remove(restartMarkFileName); nil != err && !errors.Is(err, os.ErrNotExist) {
-					e.logger.Error().Err(err).Msg("failed to remove restart-mark file")
-				}
+	mustDeleteRestartMarkFile, markPushed := false, false
+	var content [1]byte
+	if restartMarkFileName != "" {
+		content, err = e.restartMarkFile.Read(restartMarkFileName)
+		if nil != err && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read restart-mark file: %w", err)
+		}
+		mustDeleteRestartMarkFile = content == [1]byte{1}
+		if mustDeleteRestartMarkFile {
+			// The mark read is handled along with the pushes of it, which come before it is read.
+			marked = e.marked.Load()
+		}
+	}
+	if marked != e.markedHandled {
+		// The pushed mark is removed the same as a read one, unless the file is not read at all.
+		mustDeleteRestartMarkFile = restartMarkFileName != ""
+		markPushed = true
+	}
+	linkRecreated := nil != e.link && linkGeneration != e.linkGeneration
+	if mustDeleteRestartMarkFile || markPushed || linkRecreated {
+		if linkRecreated {
+			e.logger.Info().Msg("interface was recreated")
+		}
+		if restarted, all := e.restartedPeers(peersUsage); all {
+			offsets, err := e.store.ResetBaselines(ctx, nil)
+			if nil != err {
+				e.logger.Error().Err(err).Msg("failed to reset peers baselines after interface restart")
+				return e.failed(ctx, StageBaseline, err, gatheredAt), nil
+			}
+			e.offsets = offsets
+			if nil == e.offsets {
+				e.offsets = make(map[string]PeerUsage)
+			}
+			e.counters = make(map[string]peerCounters)
+		} else if len(restarted) > 0 {
+			offsets, err := e.store.ResetBaselines(ctx, restarted)
+			if nil != err {
+				e.logger.Error().Err(err).Msg("failed to reset peers baselines after interface restart")
+				return e.failed(ctx, StageBaseline, err, gatheredAt), nil
 			}
+			e.resetOffsets(restarted, offsets)
 		}
+		e.peersReset = nil
+	} else if reset := e.resetPeers(peersUsage); len(reset) > 0 {
+		offsets, err := e.store.ResetBaselines(ctx, reset)
+		if nil != err {
+			e.logger.Error().Err(err).Strs("peers", reset).Msg("failed to reset baselines of peers with reset counters")
+			return e.failed(ctx, StageBaseline, err, gatheredAt), nil
+		}
+		e.resetOffsets(reset, offsets)
+		if nil == e.peersReset {
+			e.peersReset = make(map[string]struct{}, len(reset))
+		}
+		for _, publicKey := range reset {
+			e.peersReset[publicKey] = struct{}{}
+		}
+		e.logger.Info().Strs("peers", reset).Msg("reset baselines of peers with reset counters")
 	}
 
-	return nil
+	e.scratch = append(e.scratch[:0], peersUsage...)
+	compensated := 0
+	for i := 0; i < len(peersUsage); i++ {
+		if offset, exists := e.offsets[peersUsage[i].PublicKey]; exists && (offset.Upload > 0 || offset.Download > 0) {
+			peersUsage[i].Download += offset.Download
+			peersUsage[i].Upload += offset.Upload
+			compensated++
+		}
+	}
+	if compensated > 0 {
+		e.metrics.RestartCompensated(compensated)
+	}
+
+	if len(peersUsage) > 0 {
+		writeStart := time.Now()
+		err := e.store.IngestUsage(ctx, peersUsage, gatheredAt)
+		e.metrics.StoreWritten(time.Since(writeStart))
+		if nil != err {
+			e.logger.Error().Err(err).Msg("failed to ingest peers usage data")
+			return e.failed(ctx, StageStore, err, gatheredAt), nil
+		}
+	}
+	e.ingested++
+	for _, c := range e.scratch {
+		e.counters[c.PublicKey] = peerCounters{PeerUsage: c, ingested: e.ingested}
+	}
+	e.linkGeneration = linkGeneration
+	e.markedHandled = marked
+
+	e.notify(func(o Observer) {
+		o.UsageIngested(ctx, peersUsage, gatheredAt)
+	})
+
+	if mustDeleteRestartMarkFile {
+		if err := e.restartMarkFile.Remove(restartMarkFileName); nil != err && !errors.Is(err, os.ErrNotExist) {
+			e.logger.Error().Err(err).Msg("failed to remove restart-mark file")
+		}
+	}
+
+	return nil, nil
+}
+
+// resetPeers returns the public keys of known peers whose counters are lower than when their
+// usage was last ingested, or that were missing from a tick that ingested usage since, as they
+// were removed from the interface, and are added back with new counters.
+func (e *Engine) resetPeers(peersUsage []PeerUsage) []string {
+	var out []string
+	for _, p := range peersUsage {
+		if c, exists := e.counters[p.PublicKey]; exists && (c.ingested != e.ingested || p.Upload < c.Upload || p.Download < c.Download) {
+			out = append(out, p.PublicKey)
+		}
+	}
+	return out
+}
+
+// restartedPeers returns the public keys of the peers whose baselines are reset on a restart of
+// the interface, or all set if every peer's baseline is reset. Peers whose baselines were already
+// reset on their own since the last restart, and whose counters kept growing since, are left
+// out, as their counters were detected as reset before the restart was, e.g., by a tick that read
+// the device after the interface was recreated but before its restart mark was written.
+func (e *Engine) restartedPeers(peersUsage []PeerUsage) (publicKeys []string, all bool) {
+	skipped := make(map[string]struct{})
+	for _, p := range peersUsage {
+		if _, reset := e.peersReset[p.PublicKey]; !reset {
+			continue
+		}
+		if c, exists := e.counters[p.PublicKey]; exists && p.Upload >= c.Upload && p.Download >= c.Download {
+			skipped[p.PublicKey] = struct{}{}
+		}
+	}
+	if len(skipped) == 0 {
+		return nil, true
+	}
+
+	known := make(map[string]struct{}, len(e.offsets)+len(e.counters)+len(peersUsage))
+	for publicKey := range e.offsets {
+		known[publicKey] = struct{}{}
+	}
+	for publicKey := range e.counters {
+		known[publicKey] = struct{}{}
+	}
+	for _, p := range peersUsage {
+		known[p.PublicKey] = struct{}{}
+	}
+	for publicKey := range known {
+		if _, skip := skipped[publicKey]; !skip {
+			publicKeys = append(publicKeys, publicKey)
+		}
+	}
+	sort.Strings(publicKeys)
+	e.logger.Info().Int("skipped_peers", len(skipped)).Msg("skipped resetting baselines of peers already reset since the interface restart")
+	return publicKeys, false
+}
+
+// resetOffsets applies offsets, the new offsets of the peers with publicKeys, whose counters are
+// forgotten.
+func (e *Engine) resetOffsets(publicKeys []string, offsets map[string]PeerUsage) {
+	for _, publicKey := range publicKeys {
+		delete(e.counters, publicKey)
+		if offset, exists := offsets[publicKey]; exists {
+			e.offsets[publicKey] = offset
+		} else {
+			delete(e.offsets, publicKey)
+		}
+	}
+}
+
+func subtract(a, b uint) uint {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+func (e *Engine) failed(ctx context.Context, stage string, err error, failedAt time.Time) *StageError {
+	e.metrics.TickFailed(stage)
+	failure := &StageError{Stage: stage, Err: err}
+	e.notify(func(o Observer) {
+		o.UsageFailed(ctx, failure, failedAt)
+	})
+	return failure
 }
@@ -12,8 +12,10 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 
+	"github.com/xeptore/wireuse/health"
 	"github.com/xeptore/wireuse/ingest"
 	"github.com/xeptore/wireuse/ingest/mocks"
+	"github.com/xeptore/wireuse/metrics"
 )
 
 func TestEngineContextCancellation(t *testing.T) {
@@ -27,7 +29,8 @@ func TestEngineContextCancellation(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
-	store.EXPECT().LoadBeforeRestartUsage(runCtx).Times(0)
+	store.EXPECT().LoadBaselines(runCtx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(runCtx, gomock.Any()).Times(0)
 	store.EXPECT().IngestUsage(runCtx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1)
 
 	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
@@ -78,7 +81,8 @@ func TestEngineSingleStaticPeer(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
-	store.EXPECT().LoadBeforeRestartUsage(ctx).Times(0)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 20, Download: 60, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -144,7 +148,8 @@ func TestEngineSingleStaticPeerWithWgReadPeersUsageFailure(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
-	store.EXPECT().LoadBeforeRestartUsage(ctx).Times(0)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 20, Download: 60, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -208,7 +213,8 @@ func TestEngineSingleStaticPeerWithIngestFailure(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
-	store.EXPECT().LoadBeforeRestartUsage(ctx).Times(0)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 20, Download: 60, PublicKey: "xyz"}}, gatherTime).Return(errors.New("unknown error")).Times(1),
@@ -274,7 +280,8 @@ func TestEngineSingleStaticPeerWithRestartMarkFileReadFailure(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
-	store.EXPECT().LoadBeforeRestartUsage(ctx).Times(0)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 20, Download: 60, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -331,7 +338,7 @@ func TestEngineSingleStaticPeerWithRestartMarkFileReadFailure(t *testing.T) {
 	require.ErrorIs(t, runErr, os.ErrPermission)
 }
 
-func TestEngineSingleStaticPeerWithRestartsAndLoadBeforeRestartUsageFailure(t *testing.T) {
+func TestEngineSingleStaticPeerWithRestartsAndResetBaselinesFailure(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
 	ctrl, ctx := gomock.WithContext(ctx, t)
@@ -339,13 +346,14 @@ func TestEngineSingleStaticPeerWithRestartsAndLoadBeforeRestartUsageFailure(t *t
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
 	gomock.InOrder(
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10, Download: 80, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(nil, errors.New("unknown error")).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10 + 10, Download: 30 + 80, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(nil, errors.New("network error")).Times(2),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 50 + 10 + 10, Download: 150 + 30 + 80, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(nil, errors.New("unknown error")).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10, Download: 80, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(nil, errors.New("unknown error")).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10 + 10, Download: 30 + 80, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(nil, errors.New("network error")).Times(2),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 50 + 10 + 10, Download: 150 + 30 + 80, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(nil, errors.New("unknown error")).Times(1),
 	)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10 + 10, Download: 30 + 80, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -415,11 +423,12 @@ func TestEngineSingleStaticPeerWithRestart(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
 	gomock.InOrder(
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 154, Download: 215, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 5852, Download: 43146, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 6406, Download: 43888, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 8555, Download: 67015, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 154, Download: 215, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 5852, Download: 43146, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 6406, Download: 43888, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 8555, Download: 67015, PublicKey: "xyz"}}, nil).Times(1),
 	)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 120, Download: 169, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -547,12 +556,13 @@ func TestEngineSingleStaticPeerWithRestartStartingWithRestartMarkFileExistence(t
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
 	gomock.InOrder(
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 1, Download: 2, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 155, Download: 217, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 5853, Download: 43148, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 6407, Download: 43890, PublicKey: "xyz"}}, nil).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 8556, Download: 67017, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 1, Download: 2, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 155, Download: 217, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 5853, Download: 43148, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 6407, Download: 43890, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 8556, Download: 67017, PublicKey: "xyz"}}, nil).Times(1),
 	)
 	gomock.InOrder(
 		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 121, Download: 171, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
@@ -680,8 +690,9 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 	gatherTime := time.Now()
 
 	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
 	gomock.InOrder(
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(
+		store.EXPECT().ResetBaselines(ctx, nil).Return(
 			map[string]ingest.PeerUsage{
 				"abc": {Upload: 25, Download: 65, PublicKey: "abc"},
 				"xyz": {Upload: 20, Download: 60, PublicKey: "xyz"},
@@ -689,7 +700,7 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 			},
 			nil,
 		).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(
+		store.EXPECT().ResetBaselines(ctx, nil).Return(
 			map[string]ingest.PeerUsage{
 				"xyz": {Upload: 50, Download: 150, PublicKey: "xyz"},
 				"852": {Upload: 186580512, Download: 995098551, PublicKey: "852"},
@@ -698,7 +709,7 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 			},
 			nil,
 		).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(
+		store.EXPECT().ResetBaselines(ctx, nil).Return(
 			map[string]ingest.PeerUsage{
 				"xyz": {Upload: 50, Download: 150, PublicKey: "xyz"},
 				"852": {Upload: 186580512, Download: 995098551, PublicKey: "852"},
@@ -707,14 +718,24 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 			},
 			nil,
 		).Times(1),
-		store.EXPECT().LoadBeforeRestartUsage(ctx).Return(
+		// 123 counters were reset, while xyz and abc were missing from the previous tick, i.e.,
+		// removed from the interface, and are added back.
+		store.EXPECT().ResetBaselines(ctx, []string{"xyz", "123", "abc"}).Return(
+			map[string]ingest.PeerUsage{
+				"xyz": {Upload: 37 + 50, Download: 98 + 150, PublicKey: "xyz"},
+				"123": {Upload: 53 + 107, Download: 132 + 263, PublicKey: "123"},
+				"abc": {Upload: 49 + 128001692, Download: 137 + 186202004, PublicKey: "abc"},
+			},
+			nil,
+		).Times(1),
+		// xyz was already reset since the restart, and its counters kept growing.
+		store.EXPECT().ResetBaselines(ctx, []string{"123", "456", "852", "abc", "qwe"}).Return(
 			map[string]ingest.PeerUsage{
 				"852": {Upload: 186580512, Download: 995098551, PublicKey: "852"},
 				"qwe": {Upload: 40, Download: 85, PublicKey: "qwe"},
-				"xyz": {Upload: 37 + 50, Download: 98 + 150, PublicKey: "xyz"},
-				"123": {Upload: 45 + 107, Download: 120 + 263, PublicKey: "123"},
+				"123": {Upload: 45 + 53 + 107, Download: 120 + 132 + 263, PublicKey: "123"},
 				"456": {Upload: 124728866, Download: 155917550, PublicKey: "456"},
-				"abc": {Upload: 49 + 128001692, Download: 137 + 186202004, PublicKey: "abc"},
+				"abc": {Upload: 49 + 49 + 128001692, Download: 137 + 137 + 186202004, PublicKey: "abc"},
 			},
 			nil,
 		).Times(1),
@@ -785,10 +806,10 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 			ctx,
 			[]ingest.PeerUsage{
 				{Upload: 40, Download: 85, PublicKey: "qwe"},
-				{Upload: 37 + 50, Download: 98 + 150, PublicKey: "xyz"},
-				{Upload: 45 + 107, Download: 120 + 263, PublicKey: "123"},
+				{Upload: 37 + 37 + 50, Download: 98 + 98 + 150, PublicKey: "xyz"},
+				{Upload: 45 + 53 + 107, Download: 120 + 132 + 263, PublicKey: "123"},
 				{Upload: 124728866, Download: 155917550, PublicKey: "456"},
-				{Upload: 49 + 128001692, Download: 137 + 186202004, PublicKey: "abc"},
+				{Upload: 49 + 49 + 128001692, Download: 137 + 137 + 186202004, PublicKey: "abc"},
 			},
 			gatherTime,
 		).Return(nil).Times(1),
@@ -928,3 +949,486 @@ func TestEngineMultipleDynamicPeers(t *testing.T) {
 	<-wait
 	require.Nil(t, runErr)
 }
+
+func TestEngineWatchesLink(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10, Download: 30, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 12, Download: 35, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 14, Download: 40, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	readRestartMarkFile.EXPECT().Read(gomock.Any()).Times(0)
+	readRestartMarkFile.EXPECT().Remove(gomock.Any()).Times(0)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 2, Download: 5, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 4, Download: 10, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+	)
+
+	link := mocks.NewMockLink(ctrl)
+	gomock.InOrder(
+		link.EXPECT().State().Return(true, uint64(0)).Times(2),
+		link.EXPECT().State().Return(false, uint64(0)).Times(2),
+		link.EXPECT().State().Return(true, uint64(1)).Times(2),
+	)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	e.WatchLink(link)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, e.Flush(ctx, ""))
+	}
+}
+
+func TestEngineMarkRestarted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 10, Download: 30, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 12, Download: 35, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 12, Download: 35, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 13, Download: 36, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 15, Download: 38, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	gomock.InOrder(
+		// The second mark is pushed before its file is read.
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, nil).Times(2),
+		readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(1),
+		// The pushed mark is read along with the push, and handled once.
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{1}, nil).Times(1),
+		readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(1),
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(1),
+	)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 2, Download: 5, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 1, Download: 1, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 3, Download: 3, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+	)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	require.NoError(t, e.Flush(ctx, "TODO"))
+	e.MarkRestarted()
+	require.NoError(t, e.Flush(ctx, "TODO"))
+	e.MarkRestarted()
+	require.NoError(t, e.Flush(ctx, "TODO"))
+	require.NoError(t, e.Flush(ctx, "TODO"))
+}
+
+func TestEngineWaitingForLinkKeepsTickCheckPassing(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(gomock.Any()).Times(0)
+	store.EXPECT().IngestUsage(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	readWGPeersUsage.EXPECT().Usage(gomock.Any()).Times(0)
+
+	link := mocks.NewMockLink(ctrl)
+	link.EXPECT().State().Return(false, uint64(0)).AnyTimes()
+
+	now := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	progress := health.NewProgress(now, func() time.Time { return now })
+	check := progress.TickCheck(time.Minute)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	e.WatchLink(link)
+	e.Observe(progress)
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(30 * time.Second)
+		require.NoError(t, e.Flush(ctx, ""))
+		require.NoError(t, check.Func(ctx), "expected ticks skipped while waiting for the interface to count as engine progress")
+	}
+	require.Error(t, progress.IngestCheck(time.Minute).Func(ctx), "expected no usage to be ingested while waiting for the interface")
+}
+
+func TestEngineObservers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+	usageErr := errors.New("unknown error")
+	storeErr := errors.New("write error")
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 30, Download: 90, PublicKey: "xyz"}}, gatherTime).Return(storeErr).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(2)
+	readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(0)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return(nil, gatherTime, usageErr).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 30, Download: 90, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+	)
+
+	observer := mocks.NewMockObserver(ctrl)
+	gomock.InOrder(
+		observer.EXPECT().UsageIngested(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Times(1),
+		observer.EXPECT().UsageFailed(ctx, &ingest.StageError{Stage: ingest.StageDevice, Err: usageErr}, gatherTime).Times(1),
+		observer.EXPECT().UsageFailed(ctx, &ingest.StageError{Stage: ingest.StageStore, Err: storeErr}, gatherTime).Times(1),
+	)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	e.Observe(observer)
+
+	ticker := make(chan struct{})
+
+	var runErr error
+	wait := make(chan struct{})
+	go func() {
+		defer func() {
+			wait <- struct{}{}
+		}()
+		runErr = e.Run(ctx, ticker, "TODO")
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case ticker <- struct{}{}:
+		case <-wait:
+			t.Fatal("unexpected engine run termination")
+		}
+	}
+
+	close(ticker)
+	<-wait
+	require.Nil(t, runErr)
+}
+
+func TestEngineMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+	usageErr := errors.New("unknown error")
+	storeErr := errors.New("write error")
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 100, Download: 300, PublicKey: "xyz"}}, nil).Times(1)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}, {Upload: 1, Download: 3, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 101, Download: 303, PublicKey: "xyz"}, {Upload: 1, Download: 3, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 102, Download: 306, PublicKey: "xyz"}}, gatherTime).Return(storeErr).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	gomock.InOrder(
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(1),
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{1}, nil).Times(1),
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(1),
+	)
+	readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(1)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}, {Upload: 1, Download: 3, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return(nil, gatherTime, usageErr).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 1, Download: 3, PublicKey: "xyz"}, {Upload: 1, Download: 3, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 2, Download: 6, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+	)
+
+	recorder := metrics.NewRecorder()
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	e.Instrument(recorder)
+
+	ticker := make(chan struct{})
+
+	var runErr error
+	wait := make(chan struct{})
+	go func() {
+		defer func() {
+			wait <- struct{}{}
+		}()
+		runErr = e.Run(ctx, ticker, "TODO")
+	}()
+
+	for i := 0; i < 4; i++ {
+		select {
+		case ticker <- struct{}{}:
+		case <-wait:
+			t.Fatal("unexpected engine run termination")
+		}
+	}
+
+	close(ticker)
+	<-wait
+	require.Nil(t, runErr)
+
+	s := recorder.Snapshot()
+	require.Equal(t, uint64(4), s.Ticks)
+	require.Equal(t, uint64(4), s.DeviceReads)
+	require.Equal(t, uint64(3), s.StoreWrites)
+	require.Equal(t, map[string]uint64{ingest.StageDevice: 1, ingest.StageStore: 1}, s.FailedTicks)
+	require.Equal(t, 1, s.Peers)
+	require.Equal(t, uint64(2), s.RestartCompensations)
+	require.Zero(t, s.DroppedTicks)
+}
+
+func TestEngineTicksDropsTicksWhileBusy(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := metrics.NewRecorder()
+	e := ingest.NewEngine(nil, nil, nil, zerolog.New(io.Discard))
+	e.Instrument(recorder)
+
+	in := make(chan time.Time)
+	out := e.Ticks(ctx, in)
+
+	in <- time.Now()
+	in <- time.Now()
+	require.Eventually(t, func() bool { return recorder.Snapshot().DroppedTicks == 2 }, 5*time.Second, time.Millisecond)
+
+	received := make(chan struct{})
+	go func() {
+		<-out
+		close(received)
+	}()
+	require.Eventually(t, func() bool {
+		select {
+		case in <- time.Now():
+		default:
+		}
+		select {
+		case <-received:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	for range out {
+	}
+}
+
+func TestEngineKeepsBaselinesAcrossRuns(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 100, Download: 300, PublicKey: "xyz"}}, nil).Times(1)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 101, Download: 303, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 102, Download: 306, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	gomock.InOrder(
+		readRestartMarkFile.EXPECT().Read("before").Return([1]byte{1}, nil).Times(1),
+		readRestartMarkFile.EXPECT().Remove("before").Return(nil).Times(1),
+		readRestartMarkFile.EXPECT().Read("after").Return([1]byte{0}, os.ErrNotExist).Times(1),
+	)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 1, Download: 3, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 2, Download: 6, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+	)
+
+	first := mocks.NewMockObserver(ctrl)
+	first.EXPECT().UsageIngested(ctx, []ingest.PeerUsage{{Upload: 101, Download: 303, PublicKey: "xyz"}}, gatherTime).Times(1)
+	second := mocks.NewMockObserver(ctrl)
+	second.EXPECT().UsageIngested(ctx, []ingest.PeerUsage{{Upload: 102, Download: 306, PublicKey: "xyz"}}, gatherTime).Times(1)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	e.Observe(first)
+
+	for _, restartMarkFileName := range []string{"before", "after"} {
+		ticker := make(chan struct{}, 1)
+		ticker <- struct{}{}
+		close(ticker)
+		require.NoError(t, e.Run(ctx, ticker, restartMarkFileName))
+		e.SetObservers(second)
+	}
+}
+
+func TestEngineFlush(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+	storeErr := errors.New("write error")
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(nil, nil).Times(1)
+	store.EXPECT().ResetBaselines(ctx, gomock.Any()).Times(0)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(storeErr).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(2)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 10, Download: 30, PublicKey: "xyz"}}, gatherTime, nil).Times(2)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+
+	err := e.Flush(ctx, "TODO")
+	var stageErr *ingest.StageError
+	require.ErrorAs(t, err, &stageErr)
+	require.Equal(t, ingest.StageStore, stageErr.Stage)
+	require.ErrorIs(t, err, storeErr)
+
+	require.NoError(t, e.Flush(ctx, "TODO"))
+}
+
+func TestEngineResumesBaselinesAndResetsPeersWithResetCounters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+	loadErr := errors.New("network error")
+
+	store := mocks.NewMockStore(ctrl)
+	gomock.InOrder(
+		store.EXPECT().LoadBaselines(ctx).Return(nil, loadErr).Times(1),
+		store.EXPECT().LoadBaselines(ctx).Return(map[string]ingest.Baseline{
+			"xyz": {Offset: ingest.PeerUsage{Upload: 100, Download: 300, PublicKey: "xyz"}, Last: ingest.PeerUsage{Upload: 110, Download: 330, PublicKey: "xyz"}},
+			"abc": {Last: ingest.PeerUsage{Upload: 5, Download: 7, PublicKey: "abc"}},
+		}, nil).Times(1),
+	)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 112, Download: 335, PublicKey: "xyz"}, {Upload: 6, Download: 8, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().ResetBaselines(ctx, []string{"xyz"}).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 112, Download: 335, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 2 + 112, Download: 5 + 335, PublicKey: "xyz"}, {Upload: 7, Download: 9, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 3 + 112, Download: 6 + 335, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		// abc was removed and added back, and its new counters went past its old ones.
+		store.EXPECT().ResetBaselines(ctx, []string{"abc"}).Return(map[string]ingest.PeerUsage{"abc": {Upload: 7, Download: 9, PublicKey: "abc"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 4 + 112, Download: 7 + 335, PublicKey: "xyz"}, {Upload: 8 + 7, Download: 10 + 9, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(4)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 11, Download: 31, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 12, Download: 35, PublicKey: "xyz"}, {Upload: 6, Download: 8, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 2, Download: 5, PublicKey: "xyz"}, {Upload: 7, Download: 9, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 3, Download: 6, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 4, Download: 7, PublicKey: "xyz"}, {Upload: 8, Download: 10, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+	)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+
+	err := e.Flush(ctx, "TODO")
+	var stageErr *ingest.StageError
+	require.ErrorAs(t, err, &stageErr)
+	require.Equal(t, ingest.StageBaseline, stageErr.Stage)
+	require.ErrorIs(t, err, loadErr)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, e.Flush(ctx, "TODO"))
+	}
+}
+
+func TestEngineRestartMarkAfterPeersWereReset(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl, ctx := gomock.WithContext(ctx, t)
+
+	gatherTime := time.Now()
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().LoadBaselines(ctx).Return(map[string]ingest.Baseline{
+		"old": {Last: ingest.PeerUsage{Upload: 7, Download: 9, PublicKey: "old"}},
+	}, nil).Times(1)
+	gomock.InOrder(
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 100, Download: 300, PublicKey: "xyz"}, {Upload: 50, Download: 70, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		// The interface was recreated, and the tick reads the device before the mark is written.
+		store.EXPECT().ResetBaselines(ctx, []string{"xyz"}).Return(map[string]ingest.PeerUsage{"xyz": {Upload: 100, Download: 300, PublicKey: "xyz"}}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 5 + 100, Download: 15 + 300, PublicKey: "xyz"}}, gatherTime).Return(nil).Times(1),
+		// xyz is not reset again when the mark is read, while abc, which was not added back to the
+		// interface yet on the previous tick, is.
+		store.EXPECT().ResetBaselines(ctx, []string{"abc", "old"}).Return(map[string]ingest.PeerUsage{
+			"abc": {Upload: 50, Download: 70, PublicKey: "abc"},
+			"old": {Upload: 7, Download: 9, PublicKey: "old"},
+		}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 8 + 100, Download: 20 + 300, PublicKey: "xyz"}, {Upload: 1 + 50, Download: 2 + 70, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+		// The next restart resets every peer again.
+		store.EXPECT().ResetBaselines(ctx, nil).Return(map[string]ingest.PeerUsage{
+			"xyz": {Upload: 8 + 100, Download: 20 + 300, PublicKey: "xyz"},
+			"abc": {Upload: 1 + 50, Download: 2 + 70, PublicKey: "abc"},
+			"old": {Upload: 7, Download: 9, PublicKey: "old"},
+		}, nil).Times(1),
+		store.EXPECT().IngestUsage(ctx, []ingest.PeerUsage{{Upload: 1 + 8 + 100, Download: 1 + 20 + 300, PublicKey: "xyz"}, {Upload: 1 + 1 + 50, Download: 1 + 2 + 70, PublicKey: "abc"}}, gatherTime).Return(nil).Times(1),
+	)
+
+	readRestartMarkFile := mocks.NewMockRestartMarkFileReadRemover(ctrl)
+	gomock.InOrder(
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{0}, os.ErrNotExist).Times(2),
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{1}, nil).Times(1),
+		readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(1),
+		readRestartMarkFile.EXPECT().Read("TODO").Return([1]byte{1}, nil).Times(1),
+		readRestartMarkFile.EXPECT().Remove("TODO").Return(nil).Times(1),
+	)
+
+	readWGPeersUsage := mocks.NewMockWgPeers(ctrl)
+	gomock.InOrder(
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 100, Download: 300, PublicKey: "xyz"}, {Upload: 50, Download: 70, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 5, Download: 15, PublicKey: "xyz"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 8, Download: 20, PublicKey: "xyz"}, {Upload: 1, Download: 2, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+		readWGPeersUsage.EXPECT().Usage(ctx).Return([]ingest.PeerUsage{{Upload: 1, Download: 1, PublicKey: "xyz"}, {Upload: 1, Download: 1, PublicKey: "abc"}}, gatherTime, nil).Times(1),
+	)
+
+	e := ingest.NewEngine(readRestartMarkFile, readWGPeersUsage, store, zerolog.New(io.Discard))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, e.Flush(ctx, "TODO"))
+	}
+}
+
+func TestPeerUsageSub(t *testing.T) {
+	t.Parallel()
+
+	u := ingest.PeerUsage{Upload: 10, Download: 5, PublicKey: "peer"}
+	require.Equal(t, ingest.PeerUsage{Upload: 7, Download: 0, PublicKey: "peer"}, u.Sub(ingest.PeerUsage{Upload: 3, Download: 8}))
+}
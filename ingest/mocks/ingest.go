@@ -50,19 +50,34 @@ func (mr *MockStoreMockRecorder) IngestUsage(ctx, peersUsage, gatheredAt interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestUsage", reflect.TypeOf((*MockStore)(nil).IngestUsage), ctx, peersUsage, gatheredAt)
 }
 
-// LoadBeforeRestartUsage mocks base method.
-func (m *MockStore) LoadBeforeRestartUsage(ctx context.Context) (map[string]ingest.PeerUsage, error) {
+// LoadBaselines mocks base method.
+func (m *MockStore) LoadBaselines(ctx context.Context) (map[string]ingest.Baseline, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "LoadBeforeRestartUsage", ctx)
+	ret := m.ctrl.Call(m, "LoadBaselines", ctx)
+	ret0, _ := ret[0].(map[string]ingest.Baseline)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadBaselines indicates an expected call of LoadBaselines.
+func (mr *MockStoreMockRecorder) LoadBaselines(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadBaselines", reflect.TypeOf((*MockStore)(nil).LoadBaselines), ctx)
+}
+
+// ResetBaselines mocks base method.
+func (m *MockStore) ResetBaselines(ctx context.Context, publicKeys []string) (map[string]ingest.PeerUsage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetBaselines", ctx, publicKeys)
 	ret0, _ := ret[0].(map[string]ingest.PeerUsage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// LoadBeforeRestartUsage indicates an expected call of LoadBeforeRestartUsage.
-func (mr *MockStoreMockRecorder) LoadBeforeRestartUsage(ctx interface{}) *gomock.Call {
+// ResetBaselines indicates an expected call of ResetBaselines.
+func (mr *MockStoreMockRecorder) ResetBaselines(ctx, publicKeys interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadBeforeRestartUsage", reflect.TypeOf((*MockStore)(nil).LoadBeforeRestartUsage), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetBaselines", reflect.TypeOf((*MockStore)(nil).ResetBaselines), ctx, publicKeys)
 }
 
 // MockWgPeers is a mock of WgPeers interface.
@@ -155,3 +170,230 @@ func (mr *MockRestartMarkFileReadRemoverMockRecorder) Remove(filename interface{
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockRestartMarkFileReadRemover)(nil).Remove), filename)
 }
+
+// MockLink is a mock of Link interface.
+type MockLink struct {
+	ctrl     *gomock.Controller
+	recorder *MockLinkMockRecorder
+}
+
+// MockLinkMockRecorder is the mock recorder for MockLink.
+type MockLinkMockRecorder struct {
+	mock *MockLink
+}
+
+// NewMockLink creates a new mock instance.
+func NewMockLink(ctrl *gomock.Controller) *MockLink {
+	mock := &MockLink{ctrl: ctrl}
+	mock.recorder = &MockLinkMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLink) EXPECT() *MockLinkMockRecorder {
+	return m.recorder
+}
+
+// State mocks base method.
+func (m *MockLink) State() (bool, uint64) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "State")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(uint64)
+	return ret0, ret1
+}
+
+// State indicates an expected call of State.
+func (mr *MockLinkMockRecorder) State() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "State", reflect.TypeOf((*MockLink)(nil).State))
+}
+
+// MockObserver is a mock of Observer interface.
+type MockObserver struct {
+	ctrl     *gomock.Controller
+	recorder *MockObserverMockRecorder
+}
+
+// MockObserverMockRecorder is the mock recorder for MockObserver.
+type MockObserverMockRecorder struct {
+	mock *MockObserver
+}
+
+// NewMockObserver creates a new mock instance.
+func NewMockObserver(ctrl *gomock.Controller) *MockObserver {
+	mock := &MockObserver{ctrl: ctrl}
+	mock.recorder = &MockObserverMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockObserver) EXPECT() *MockObserverMockRecorder {
+	return m.recorder
+}
+
+// UsageFailed mocks base method.
+func (m *MockObserver) UsageFailed(ctx context.Context, err error, failedAt time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UsageFailed", ctx, err, failedAt)
+}
+
+// UsageFailed indicates an expected call of UsageFailed.
+func (mr *MockObserverMockRecorder) UsageFailed(ctx, err, failedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsageFailed", reflect.TypeOf((*MockObserver)(nil).UsageFailed), ctx, err, failedAt)
+}
+
+// UsageIngested mocks base method.
+func (m *MockObserver) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UsageIngested", ctx, peersUsage, gatheredAt)
+}
+
+// UsageIngested indicates an expected call of UsageIngested.
+func (mr *MockObserverMockRecorder) UsageIngested(ctx, peersUsage, gatheredAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsageIngested", reflect.TypeOf((*MockObserver)(nil).UsageIngested), ctx, peersUsage, gatheredAt)
+}
+
+// MockWaitObserver is a mock of WaitObserver interface.
+type MockWaitObserver struct {
+	ctrl     *gomock.Controller
+	recorder *MockWaitObserverMockRecorder
+}
+
+// MockWaitObserverMockRecorder is the mock recorder for MockWaitObserver.
+type MockWaitObserverMockRecorder struct {
+	mock *MockWaitObserver
+}
+
+// NewMockWaitObserver creates a new mock instance.
+func NewMockWaitObserver(ctrl *gomock.Controller) *MockWaitObserver {
+	mock := &MockWaitObserver{ctrl: ctrl}
+	mock.recorder = &MockWaitObserverMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWaitObserver) EXPECT() *MockWaitObserverMockRecorder {
+	return m.recorder
+}
+
+// UsageWaiting mocks base method.
+func (m *MockWaitObserver) UsageWaiting(ctx context.Context, waitingAt time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UsageWaiting", ctx, waitingAt)
+}
+
+// UsageWaiting indicates an expected call of UsageWaiting.
+func (mr *MockWaitObserverMockRecorder) UsageWaiting(ctx, waitingAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsageWaiting", reflect.TypeOf((*MockWaitObserver)(nil).UsageWaiting), ctx, waitingAt)
+}
+
+// MockMetrics is a mock of Metrics interface.
+type MockMetrics struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsMockRecorder
+}
+
+// MockMetricsMockRecorder is the mock recorder for MockMetrics.
+type MockMetricsMockRecorder struct {
+	mock *MockMetrics
+}
+
+// NewMockMetrics creates a new mock instance.
+func NewMockMetrics(ctrl *gomock.Controller) *MockMetrics {
+	mock := &MockMetrics{ctrl: ctrl}
+	mock.recorder = &MockMetricsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetrics) EXPECT() *MockMetricsMockRecorder {
+	return m.recorder
+}
+
+// DeviceRead mocks base method.
+func (m *MockMetrics) DeviceRead(took time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DeviceRead", took)
+}
+
+// DeviceRead indicates an expected call of DeviceRead.
+func (mr *MockMetricsMockRecorder) DeviceRead(took interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceRead", reflect.TypeOf((*MockMetrics)(nil).DeviceRead), took)
+}
+
+// PeersGathered mocks base method.
+func (m *MockMetrics) PeersGathered(n int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PeersGathered", n)
+}
+
+// PeersGathered indicates an expected call of PeersGathered.
+func (mr *MockMetricsMockRecorder) PeersGathered(n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PeersGathered", reflect.TypeOf((*MockMetrics)(nil).PeersGathered), n)
+}
+
+// RestartCompensated mocks base method.
+func (m *MockMetrics) RestartCompensated(peers int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RestartCompensated", peers)
+}
+
+// RestartCompensated indicates an expected call of RestartCompensated.
+func (mr *MockMetricsMockRecorder) RestartCompensated(peers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestartCompensated", reflect.TypeOf((*MockMetrics)(nil).RestartCompensated), peers)
+}
+
+// StoreWritten mocks base method.
+func (m *MockMetrics) StoreWritten(took time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StoreWritten", took)
+}
+
+// StoreWritten indicates an expected call of StoreWritten.
+func (mr *MockMetricsMockRecorder) StoreWritten(took interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreWritten", reflect.TypeOf((*MockMetrics)(nil).StoreWritten), took)
+}
+
+// TickDropped mocks base method.
+func (m *MockMetrics) TickDropped() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "TickDropped")
+}
+
+// TickDropped indicates an expected call of TickDropped.
+func (mr *MockMetricsMockRecorder) TickDropped() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TickDropped", reflect.TypeOf((*MockMetrics)(nil).TickDropped))
+}
+
+// TickFailed mocks base method.
+func (m *MockMetrics) TickFailed(stage string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "TickFailed", stage)
+}
+
+// TickFailed indicates an expected call of TickFailed.
+func (mr *MockMetricsMockRecorder) TickFailed(stage interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TickFailed", reflect.TypeOf((*MockMetrics)(nil).TickFailed), stage)
+}
+
+// TickFinished mocks base method.
+func (m *MockMetrics) TickFinished(took time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "TickFinished", took)
+}
+
+// TickFinished indicates an expected call of TickFinished.
+func (mr *MockMetricsMockRecorder) TickFinished(took interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TickFinished", reflect.TypeOf((*MockMetrics)(nil).TickFinished), took)
+}
@@ -0,0 +1,145 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RestartMarkVersion is the version of the restart marks written by WriteRestartMark.
+const RestartMarkVersion = 1
+
+// RestartMark is the content of a restart-mark file, which tells the engine that the counters of
+// the interface were reset, e.g., by the interface being recreated. Legacy restart-mark files
+// containing a single 0x01 byte are read as a mark without any details.
+type RestartMark struct {
+	Version   int       `json:"version"`
+	Interface string    `json:"interface"`
+	At        time.Time `json:"at"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// ParseRestartMark parses the content of a restart-mark file.
+func ParseRestartMark(content []byte) (*RestartMark, error) {
+	if len(bytes.TrimSpace(content)) == 1 && content[0] == 1 {
+		return &RestartMark{}, nil
+	}
+	var mark RestartMark
+	if err := json.Unmarshal(content, &mark); nil != err {
+		return nil, fmt.Errorf("invalid restart mark: %w", err)
+	}
+	if mark.Version != RestartMarkVersion {
+		return nil, fmt.Errorf("unsupported restart mark version: %d", mark.Version)
+	}
+	return &mark, nil
+}
+
+// WriteRestartMark writes mark to filename atomically, so the engine never reads a partial mark.
+func WriteRestartMark(filename string, mark RestartMark) error {
+	mark.Version = RestartMarkVersion
+	content, err := json.Marshal(mark)
+	if nil != err {
+		return fmt.Errorf("failed to encode restart mark: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if nil != err {
+		return fmt.Errorf("failed to create temporary restart-mark file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(content, '\n')); nil != err {
+		file.Close()
+		return fmt.Errorf("failed to write temporary restart-mark file: %w", err)
+	}
+	if err := file.Sync(); nil != err {
+		file.Close()
+		return fmt.Errorf("failed to sync temporary restart-mark file: %w", err)
+	}
+	if err := file.Close(); nil != err {
+		return fmt.Errorf("failed to close temporary restart-mark file: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0o644); nil != err {
+		return fmt.Errorf("failed to set restart-mark file permissions: %w", err)
+	}
+	if err := os.Rename(file.Name(), filename); nil != err {
+		return fmt.Errorf("failed to replace restart-mark file: %w", err)
+	}
+	return nil
+}
+
+// RestartMarkFile reads restart-mark files of an interface. Marks that cannot be parsed, or are
+// written for another interface, are logged and ignored rather than stopping the engine. They are
+// logged once per modification of the file, as they are left in place and read again every tick.
+type RestartMarkFile struct {
+	interfaceName string
+	logger        zerolog.Logger
+
+	mu sync.Mutex
+	// ignored is the modification time of each file whose ignored mark was logged.
+	ignored map[string]time.Time
+}
+
+func NewRestartMarkFile(interfaceName string, logger zerolog.Logger) *RestartMarkFile {
+	return &RestartMarkFile{interfaceName: interfaceName, logger: logger, ignored: make(map[string]time.Time)}
+}
+
+func (f *RestartMarkFile) Read(filename string) ([1]byte, error) {
+	content, err := os.ReadFile(filename)
+	if nil != err {
+		return [1]byte{0}, fmt.Errorf("failed to read restart-mark file: %w", err)
+	}
+	return f.Parse(filename, content), nil
+}
+
+// Parse returns the mark of content read from filename, which is unset when content is empty,
+// invalid, or a mark of another interface.
+func (f *RestartMarkFile) Parse(filename string, content []byte) [1]byte {
+	if len(content) == 0 {
+		return [1]byte{0}
+	}
+
+	mark, err := ParseRestartMark(content)
+	if nil != err {
+		f.ignore(filename).Err(err).Str("file_name", filename).Msg("ignoring invalid restart-mark file")
+		return [1]byte{0}
+	}
+	if mark.Interface != "" && mark.Interface != f.interfaceName {
+		f.ignore(filename).Str("file_name", filename).Str("mark_interface", mark.Interface).Msg("ignoring restart-mark file of another interface")
+		return [1]byte{0}
+	}
+	f.mu.Lock()
+	delete(f.ignored, filename)
+	f.mu.Unlock()
+	f.logger.Info().Str("file_name", filename).Time("marked_at", mark.At).Str("reason", mark.Reason).Msg("read restart-mark file")
+	return [1]byte{1}
+}
+
+// ignore returns the event logging that the mark of filename is ignored, which is a warning unless
+// one was already logged since the file was last modified.
+func (f *RestartMarkFile) ignore(filename string) *zerolog.Event {
+	info, err := os.Stat(filename)
+	if nil != err {
+		return f.logger.Warn()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if modTime, exists := f.ignored[filename]; exists && modTime.Equal(info.ModTime()) {
+		return f.logger.Debug()
+	}
+	f.ignored[filename] = info.ModTime()
+	return f.logger.Warn()
+}
+
+func (f *RestartMarkFile) Remove(filename string) error {
+	if err := os.Remove(filename); nil != err && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
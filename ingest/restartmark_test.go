@@ -0,0 +1,91 @@
+package ingest_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+func TestRestartMarkFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	f := ingest.NewRestartMarkFile("wg0", zerolog.Nop())
+
+	filename := filepath.Join(dir, "wg0.restart")
+	_, err := f.Read(filename)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, f.Remove(filename))
+
+	at := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg0", At: at, Reason: "wg-quick"}))
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	mark, err := ingest.ParseRestartMark(content)
+	require.NoError(t, err)
+	require.Equal(t, ingest.RestartMark{Version: ingest.RestartMarkVersion, Interface: "wg0", At: at, Reason: "wg-quick"}, *mark)
+	marked, err := f.Read(filename)
+	require.NoError(t, err)
+	require.Equal(t, [1]byte{1}, marked)
+	require.NoError(t, f.Remove(filename))
+	_, err = os.Stat(filename)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	for name, tc := range map[string]struct {
+		content []byte
+		marked  [1]byte
+	}{
+		"legacy":            {content: []byte{1}, marked: [1]byte{1}},
+		"empty":             {content: []byte{}, marked: [1]byte{0}},
+		"without interface": {content: []byte(`{"version":1,"at":"2023-03-04T05:06:07Z"}`), marked: [1]byte{1}},
+		"another interface": {content: []byte(`{"version":1,"interface":"wg1","at":"2023-03-04T05:06:07Z"}`), marked: [1]byte{0}},
+		"newer version":     {content: []byte(`{"version":2,"interface":"wg0"}`), marked: [1]byte{0}},
+		"invalid":           {content: []byte("restarted"), marked: [1]byte{0}},
+	} {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filename, tc.content, 0o644), name)
+		marked, err := f.Read(filename)
+		require.NoError(t, err, name)
+		require.Equal(t, tc.marked, marked, name)
+	}
+}
+
+func TestRestartMarkFileWarnsOncePerModification(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	f := ingest.NewRestartMarkFile("wg0", zerolog.New(&logs).Level(zerolog.WarnLevel))
+	filename := filepath.Join(t.TempDir(), "wg0.restart")
+	warnings := func() int { return strings.Count(logs.String(), `"level":"warn"`) }
+
+	require.NoError(t, os.WriteFile(filename, []byte("restarted"), 0o644))
+	for i := 0; i < 3; i++ {
+		marked, err := f.Read(filename)
+		require.NoError(t, err)
+		require.Equal(t, [1]byte{0}, marked)
+	}
+	require.Equal(t, 1, warnings())
+
+	require.NoError(t, os.WriteFile(filename, []byte(`{"version":1,"interface":"wg1"}`), 0o644))
+	modified := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filename, modified, modified))
+	for i := 0; i < 3; i++ {
+		marked, err := f.Read(filename)
+		require.NoError(t, err)
+		require.Equal(t, [1]byte{0}, marked)
+	}
+	require.Equal(t, 2, warnings())
+}
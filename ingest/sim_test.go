@@ -0,0 +1,102 @@
+package ingest_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/store"
+	"github.com/xeptore/wireuse/wgsim"
+)
+
+func TestEngineSimulatedDevice(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := zerolog.New(io.Discard)
+
+	restartMarkFileName := filepath.Join(t.TempDir(), "wg0.restart")
+	d := wgsim.NewDevice(time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC))
+	keys := d.AddPeers(100, wgsim.NewRandom(1, wgsim.Constant{Upload: 1 << 10, Download: 1 << 20}))
+	d.AddPeers(10, wgsim.Bursty{Rate: wgsim.Constant{Upload: 1 << 20, Download: 1 << 20}, Every: time.Minute, For: 10 * time.Second})
+	usage := store.NewMemory()
+	e := ingest.NewEngine(ingest.NewRestartMarkFile("wg0", logger), d, usage, logger)
+
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			d.Advance(5 * time.Second)
+			err := e.Flush(ctx, restartMarkFileName)
+			require.NoError(t, err)
+		}
+	}
+
+	tick(20)
+	d.Restart()
+	d.Advance(5 * time.Second)
+	require.NoError(t, ingest.WriteRestartMark(restartMarkFileName, ingest.RestartMark{Interface: "wg0", At: d.Now()}))
+	require.NoError(t, e.Flush(ctx, restartMarkFileName))
+	tick(20)
+
+	// Without a restart mark, the reset counters are noticed per peer.
+	d.Restart()
+	tick(20)
+
+	require.NoError(t, d.RemovePeer(keys[0]))
+	tick(5)
+	require.NoError(t, d.AddPeer(keys[0], wgsim.Constant{Upload: 1, Download: 1}))
+	d.FailReads(wgsim.ErrRead, wgsim.ErrRead)
+	d.Advance(5 * time.Second)
+	require.ErrorIs(t, e.Flush(ctx, restartMarkFileName), wgsim.ErrRead)
+	require.ErrorIs(t, e.Flush(ctx, restartMarkFileName), wgsim.ErrRead)
+	tick(20)
+
+	// A new engine resumes from the stored baselines, as after the process restarts.
+	e = ingest.NewEngine(ingest.NewRestartMarkFile("wg0", logger), d, usage, logger)
+	d.Restart()
+	tick(20)
+
+	baselines, err := usage.LoadBaselines(ctx)
+	require.NoError(t, err)
+	totals := make(map[string]ingest.PeerUsage, len(baselines))
+	for publicKey, b := range baselines {
+		totals[publicKey] = b.Last
+	}
+	require.Equal(t, d.Totals(), totals)
+}
+
+// BenchmarkEngine ticks an engine ingesting into the in-memory store, failing if a tick takes
+// longer than the default polling interval.
+func BenchmarkEngine(b *testing.B) {
+	interval := ingest.DefaultConfig().Polling.Interval
+	for _, n := range []int{10_000, 50_000, 100_000} {
+		b.Run(fmt.Sprintf("%dPeers", n), func(b *testing.B) {
+			ctx := context.Background()
+			logger := zerolog.New(io.Discard)
+
+			d := wgsim.NewDevice(time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC))
+			d.AddPeers(n, wgsim.Constant{Upload: 1 << 10, Download: 1 << 20})
+			e := ingest.NewEngine(ingest.NewRestartMarkFile("wg0", logger), d, store.NewMemory(), logger)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d.Advance(interval)
+				if err := e.Flush(ctx, ""); nil != err {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			tick := b.Elapsed() / time.Duration(b.N)
+			b.ReportMetric(float64(tick)/float64(interval), "interval/op")
+			if tick > interval {
+				b.Fatalf("tick took %s, longer than the %s polling interval", tick, interval)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package linkwatch tracks network interfaces from the kernel link events, so that interfaces
+// being deleted, recreated or renumbered are noticed without polling for them.
+package linkwatch
+
+import (
+	"sync"
+
+	"github.com/mdlayher/netlink"
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Links is the state of the interfaces as of the link events applied to it.
+type Links struct {
+	mu    sync.Mutex
+	links map[string]*state
+}
+
+type state struct {
+	index      uint32
+	exists     bool
+	generation uint64
+}
+
+func NewLinks() *Links {
+	return &Links{links: make(map[string]*state)}
+}
+
+func (l *Links) state(name string) *state {
+	st, exists := l.links[name]
+	if !exists {
+		st = &state{}
+		l.links[name] = st
+	}
+	return st
+}
+
+// Created applies a link event of the interface name with index being created or changed. The
+// generation of the interface changes unless it already existed with the same index.
+func (l *Links) Created(name string, index uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for other, st := range l.links {
+		// The interface was renamed, which is only announced with its new name.
+		if other != name && st.exists && st.index == index {
+			st.exists = false
+		}
+	}
+	st := l.state(name)
+	if st.exists && st.index == index {
+		return
+	}
+	st.exists = true
+	st.index = index
+	st.generation++
+}
+
+// Deleted applies a link event of the interface name being deleted.
+func (l *Links) Deleted(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state(name).exists = false
+}
+
+// Sync applies the complete list of existing interfaces, mapping their names to their indexes,
+// e.g., after link events were missed.
+func (l *Links) Sync(existing map[string]uint32) {
+	for name, index := range existing {
+		l.Created(name, index)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, st := range l.links {
+		if _, exists := existing[name]; !exists {
+			st.exists = false
+		}
+	}
+}
+
+// Link returns the link of the interface name, which does not need to exist yet.
+func (l *Links) Link(name string) ingest.Link {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state(name)
+	return &link{links: l, name: name}
+}
+
+type link struct {
+	links *Links
+	name  string
+}
+
+func (l *link) State() (bool, uint64) {
+	l.links.mu.Lock()
+	defer l.links.mu.Unlock()
+	st := l.links.links[l.name]
+	return st.exists, st.generation
+}
+
+// Watcher keeps Links up to date with the link events of the host.
+type Watcher struct {
+	*Links
+	conn   *netlink.Conn
+	logger zerolog.Logger
+}
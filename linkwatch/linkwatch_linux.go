@@ -0,0 +1,129 @@
+package linkwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+// NewWatcher subscribes to the link events of the host, and loads the existing interfaces. Run
+// must be called to apply the events.
+func NewWatcher(logger zerolog.Logger) (*Watcher, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, &netlink.Config{Groups: unix.RTMGRP_LINK})
+	if nil != err {
+		return nil, fmt.Errorf("failed to subscribe to link events: %w", err)
+	}
+	w := &Watcher{Links: NewLinks(), conn: conn, logger: logger}
+	// Events of the changes made while loading the interfaces are queued, and applied by Run.
+	if err := w.sync(); nil != err {
+		conn.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run applies the link events until ctx is done, or receiving them fails.
+func (w *Watcher) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer w.conn.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		msgs, err := w.conn.Receive()
+		if nil != err {
+			if nil != ctx.Err() {
+				return nil
+			}
+			if errors.Is(err, unix.ENOBUFS) {
+				w.logger.Warn().Msg("missed link events, reloading interfaces")
+				if err := w.sync(); nil != err {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("failed to receive link events: %w", err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Type != unix.RTM_NEWLINK && msg.Header.Type != unix.RTM_DELLINK {
+				continue
+			}
+			name, index, err := parseLink(msg.Data)
+			if nil != err {
+				w.logger.Warn().Err(err).Msg("ignoring invalid link event")
+				continue
+			}
+			if msg.Header.Type == unix.RTM_NEWLINK {
+				w.logger.Debug().Str("interface", name).Uint32("index", index).Msg("link created or changed")
+				w.Created(name, index)
+			} else {
+				w.logger.Debug().Str("interface", name).Uint32("index", index).Msg("link deleted")
+				w.Deleted(name)
+			}
+		}
+	}
+}
+
+// sync loads the existing interfaces into w.
+func (w *Watcher) sync() error {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if nil != err {
+		return fmt.Errorf("failed to connect to rtnetlink: %w", err)
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{Type: unix.RTM_GETLINK, Flags: netlink.Request | netlink.Dump},
+		Data:   make([]byte, unix.SizeofIfInfomsg),
+	})
+	if nil != err {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	existing := make(map[string]uint32, len(msgs))
+	for _, msg := range msgs {
+		name, index, err := parseLink(msg.Data)
+		if nil != err {
+			return err
+		}
+		existing[name] = index
+	}
+	w.Sync(existing)
+	return nil
+}
+
+// parseLink returns the name and index of the interface of an ifinfomsg link message.
+func parseLink(data []byte) (string, uint32, error) {
+	if len(data) < unix.SizeofIfInfomsg {
+		return "", 0, fmt.Errorf("link message is too short: %d bytes", len(data))
+	}
+	index := uint32(nlenc.Int32(data[4:8]))
+
+	ad, err := netlink.NewAttributeDecoder(data[unix.SizeofIfInfomsg:])
+	if nil != err {
+		return "", 0, fmt.Errorf("invalid link message attributes: %w", err)
+	}
+	var name string
+	for ad.Next() {
+		if ad.Type() == unix.IFLA_IFNAME {
+			name = ad.String()
+		}
+	}
+	if err := ad.Err(); nil != err {
+		return "", 0, fmt.Errorf("invalid link message attributes: %w", err)
+	}
+	if name == "" {
+		return "", 0, fmt.Errorf("link message of index %d has no name", index)
+	}
+	return name, index, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package linkwatch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+var errUnsupported = errors.New("link events are only supported on linux")
+
+func NewWatcher(logger zerolog.Logger) (*Watcher, error) {
+	return nil, errUnsupported
+}
+
+func (w *Watcher) Run(ctx context.Context) error {
+	return errUnsupported
+}
@@ -0,0 +1,59 @@
+package linkwatch_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/linkwatch"
+)
+
+func TestLinks(t *testing.T) {
+	t.Parallel()
+
+	links := linkwatch.NewLinks()
+	links.Sync(map[string]uint32{"lo": 1, "wg0": 5})
+	wg0 := links.Link("wg0")
+	wg1 := links.Link("wg1")
+
+	exists, generation := wg0.State()
+	require.True(t, exists)
+	exists, wg1Generation := wg1.State()
+	require.False(t, exists)
+
+	// Attribute changes, e.g., the interface going up, are announced with the same index.
+	links.Created("wg0", 5)
+	exists, g := wg0.State()
+	require.True(t, exists)
+	require.Equal(t, generation, g)
+
+	links.Deleted("wg0")
+	exists, g = wg0.State()
+	require.False(t, exists)
+	require.Equal(t, generation, g)
+
+	links.Created("wg0", 6)
+	exists, g = wg0.State()
+	require.True(t, exists)
+	require.NotEqual(t, generation, g)
+	generation = g
+
+	// A missed deletion is noticed from the changed index.
+	links.Sync(map[string]uint32{"lo": 1, "wg0": 7, "wg1": 8})
+	exists, g = wg0.State()
+	require.True(t, exists)
+	require.NotEqual(t, generation, g)
+	generation = g
+	exists, g = wg1.State()
+	require.True(t, exists)
+	require.NotEqual(t, wg1Generation, g)
+
+	links.Created("wg2", 7)
+	exists, g = wg0.State()
+	require.False(t, exists)
+	require.Equal(t, generation, g)
+
+	links.Sync(map[string]uint32{"lo": 1})
+	exists, _ = wg1.State()
+	require.False(t, exists)
+}
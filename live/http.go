@@ -0,0 +1,112 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	subscriberBuffer  = 16
+	keepaliveInterval = 15 * time.Second
+)
+
+// Handler serves the hub's updates as Server-Sent Events on GET /live/events, and as WebSocket
+// text messages on /live/ws. Every event or message is a JSON array of updates. The optional
+// interface query parameter, and publicKey query parameters, which can be repeated or
+// comma-separated, narrow the updates to specific peers.
+func Handler(hub *Hub, logger zerolog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		updates, unsubscribe := hub.Subscribe(parseFilter(r), subscriberBuffer)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(keepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); nil != err {
+					return
+				}
+			case batch := <-updates:
+				data, err := json.Marshal(batch)
+				if nil != err {
+					logger.Error().Err(err).Msg("failed to encode live updates")
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: usage\ndata: %s\n\n", data); nil != err {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+	mux.Handle("/live/ws", websocket.Server{Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+		updates, unsubscribe := hub.Subscribe(parseFilter(conn.Request()), subscriberBuffer)
+		defer unsubscribe()
+
+		// Messages from the client are not expected, and reading only detects the connection closing.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var discard []byte
+			for nil == websocket.Message.Receive(conn, &discard) {
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case batch := <-updates:
+				if err := websocket.JSON.Send(conn, batch); nil != err {
+					logger.Debug().Err(err).Msg("failed to send live updates")
+					return
+				}
+			}
+		}
+	}})
+	return mux
+}
+
+func parseFilter(r *http.Request) Filter {
+	q := r.URL.Query()
+	filter := Filter{Interface: q.Get("interface")}
+	for _, v := range q["publicKey"] {
+		for _, publicKey := range strings.Split(v, ",") {
+			if publicKey = strings.TrimSpace(publicKey); publicKey != "" {
+				if nil == filter.PublicKeys {
+					filter.PublicKeys = make(map[string]struct{})
+				}
+				filter.PublicKeys[publicKey] = struct{}{}
+			}
+		}
+	}
+	return filter
+}
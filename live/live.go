@@ -0,0 +1,171 @@
+package live
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Update is a peer's usage totals as of At, along with its transfer rates since its previous update.
+type Update struct {
+	Interface string    `json:"interface"`
+	PublicKey string    `json:"publicKey"`
+	Upload    uint      `json:"upload"`
+	Download  uint      `json:"download"`
+	At        time.Time `json:"at"`
+	// UploadRate and DownloadRate are in bytes per second, and are zero for a peer's first update
+	// or after its counters are reset.
+	UploadRate   float64 `json:"uploadRate"`
+	DownloadRate float64 `json:"downloadRate"`
+}
+
+// Filter selects the updates delivered to a subscriber. Zero values match everything.
+type Filter struct {
+	Interface  string
+	PublicKeys map[string]struct{}
+}
+
+func (f Filter) match(u Update) bool {
+	if f.Interface != "" && f.Interface != u.Interface {
+		return false
+	}
+	if len(f.PublicKeys) > 0 {
+		if _, ok := f.PublicKeys[u.PublicKey]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type peer struct {
+	interfaceName string
+	publicKey     string
+}
+
+type sample struct {
+	upload   uint
+	download uint
+	at       time.Time
+}
+
+type subscriber struct {
+	filter  Filter
+	updates chan []Update
+}
+
+// staleAfter is how long the last sample of a peer is kept without being updated, e.g., as the
+// peer was removed, before it is evicted.
+const staleAfter = 10 * time.Minute
+
+// Hub turns usage samples of any number of interfaces into updates, and fans them out to its
+// subscribers. Subscribers not keeping up miss updates rather than slowing down publishers.
+type Hub struct {
+	logger zerolog.Logger
+
+	mu   sync.Mutex
+	last map[peer]sample
+	// prunedAt is the time of the latest published samples when stale ones were last evicted.
+	prunedAt    time.Time
+	subscribers map[*subscriber]struct{}
+}
+
+func NewHub(logger zerolog.Logger) *Hub {
+	return &Hub{
+		logger:      logger,
+		last:        make(map[peer]sample),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// prune evicts the last samples not updated within staleAfter of now, at most once per staleAfter.
+func (h *Hub) prune(now time.Time) {
+	if now.Sub(h.prunedAt) < staleAfter {
+		return
+	}
+	h.prunedAt = now
+	for key, s := range h.last {
+		if now.Sub(s.at) > staleAfter {
+			delete(h.last, key)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the batches of updates matching filter, and a function
+// that unsubscribes and closes the channel. buffer is the number of batches that can be pending.
+func (h *Hub) Subscribe(filter Filter, buffer int) (<-chan []Update, func()) {
+	s := &subscriber{filter: filter, updates: make(chan []Update, buffer)}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return s.updates, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subscribers, s)
+			close(s.updates)
+		})
+	}
+}
+
+// Publish delivers the updates of interfaceName peers usage gathered at gatheredAt.
+func (h *Hub) Publish(interfaceName string, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune(gatheredAt)
+
+	updates := make([]Update, 0, len(peersUsage))
+	for _, p := range peersUsage {
+		key := peer{interfaceName: interfaceName, publicKey: p.PublicKey}
+		u := Update{Interface: interfaceName, PublicKey: p.PublicKey, Upload: p.Upload, Download: p.Download, At: gatheredAt}
+		if prev, exists := h.last[key]; exists {
+			if !gatheredAt.After(prev.at) {
+				continue
+			}
+			if elapsed := gatheredAt.Sub(prev.at).Seconds(); p.Upload >= prev.upload && p.Download >= prev.download {
+				u.UploadRate = float64(p.Upload-prev.upload) / elapsed
+				u.DownloadRate = float64(p.Download-prev.download) / elapsed
+			}
+		}
+		h.last[key] = sample{upload: p.Upload, download: p.Download, at: gatheredAt}
+		updates = append(updates, u)
+	}
+
+	for s := range h.subscribers {
+		var matched []Update
+		for _, u := range updates {
+			if s.filter.match(u) {
+				matched = append(matched, u)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		select {
+		case s.updates <- matched:
+		default:
+			h.logger.Debug().Str("interface", interfaceName).Msg("dropped live updates of a slow subscriber")
+		}
+	}
+}
+
+// Observer returns an engine observer publishing interfaceName ingested usage to h.
+func (h *Hub) Observer(interfaceName string) ingest.Observer {
+	return &observer{hub: h, interfaceName: interfaceName}
+}
+
+type observer struct {
+	hub           *Hub
+	interfaceName string
+}
+
+func (o *observer) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	o.hub.Publish(o.interfaceName, peersUsage, gatheredAt)
+}
+
+func (o *observer) UsageFailed(ctx context.Context, err error, failedAt time.Time) {}
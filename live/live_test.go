@@ -0,0 +1,131 @@
+package live_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/live"
+)
+
+func TestHub(t *testing.T) {
+	t.Parallel()
+
+	hub := live.NewHub(zerolog.New(io.Discard))
+	all, unsubscribeAll := hub.Subscribe(live.Filter{}, 4)
+	defer unsubscribeAll()
+	filtered, unsubscribeFiltered := hub.Subscribe(live.Filter{Interface: "wg0", PublicKeys: map[string]struct{}{"abc": {}}}, 4)
+	defer unsubscribeFiltered()
+
+	now := time.Now()
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 100, Download: 1000}, {PublicKey: "def", Upload: 10}}, now)
+	require.Equal(t, []live.Update{
+		{Interface: "wg0", PublicKey: "abc", Upload: 100, Download: 1000, At: now},
+		{Interface: "wg0", PublicKey: "def", Upload: 10, At: now},
+	}, <-all)
+	require.Equal(t, []live.Update{{Interface: "wg0", PublicKey: "abc", Upload: 100, Download: 1000, At: now}}, <-filtered)
+
+	later := now.Add(2 * time.Second)
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 300, Download: 5000}, {PublicKey: "def", Upload: 4}}, later)
+	require.Equal(t, []live.Update{
+		{Interface: "wg0", PublicKey: "abc", Upload: 300, Download: 5000, At: later, UploadRate: 100, DownloadRate: 2000},
+		{Interface: "wg0", PublicKey: "def", Upload: 4, At: later},
+	}, <-all, "counter reset must not report a rate")
+	require.Equal(t, "abc", (<-filtered)[0].PublicKey)
+
+	hub.Publish("wg1", []ingest.PeerUsage{{PublicKey: "abc", Upload: 1}}, later)
+	require.Len(t, <-all, 1)
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 1}}, later)
+	select {
+	case u := <-all:
+		require.Fail(t, "stale sample must not be published", "%v", u)
+	case u := <-filtered:
+		require.Fail(t, "stale or unmatched update must not be published", "%v", u)
+	default:
+	}
+
+	unsubscribeFiltered()
+	_, open := <-filtered
+	require.False(t, open)
+}
+
+func TestHubDropsUpdatesOfSlowSubscribers(t *testing.T) {
+	t.Parallel()
+
+	hub := live.NewHub(zerolog.New(io.Discard))
+	updates, unsubscribe := hub.Subscribe(live.Filter{}, 1)
+	defer unsubscribe()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: uint(i)}}, now.Add(time.Duration(i)*time.Second))
+	}
+	require.Equal(t, uint(0), (<-updates)[0].Upload)
+	select {
+	case u := <-updates:
+		require.Fail(t, "updates beyond the buffer must be dropped", "%v", u)
+	default:
+	}
+}
+
+func TestHubEvictsStalePeers(t *testing.T) {
+	t.Parallel()
+
+	hub := live.NewHub(zerolog.New(io.Discard))
+	updates, unsubscribe := hub.Subscribe(live.Filter{}, 4)
+	defer unsubscribe()
+
+	now := time.Now()
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 10}, {PublicKey: "def", Upload: 10}}, now)
+	<-updates
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 20}}, now.Add(11*time.Minute))
+	<-updates
+
+	// def was not updated for longer than it is kept, so it is published as if it were new.
+	later := now.Add(12 * time.Minute)
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "def", Upload: 70}}, later)
+	require.Equal(t, []live.Update{{Interface: "wg0", PublicKey: "def", Upload: 70, At: later}}, <-updates)
+}
+
+func TestHandlerEvents(t *testing.T) {
+	t.Parallel()
+
+	hub := live.NewHub(zerolog.New(io.Discard))
+	srv := httptest.NewServer(live.Handler(hub, zerolog.New(io.Discard)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/live/events?publicKey=abc,ghi", nil)
+	require.NoError(t, err)
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	now := time.Now().Truncate(time.Millisecond)
+	hub.Publish("wg0", []ingest.PeerUsage{{PublicKey: "abc", Upload: 1}, {PublicKey: "def", Upload: 2}}, now)
+
+	scanner := bufio.NewScanner(res.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "event: usage", scanner.Text())
+	require.True(t, scanner.Scan())
+	data, found := strings.CutPrefix(scanner.Text(), "data: ")
+	require.True(t, found)
+	var updates []live.Update
+	require.NoError(t, json.Unmarshal([]byte(data), &updates))
+	require.Len(t, updates, 1)
+	require.Equal(t, "abc", updates[0].PublicKey)
+	require.True(t, now.Equal(updates[0].At))
+}
@@ -0,0 +1,435 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/alert"
+	"github.com/xeptore/wireuse/ingest"
+)
+
+const (
+	KindStatement = "statement"
+	KindQuota     = "quota"
+	KindExpiry    = "expiry"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+type Owner struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Quota is the peer's total (upload + download) quota in bytes, shown in statements.
+	Quota     uint       `json:"quota,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from"`
+	// Timeout bounds connecting to the server and sending a message. Defaults to 30s.
+	Timeout alert.Duration `json:"timeout,omitempty"`
+}
+
+type Config struct {
+	SMTP SMTPConfig `json:"smtp"`
+	// Owners maps peer public keys to their owners.
+	Owners map[string]Owner `json:"owners"`
+	// TemplatesDir optionally points to a directory containing statement.tmpl, quota.tmpl and
+	// expiry.tmpl files overriding the built-in templates. Each must define "subject" and "body".
+	TemplatesDir string `json:"templatesDir,omitempty"`
+	// ReminderDays lists how many days before expiry reminders are sent. Defaults to 7 and 1.
+	ReminderDays []int `json:"reminderDays,omitempty"`
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.SMTP.Host == "" {
+		errs = append(errs, errors.New("smtp host cannot be empty"))
+	}
+	if c.SMTP.Port <= 0 {
+		errs = append(errs, errors.New("smtp port must be greater than zero"))
+	}
+	if c.SMTP.From == "" {
+		errs = append(errs, errors.New("smtp from address cannot be empty"))
+	}
+	for k, o := range c.Owners {
+		if o.Email == "" {
+			errs = append(errs, fmt.Errorf("owner of peer %q: email cannot be empty", k))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read mail config file: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(content, &c); nil != err {
+		return nil, fmt.Errorf("failed to parse mail config file: %w", err)
+	}
+	if err := c.Validate(); nil != err {
+		return nil, fmt.Errorf("invalid mail config: %w", err)
+	}
+	if len(c.ReminderDays) == 0 {
+		c.ReminderDays = []int{7, 1}
+	}
+	return &c, nil
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type SMTPSender struct {
+	cfg     SMTPConfig
+	timeout time.Duration
+}
+
+func NewSMTPSender(cfg SMTPConfig) *SMTPSender {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &SMTPSender{cfg: cfg, timeout: timeout}
+}
+
+// Send sends msg within the configured timeout, or until ctx is done, whichever comes first.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := s.send(ctx, msg); nil != err {
+		return fmt.Errorf("failed to send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+func (s *SMTPSender) send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	conn, err := (&net.Dialer{Timeout: s.timeout}).DialContext(ctx, "tcp", addr)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); nil != err {
+		return err
+	}
+	// Canceling ctx interrupts the pending reads and writes.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if nil != err {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}); nil != err {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support authentication")
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); nil != err {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); nil != err {
+		return err
+	}
+	if err := c.Rcpt(msg.To); nil != err {
+		return err
+	}
+	w, err := c.Data()
+	if nil != err {
+		return err
+	}
+	if _, err := w.Write(encode(s.cfg.From, msg)); nil != err {
+		return err
+	}
+	if err := w.Close(); nil != err {
+		return err
+	}
+	return c.Quit()
+}
+
+func encode(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// DryRunSender writes rendered messages to w instead of sending them.
+type DryRunSender struct {
+	w io.Writer
+}
+
+func NewDryRunSender(w io.Writer) *DryRunSender {
+	return &DryRunSender{w: w}
+}
+
+func (s *DryRunSender) Send(ctx context.Context, msg Message) error {
+	_, err := fmt.Fprintf(s.w, "To: %s\nSubject: %s\n\n%s\n---\n", msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+type UsageReader interface {
+	UsageBetween(ctx context.Context, from, to time.Time) ([]ingest.PeerUsage, error)
+}
+
+// Mailer renders and sends the mails of an interface. Quota warnings are queued by Notify and
+// sent by Run, so a slow server never blocks an engine tick.
+type Mailer struct {
+	interfaceName string
+	cfg           Config
+	templates     map[string]*template.Template
+	sender        Sender
+	warnings      chan quotaWarning
+	logger        zerolog.Logger
+}
+
+type quotaWarning struct {
+	alertID string
+	data    templateData
+}
+
+func NewMailer(interfaceName string, cfg Config, sender Sender, logger zerolog.Logger) (*Mailer, error) {
+	templates := make(map[string]*template.Template, 3)
+	for _, kind := range []string{KindStatement, KindQuota, KindExpiry} {
+		t, err := loadTemplate(cfg.TemplatesDir, kind)
+		if nil != err {
+			return nil, err
+		}
+		templates[kind] = t
+	}
+	return &Mailer{
+		interfaceName: interfaceName,
+		cfg:           cfg,
+		templates:     templates,
+		sender:        sender,
+		warnings:      make(chan quotaWarning, 256),
+		logger:        logger,
+	}, nil
+}
+
+func loadTemplate(dir, kind string) (*template.Template, error) {
+	name := kind + ".tmpl"
+	t := template.New(name).Funcs(template.FuncMap{"bytes": formatBytes})
+	var fsys fs.FS = defaultTemplates
+	pattern := "templates/" + name
+	if dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, name)); nil == err {
+			fsys, pattern = os.DirFS(dir), name
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to stat %s template: %w", kind, err)
+		}
+	}
+	t, err := t.ParseFS(fsys, pattern)
+	if nil != err {
+		return nil, fmt.Errorf("failed to parse %s template: %w", kind, err)
+	}
+	for _, def := range []string{"subject", "body"} {
+		if nil == t.Lookup(def) {
+			return nil, fmt.Errorf("%s template must define %q", kind, def)
+		}
+	}
+	return t, nil
+}
+
+type templateData struct {
+	Owner     Owner
+	PublicKey string
+	Interface string
+	From      time.Time
+	To        time.Time
+	Upload    uint
+	Download  uint
+	Total     uint
+	Threshold uint
+	DaysLeft  int
+}
+
+func (m *Mailer) send(ctx context.Context, kind string, data templateData) error {
+	t := m.templates[kind]
+	var subject, body bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", data); nil != err {
+		return fmt.Errorf("failed to render %s subject: %w", kind, err)
+	}
+	if err := t.ExecuteTemplate(&body, "body", data); nil != err {
+		return fmt.Errorf("failed to render %s body: %w", kind, err)
+	}
+	msg := Message{
+		To:      data.Owner.Email,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+	}
+	return m.sender.Send(ctx, msg)
+}
+
+// SendStatements sends each owned peer's usage within [from, to) to its owner.
+func (m *Mailer) SendStatements(ctx context.Context, usage UsageReader, from, to time.Time) error {
+	peersUsage, err := usage.UsageBetween(ctx, from, to)
+	if nil != err {
+		return fmt.Errorf("failed to load peers usage: %w", err)
+	}
+
+	var errs []error
+	for _, p := range peersUsage {
+		owner, exists := m.cfg.Owners[p.PublicKey]
+		if !exists {
+			continue
+		}
+		data := templateData{
+			Owner:     owner,
+			PublicKey: p.PublicKey,
+			Interface: m.interfaceName,
+			From:      from,
+			To:        to,
+			Upload:    p.Upload,
+			Download:  p.Download,
+			Total:     p.Upload + p.Download,
+		}
+		if err := m.send(ctx, KindStatement, data); nil != err {
+			errs = append(errs, err)
+			continue
+		}
+		m.logger.Info().Str("public_key", p.PublicKey).Str("email", owner.Email).Msg("usage statement sent")
+	}
+	return errors.Join(errs...)
+}
+
+// SendExpiryReminders reminds owners whose peers expire in exactly one of the configured
+// reminder days from now, so running it once a day sends each reminder once.
+func (m *Mailer) SendExpiryReminders(ctx context.Context, now time.Time) error {
+	var errs []error
+	for publicKey, owner := range m.cfg.Owners {
+		if nil == owner.ExpiresAt || !owner.ExpiresAt.After(now) {
+			continue
+		}
+		daysLeft := int(owner.ExpiresAt.Sub(now).Hours() / 24)
+		if !contains(m.cfg.ReminderDays, daysLeft) {
+			continue
+		}
+		data := templateData{
+			Owner:     owner,
+			PublicKey: publicKey,
+			Interface: m.interfaceName,
+			DaysLeft:  daysLeft,
+		}
+		if err := m.send(ctx, KindExpiry, data); nil != err {
+			errs = append(errs, err)
+			continue
+		}
+		m.logger.Info().Str("public_key", publicKey).Str("email", owner.Email).Int("days_left", daysLeft).Msg("expiry reminder sent")
+	}
+	return errors.Join(errs...)
+}
+
+// Notify implements alert.Notifier by queuing quota warnings to peer owners, which are dropped
+// when the queue is full. Alerts of other kinds, and alerts of peers without an owner, are ignored.
+func (m *Mailer) Notify(ctx context.Context, a alert.Alert) {
+	if a.Kind != alert.KindQuota {
+		return
+	}
+	owner, exists := m.cfg.Owners[a.PublicKey]
+	if !exists {
+		return
+	}
+	data := templateData{
+		Owner:     owner,
+		PublicKey: a.PublicKey,
+		Interface: a.Interface,
+		Total:     a.Value,
+		Threshold: a.Threshold,
+	}
+	select {
+	case m.warnings <- quotaWarning{alertID: a.ID, data: data}:
+	default:
+		m.logger.Error().Str("alert_id", a.ID).Msg("quota warning queue is full, dropping warning")
+	}
+}
+
+// Run sends queued quota warnings until ctx is canceled.
+func (m *Mailer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case w := <-m.warnings:
+			if err := m.send(ctx, KindQuota, w.data); nil != err {
+				m.logger.Error().Err(err).Str("alert_id", w.alertID).Msg("failed to send quota warning")
+				continue
+			}
+			m.logger.Info().Str("public_key", w.data.PublicKey).Str("email", w.data.Owner.Email).Msg("quota warning sent")
+		}
+	}
+}
+
+func contains(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func formatBytes(b uint) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,248 @@
+package mail_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/alert"
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/mail"
+)
+
+type usageReader []ingest.PeerUsage
+
+func (u usageReader) UsageBetween(ctx context.Context, from, to time.Time) ([]ingest.PeerUsage, error) {
+	return u, nil
+}
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts a single SMTP session per connection and reports each delivered message.
+func smtpStandIn(t *testing.T) (host string, port int, messages <-chan received) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	out := make(chan received, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if nil != err {
+				return
+			}
+			go serveSMTP(conn, out)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func serveSMTP(conn net.Conn, out chan<- received) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	var msg received
+	for {
+		line, err := r.ReadString('\n')
+		if nil != err {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if nil != err {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.data = data.String()
+			out <- msg
+			msg = received{}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSendStatementsOverSMTP(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	host, port, messages := smtpStandIn(t)
+	cfg := mail.Config{
+		SMTP: mail.SMTPConfig{Host: host, Port: port, From: "billing@example.com"},
+		Owners: map[string]mail.Owner{
+			"xyz": {Name: "Alice", Email: "alice@example.com", Quota: 10 * 1024 * 1024 * 1024},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	m, err := mail.NewMailer("wg0", cfg, mail.NewSMTPSender(cfg.SMTP), zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	from := time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+	usage := usageReader{
+		{Upload: 1024, Download: 3 * 1024 * 1024, PublicKey: "xyz"},
+		{Upload: 1, Download: 1, PublicKey: "unowned"},
+	}
+	require.NoError(t, m.SendStatements(ctx, usage, from, from.AddDate(0, 1, 0)))
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, "billing@example.com", msg.from)
+		require.Equal(t, []string{"alice@example.com"}, msg.to)
+		require.Contains(t, msg.data, "Subject: Your WireGuard usage statement for September 2026\r\n")
+		require.Contains(t, msg.data, "Hi Alice,")
+		require.Contains(t, msg.data, "Upload:   1.00 KiB")
+		require.Contains(t, msg.data, "Download: 3.00 MiB")
+		require.Contains(t, msg.data, "Quota:    10.00 GiB")
+	case <-time.After(5 * time.Second):
+		t.Fatal("statement was not delivered")
+	}
+	require.Empty(t, messages)
+}
+
+func TestQuotaWarningOverSMTP(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	host, port, messages := smtpStandIn(t)
+	cfg := mail.Config{
+		SMTP:   mail.SMTPConfig{Host: host, Port: port, From: "billing@example.com"},
+		Owners: map[string]mail.Owner{"xyz": {Name: "Alice", Email: "alice@example.com"}},
+	}
+	m, err := mail.NewMailer("wg0", cfg, mail.NewSMTPSender(cfg.SMTP), zerolog.New(io.Discard))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go m.Run(ctx)
+
+	m.Notify(ctx, alert.Alert{ID: "1", Kind: alert.KindTransfer, PublicKey: "xyz"})
+	m.Notify(ctx, alert.Alert{ID: "2", Kind: alert.KindQuota, Interface: "wg0", PublicKey: "xyz", Value: 900, Threshold: 800})
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, []string{"alice@example.com"}, msg.to)
+		require.Contains(t, msg.data, "Subject: Your WireGuard usage has reached 800 B\r\n")
+		require.Contains(t, msg.data, "has transferred 900 B")
+	case <-time.After(5 * time.Second):
+		t.Fatal("quota warning was not delivered")
+	}
+}
+
+func TestSMTPSenderTimesOut(t *testing.T) {
+	t.Parallel()
+
+	// The server accepts connections, and never greets.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if nil != err {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	addr := l.Addr().(*net.TCPAddr)
+	cfg := mail.SMTPConfig{Host: addr.IP.String(), Port: addr.Port, From: "billing@example.com", Timeout: alert.Duration(100 * time.Millisecond)}
+	msg := mail.Message{To: "alice@example.com", Subject: "subject", Body: "body"}
+
+	start := time.Now()
+	require.Error(t, mail.NewSMTPSender(cfg).Send(context.Background(), msg))
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	cfg.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	require.Error(t, mail.NewSMTPSender(cfg).Send(ctx, msg))
+	require.Less(t, time.Since(start), 5*time.Second, "expected canceling ctx to interrupt sending")
+}
+
+func TestExpiryRemindersDryRun(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	now := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	inWeek, inMonth := now.Add(7*24*time.Hour+time.Hour), now.AddDate(0, 1, 0)
+	cfg := mail.Config{
+		Owners: map[string]mail.Owner{
+			"xyz": {Name: "Alice", Email: "alice@example.com", ExpiresAt: &inWeek},
+			"abc": {Name: "Bob", Email: "bob@example.com", ExpiresAt: &inMonth},
+			"def": {Name: "Carol", Email: "carol@example.com"},
+		},
+		ReminderDays: []int{7, 1},
+	}
+
+	var out bytes.Buffer
+	m, err := mail.NewMailer("wg0", cfg, mail.NewDryRunSender(&out), zerolog.New(io.Discard))
+	require.NoError(t, err)
+	require.NoError(t, m.SendExpiryReminders(ctx, now))
+
+	require.Equal(t, 1, strings.Count(out.String(), "To: "))
+	require.Contains(t, out.String(), "To: alice@example.com\nSubject: Your WireGuard access expires in 7 day(s)\n")
+	require.Contains(t, out.String(), "expires on "+inWeek.Format("2006-01-02"))
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	err := mail.Config{Owners: map[string]mail.Owner{"xyz": {Name: "Alice"}}}.Validate()
+	require.ErrorContains(t, err, "smtp host cannot be empty")
+	require.ErrorContains(t, err, "smtp port must be greater than zero")
+	require.ErrorContains(t, err, "smtp from address cannot be empty")
+	require.ErrorContains(t, err, `owner of peer "xyz": email cannot be empty`)
+	require.NoError(t, mail.Config{SMTP: mail.SMTPConfig{Host: "localhost", Port: 25, From: "a@b"}}.Validate())
+}
@@ -0,0 +1,220 @@
+// Package markwatch watches restart-mark files with inotify, so that the engines read their marks
+// from memory instead of the file system on every tick, and are pushed each mark as soon as it is
+// written.
+package markwatch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Watcher keeps the content of the restart-mark files read through its Marks, watching the
+// directories of the files since the first time they are read.
+type Watcher struct {
+	mu sync.Mutex
+	// files maps the watched file names to their content, which is nil for missing files, and
+	// listeners maps them to the marks that read them.
+	files     map[string][]byte
+	listeners map[string]map[*Marks]struct{}
+	// modTimes maps the watched file names to their modification time when last read.
+	modTimes map[string]time.Time
+	// dirs maps the watched directories to their watch descriptors, and watches the other way.
+	dirs    map[string]int
+	watches map[int]string
+	// events reads the events of the inotify instance fd, whose descriptor is kept as calling
+	// events.Fd would make its reads blocking.
+	fd     int
+	events *os.File
+	logger zerolog.Logger
+}
+
+// Marks returns the restart marks of the interface interfaceName, calling marked, e.g., the
+// MarkRestarted method of its engine, as soon as a valid mark is written to the files it read.
+func (w *Watcher) Marks(interfaceName string, marked func(), logger zerolog.Logger) *Marks {
+	return &Marks{watcher: w, interfaceName: interfaceName, marked: marked, file: ingest.NewRestartMarkFile(interfaceName, logger)}
+}
+
+// Marks are the restart marks of an interface read from a Watcher.
+type Marks struct {
+	watcher       *Watcher
+	interfaceName string
+	marked        func()
+	file          *ingest.RestartMarkFile
+}
+
+func (m *Marks) Read(filename string) ([1]byte, error) {
+	filename = filepath.Clean(filename)
+	content, err := m.watcher.content(filename, m)
+	if nil != err {
+		return [1]byte{0}, err
+	}
+	return m.file.Parse(filename, content), nil
+}
+
+// Close stops calling marked of m.
+func (m *Marks) Close() {
+	m.watcher.mu.Lock()
+	defer m.watcher.mu.Unlock()
+	for filename, listeners := range m.watcher.listeners {
+		delete(listeners, m)
+		if len(listeners) == 0 {
+			delete(m.watcher.listeners, filename)
+		}
+	}
+}
+
+// valid returns whether content is a mark of the interface, without logging invalid ones, which
+// are logged when read.
+func (m *Marks) valid(content []byte) bool {
+	mark, err := ingest.ParseRestartMark(content)
+	return nil == err && (mark.Interface == "" || mark.Interface == m.interfaceName)
+}
+
+func (m *Marks) Remove(filename string) error {
+	filename = filepath.Clean(filename)
+	if err := m.file.Remove(filename); nil != err {
+		return err
+	}
+	m.watcher.mu.Lock()
+	defer m.watcher.mu.Unlock()
+	if _, exists := m.watcher.files[filename]; exists {
+		m.watcher.files[filename] = nil
+	}
+	return nil
+}
+
+// content returns the content of filename, watching its directory unless it is already watched,
+// and registers m to be pushed its marks.
+func (w *Watcher) content(filename string, m *Marks) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.listeners[filename]; !exists {
+		w.listeners[filename] = make(map[*Marks]struct{})
+	}
+	w.listeners[filename][m] = struct{}{}
+
+	if content, exists := w.files[filename]; exists {
+		if _, watched := w.dirs[filepath.Dir(filename)]; watched {
+			return content, nil
+		}
+	}
+
+	dir := filepath.Dir(filename)
+	if _, watched := w.dirs[dir]; !watched {
+		wd, err := w.addWatch(dir)
+		if nil != err {
+			return nil, err
+		}
+		w.dirs[dir] = wd
+		w.watches[wd] = dir
+	}
+	// The file is read after its directory is watched, so no change is missed in between.
+	w.files[filename] = nil
+	w.load(filename)
+	return w.files[filename], nil
+}
+
+// load reads filename into w.files, which must be locked, and pushes the marks read to their
+// listeners, unless the file did not change since it was last read, e.g., when all the files are
+// read again, so that marks not removed yet are not pushed twice.
+func (w *Watcher) load(filename string) {
+	prev, prevModTime := w.files[filename], w.modTimes[filename]
+	content, modTime, err := read(filename)
+	if nil != err {
+		if !errors.Is(err, os.ErrNotExist) {
+			w.logger.Error().Err(err).Str("file_name", filename).Msg("failed to read restart-mark file")
+		}
+		w.files[filename] = nil
+		return
+	}
+	w.files[filename] = content
+	w.modTimes[filename] = modTime
+	if len(content) == 0 || (nil != prev && bytes.Equal(prev, content) && modTime.Equal(prevModTime)) {
+		return
+	}
+	for m := range w.listeners[filename] {
+		if m.valid(content) {
+			m.marked()
+		}
+	}
+}
+
+// read returns the content of filename, which is not nil, along with its modification time.
+func read(filename string) ([]byte, time.Time, error) {
+	f, err := os.Open(filename)
+	if nil != err {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if nil != err {
+		return nil, time.Time{}, err
+	}
+	content, err := io.ReadAll(f)
+	if nil != err {
+		return nil, time.Time{}, err
+	}
+	if nil == content {
+		content = []byte{}
+	}
+	return content, info.ModTime(), nil
+}
+
+// changed applies a change of the file name in the directory watched by wd, which is removed
+// if the file was deleted or moved away.
+func (w *Watcher) changed(wd int, name string, removed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir, exists := w.watches[wd]
+	if !exists {
+		return
+	}
+	filename := filepath.Join(dir, name)
+	if _, exists := w.files[filename]; !exists {
+		return
+	}
+	if removed {
+		w.files[filename] = nil
+		return
+	}
+	w.load(filename)
+}
+
+// unwatched forgets the directory of wd, e.g., after it was deleted, so that it is watched again
+// when its files are read.
+func (w *Watcher) unwatched(wd int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir, exists := w.watches[wd]
+	if !exists {
+		return
+	}
+	delete(w.watches, wd)
+	delete(w.dirs, dir)
+	for filename := range w.files {
+		if filepath.Dir(filename) == dir {
+			w.files[filename] = nil
+		}
+	}
+}
+
+// reload reads all the watched files again, e.g., after events were missed.
+func (w *Watcher) reload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for filename := range w.files {
+		w.load(filename)
+	}
+}
@@ -0,0 +1,85 @@
+package markwatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_ONLYDIR
+
+// NewWatcher creates an inotify instance. Run must be called to apply the changes of the files.
+func NewWatcher(logger zerolog.Logger) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if nil != err {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+	return &Watcher{
+		files:     make(map[string][]byte),
+		listeners: make(map[string]map[*Marks]struct{}),
+		modTimes:  make(map[string]time.Time),
+		dirs:      make(map[string]int),
+		watches:   make(map[int]string),
+		fd:        fd,
+		events:    os.NewFile(uintptr(fd), "inotify"),
+		logger:    logger,
+	}, nil
+}
+
+func (w *Watcher) addWatch(dir string) (int, error) {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, watchMask)
+	if nil != err {
+		return 0, fmt.Errorf("failed to watch restart-mark file directory: %w", &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err})
+	}
+	return wd, nil
+}
+
+// Run applies the changes of the watched files until ctx is done, or reading the events fails.
+func (w *Watcher) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer w.events.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.events.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.events.Read(buf)
+		if nil != err {
+			if nil != ctx.Err() || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read inotify events: %w", err)
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if offset > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				w.logger.Warn().Msg("missed restart-mark file events, reading the files again")
+				w.reload()
+			case event.Mask&unix.IN_IGNORED != 0:
+				w.unwatched(int(event.Wd))
+			case name != "":
+				w.changed(int(event.Wd), name, event.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package markwatch_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/markwatch"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+
+	w, err := markwatch.NewWatcher(zerolog.Nop())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "wg0.restart")
+	var pushed atomic.Int64
+	marks := w.Marks("wg0", func() { pushed.Add(1) }, zerolog.Nop())
+	eventually := func(expected [1]byte) {
+		require.Eventually(t, func() bool {
+			marked, err := marks.Read(filename)
+			require.NoError(t, err)
+			return marked == expected
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	marked, err := marks.Read(filename)
+	require.NoError(t, err)
+	require.Equal(t, [1]byte{0}, marked)
+
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg0", At: time.Now()}))
+	require.Eventually(t, func() bool { return pushed.Load() == 1 }, time.Second, 5*time.Millisecond, "expected the mark to be pushed as soon as it is written")
+	eventually([1]byte{1})
+
+	require.NoError(t, marks.Remove(filename))
+	marked, err = marks.Read(filename)
+	require.NoError(t, err)
+	require.Equal(t, [1]byte{0}, marked)
+	_, err = os.Stat(filename)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(filename, []byte{1}, 0o644))
+	eventually([1]byte{1})
+	require.NoError(t, os.Remove(filename))
+	eventually([1]byte{0})
+	require.Equal(t, int64(2), pushed.Load())
+
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg1", At: time.Now()}))
+	time.Sleep(50 * time.Millisecond)
+	marked, err = marks.Read(filename)
+	require.NoError(t, err)
+	require.Equal(t, [1]byte{0}, marked)
+	require.Equal(t, int64(2), pushed.Load(), "expected marks of other interfaces not to be pushed")
+
+	marks.Close()
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg0", At: time.Now()}))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(2), pushed.Load(), "expected closed marks not to be pushed")
+
+	_, err = marks.Read(filepath.Join(dir, "missing", "wg0.restart"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
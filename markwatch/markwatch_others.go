@@ -0,0 +1,24 @@
+//go:build !linux
+
+package markwatch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+var errUnsupported = errors.New("watching restart-mark files is only supported on linux")
+
+func NewWatcher(logger zerolog.Logger) (*Watcher, error) {
+	return nil, errUnsupported
+}
+
+func (w *Watcher) addWatch(dir string) (int, error) {
+	return 0, errUnsupported
+}
+
+func (w *Watcher) Run(ctx context.Context) error {
+	return errUnsupported
+}
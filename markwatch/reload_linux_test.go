@@ -0,0 +1,37 @@
+package markwatch
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+func TestReloadPushesChangedMarksOnly(t *testing.T) {
+	t.Parallel()
+
+	w, err := NewWatcher(zerolog.Nop())
+	require.NoError(t, err)
+	defer w.events.Close()
+
+	filename := filepath.Join(t.TempDir(), "wg0.restart")
+	at := time.Now()
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg0", At: at}))
+	pushed := 0
+	marks := w.Marks("wg0", func() { pushed++ }, zerolog.Nop())
+	marked, err := marks.Read(filename)
+	require.NoError(t, err)
+	require.Equal(t, [1]byte{1}, marked)
+	require.Equal(t, 1, pushed)
+
+	w.reload()
+	require.Equal(t, 1, pushed, "expected the mark already pushed not to be pushed again")
+
+	require.NoError(t, ingest.WriteRestartMark(filename, ingest.RestartMark{Interface: "wg0", At: at.Add(time.Second)}))
+	w.reload()
+	require.Equal(t, 2, pushed)
+}
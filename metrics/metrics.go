@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshot is a point-in-time copy of the measurements taken by a Recorder.
+type Snapshot struct {
+	Ticks                uint64
+	TickDuration         time.Duration
+	DeviceReads          uint64
+	DeviceReadDuration   time.Duration
+	StoreWrites          uint64
+	StoreWriteDuration   time.Duration
+	FailedTicks          map[string]uint64
+	Peers                int
+	RestartCompensations uint64
+	DroppedTicks         uint64
+}
+
+// Recorder keeps engine measurements in memory. Durations are totals over all of their
+// measurements, and Peers is the number of peers gathered by the latest successful device read.
+type Recorder struct {
+	mu sync.Mutex
+	s  Snapshot
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{s: Snapshot{FailedTicks: make(map[string]uint64)}}
+}
+
+func (r *Recorder) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.s
+	out.FailedTicks = make(map[string]uint64, len(r.s.FailedTicks))
+	for k, v := range r.s.FailedTicks {
+		out.FailedTicks[k] = v
+	}
+	return out
+}
+
+func (r *Recorder) TickFinished(took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.Ticks++
+	r.s.TickDuration += took
+}
+
+func (r *Recorder) DeviceRead(took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.DeviceReads++
+	r.s.DeviceReadDuration += took
+}
+
+func (r *Recorder) StoreWritten(took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.StoreWrites++
+	r.s.StoreWriteDuration += took
+}
+
+func (r *Recorder) TickFailed(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.FailedTicks[stage]++
+}
+
+func (r *Recorder) PeersGathered(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.Peers = n
+}
+
+func (r *Recorder) RestartCompensated(peers int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.RestartCompensations += uint64(peers)
+}
+
+func (r *Recorder) TickDropped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.DroppedTicks++
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "wireuse_ingest"
+
+// Prometheus exposes engine measurements as Prometheus metrics labeled with the interface name.
+type Prometheus struct {
+	reg                  prometheus.Registerer
+	tickDuration         prometheus.Histogram
+	deviceReadDuration   prometheus.Histogram
+	storeWriteDuration   prometheus.Histogram
+	failedTicks          *prometheus.CounterVec
+	peers                prometheus.Gauge
+	restartCompensations prometheus.Counter
+	droppedTicks         prometheus.Counter
+}
+
+func NewPrometheus(interfaceName string, reg prometheus.Registerer) (*Prometheus, error) {
+	labels := prometheus.Labels{"interface": interfaceName}
+	histogram := func(name, help string) prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+			Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		})
+	}
+	p := &Prometheus{
+		reg:                reg,
+		tickDuration:       histogram("tick_duration_seconds", "Duration of engine ticks."),
+		deviceReadDuration: histogram("device_read_duration_seconds", "Duration of reading peers usage from the wireguard device."),
+		storeWriteDuration: histogram("store_write_duration_seconds", "Duration of writing peers usage to the store."),
+		failedTicks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "failed_ticks_total",
+			Help:        "Number of engine ticks that failed, by the stage that failed.",
+			ConstLabels: labels,
+		}, []string{"stage"}),
+		peers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "peers",
+			Help:        "Number of peers gathered by the latest device read.",
+			ConstLabels: labels,
+		}),
+		restartCompensations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "restart_compensations_total",
+			Help:        "Number of peers usage compensated with their usage from before the interface restart.",
+			ConstLabels: labels,
+		}),
+		droppedTicks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "dropped_ticks_total",
+			Help:        "Number of ticks dropped as the engine was still busy with a previous one.",
+			ConstLabels: labels,
+		}),
+	}
+
+	var errs []error
+	for _, c := range p.collectors() {
+		if err := reg.Register(c); nil != err {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); nil != err {
+		p.Unregister()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Prometheus) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.tickDuration, p.deviceReadDuration, p.storeWriteDuration, p.failedTicks, p.peers, p.restartCompensations, p.droppedTicks}
+}
+
+// Unregister removes the metrics from the registry they were registered to, e.g., when their
+// interface is no longer ingested.
+func (p *Prometheus) Unregister() {
+	for _, c := range p.collectors() {
+		p.reg.Unregister(c)
+	}
+}
+
+func (p *Prometheus) TickFinished(took time.Duration) {
+	p.tickDuration.Observe(took.Seconds())
+}
+
+func (p *Prometheus) DeviceRead(took time.Duration) {
+	p.deviceReadDuration.Observe(took.Seconds())
+}
+
+func (p *Prometheus) StoreWritten(took time.Duration) {
+	p.storeWriteDuration.Observe(took.Seconds())
+}
+
+func (p *Prometheus) TickFailed(stage string) {
+	p.failedTicks.WithLabelValues(stage).Inc()
+}
+
+func (p *Prometheus) PeersGathered(n int) {
+	p.peers.Set(float64(n))
+}
+
+func (p *Prometheus) RestartCompensated(peers int) {
+	p.restartCompensations.Add(float64(peers))
+}
+
+func (p *Prometheus) TickDropped() {
+	p.droppedTicks.Inc()
+}
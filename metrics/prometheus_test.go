@@ -0,0 +1,66 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/metrics"
+)
+
+func TestPrometheus(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewPedanticRegistry()
+	p, err := metrics.NewPrometheus("wg0", reg)
+	require.NoError(t, err)
+
+	p.TickFinished(20 * time.Millisecond)
+	p.DeviceRead(time.Millisecond)
+	p.StoreWritten(15 * time.Millisecond)
+	p.TickFailed("store")
+	p.TickFailed("store")
+	p.TickFailed("device")
+	p.PeersGathered(42)
+	p.RestartCompensated(3)
+	p.TickDropped()
+
+	expected := `
+# HELP wireuse_ingest_dropped_ticks_total Number of ticks dropped as the engine was still busy with a previous one.
+# TYPE wireuse_ingest_dropped_ticks_total counter
+wireuse_ingest_dropped_ticks_total{interface="wg0"} 1
+# HELP wireuse_ingest_failed_ticks_total Number of engine ticks that failed, by the stage that failed.
+# TYPE wireuse_ingest_failed_ticks_total counter
+wireuse_ingest_failed_ticks_total{interface="wg0",stage="device"} 1
+wireuse_ingest_failed_ticks_total{interface="wg0",stage="store"} 2
+# HELP wireuse_ingest_peers Number of peers gathered by the latest device read.
+# TYPE wireuse_ingest_peers gauge
+wireuse_ingest_peers{interface="wg0"} 42
+# HELP wireuse_ingest_restart_compensations_total Number of peers usage compensated with their usage from before the interface restart.
+# TYPE wireuse_ingest_restart_compensations_total counter
+wireuse_ingest_restart_compensations_total{interface="wg0"} 3
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"wireuse_ingest_dropped_ticks_total",
+		"wireuse_ingest_failed_ticks_total",
+		"wireuse_ingest_peers",
+		"wireuse_ingest_restart_compensations_total",
+	))
+	require.Equal(t, 1, testutil.CollectAndCount(reg, "wireuse_ingest_tick_duration_seconds"))
+
+	_, err = metrics.NewPrometheus("wg0", reg)
+	require.Error(t, err, "expected registering metrics of the same interface twice to fail")
+
+	p.Unregister()
+	_, err = metrics.NewPrometheus("wg0", reg)
+	require.NoError(t, err)
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP wireuse_ingest_dropped_ticks_total Number of ticks dropped as the engine was still busy with a previous one.
+# TYPE wireuse_ingest_dropped_ticks_total counter
+wireuse_ingest_dropped_ticks_total{interface="wg0"} 0
+`), "wireuse_ingest_dropped_ticks_total"), "expected re-registered metrics to start over")
+}
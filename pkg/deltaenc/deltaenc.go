@@ -0,0 +1,111 @@
+// Package deltaenc packs usage samples, i.e., timestamps along with upload and download counters,
+// in the spirit of Gorilla: timestamps are delta-of-delta encoded and counters delta encoded, all
+// written as varints. Samples taken at a regular interval with slowly growing counters take a few
+// bytes each, instead of the tens of bytes of a BSON document.
+package deltaenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const version = 1
+
+// ErrCorrupt is returned when decoding data that was not produced by Encode.
+var ErrCorrupt = errors.New("corrupt delta-encoded samples")
+
+// Sample is a usage sample, taken at At, usually in milliseconds since the Unix epoch.
+type Sample struct {
+	At       int64
+	Upload   uint64
+	Download uint64
+}
+
+// Encode packs samples, which are usually ordered by time, although any order is supported.
+func Encode(samples []Sample) []byte {
+	out := make([]byte, 0, 1+binary.MaxVarintLen64*(1+3*len(samples)))
+	out = append(out, version)
+	out = binary.AppendUvarint(out, uint64(len(samples)))
+	var prev Sample
+	var prevDelta int64
+	for i, s := range samples {
+		if i == 0 {
+			out = binary.AppendVarint(out, s.At)
+			out = binary.AppendUvarint(out, s.Upload)
+			out = binary.AppendUvarint(out, s.Download)
+		} else {
+			delta := s.At - prev.At
+			out = binary.AppendVarint(out, delta-prevDelta)
+			// Counters usually grow, although they may decrease, so their deltas wrap around.
+			out = binary.AppendVarint(out, int64(s.Upload-prev.Upload))
+			out = binary.AppendVarint(out, int64(s.Download-prev.Download))
+			prevDelta = delta
+		}
+		prev = s
+	}
+	return out
+}
+
+// Decode unpacks the samples packed by Encode.
+func Decode(data []byte) ([]Sample, error) {
+	if len(data) == 0 || data[0] != version {
+		return nil, fmt.Errorf("%w: unsupported version", ErrCorrupt)
+	}
+	r := reader{data: data[1:]}
+	n := r.uvarint()
+	if nil != r.err || n > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: invalid sample count", ErrCorrupt)
+	}
+
+	out := make([]Sample, n)
+	var prevDelta int64
+	for i := range out {
+		if i == 0 {
+			out[i] = Sample{At: r.varint(), Upload: r.uvarint(), Download: r.uvarint()}
+		} else {
+			prev := out[i-1]
+			delta := prevDelta + r.varint()
+			out[i] = Sample{
+				At:       prev.At + delta,
+				Upload:   prev.Upload + uint64(r.varint()),
+				Download: prev.Download + uint64(r.varint()),
+			}
+			prevDelta = delta
+		}
+		if nil != r.err {
+			return nil, fmt.Errorf("%w: truncated sample %d", ErrCorrupt, i)
+		}
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(r.data))
+	}
+	return out, nil
+}
+
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrCorrupt
+		r.data = nil
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = ErrCorrupt
+		r.data = nil
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
@@ -0,0 +1,63 @@
+package deltaenc_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/pkg/deltaenc"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	for name, samples := range map[string][]deltaenc.Sample{
+		"empty":  {},
+		"single": {{At: 1677628800000, Upload: 10, Download: 20}},
+		"regular": {
+			{At: 1677628800000, Upload: 10, Download: 20},
+			{At: 1677628805000, Upload: 1000, Download: 2000},
+			{At: 1677628810000, Upload: 5000, Download: 1 << 40},
+			{At: 1677628815001, Upload: 5000, Download: 1 << 40},
+		},
+		"unordered": {
+			{At: 1677628815000, Upload: math.MaxUint64, Download: 0},
+			{At: 1677628800000, Upload: 0, Download: math.MaxUint64},
+			{At: -1, Upload: 3, Download: 2},
+		},
+	} {
+		data := deltaenc.Encode(samples)
+		decoded, err := deltaenc.Decode(data)
+		require.NoError(t, err, name)
+		require.Equal(t, samples, decoded, name)
+
+		if len(data) > 1 {
+			_, err = deltaenc.Decode(data[:len(data)-1])
+			require.ErrorIs(t, err, deltaenc.ErrCorrupt, name)
+		}
+		_, err = deltaenc.Decode(append(data, 0))
+		require.ErrorIs(t, err, deltaenc.ErrCorrupt, name)
+	}
+
+	_, err := deltaenc.Decode(nil)
+	require.ErrorIs(t, err, deltaenc.ErrCorrupt)
+	_, err = deltaenc.Decode([]byte{2, 0})
+	require.ErrorIs(t, err, deltaenc.ErrCorrupt)
+}
+
+func TestEncodeSize(t *testing.T) {
+	t.Parallel()
+
+	// An hour of samples taken every 5 seconds, of an idle peer, and of a peer transferring a few
+	// MB/s, which take about 56 bytes each as BSON documents.
+	idle := make([]deltaenc.Sample, 720)
+	busy := make([]deltaenc.Sample, 720)
+	for i := range idle {
+		at := 1677628800000 + int64(i)*5000
+		idle[i] = deltaenc.Sample{At: at, Upload: 1 << 30, Download: 1 << 32}
+		busy[i] = deltaenc.Sample{At: at, Upload: uint64(i) << 18, Download: uint64(i) * 5 << 20}
+	}
+	require.LessOrEqual(t, len(deltaenc.Encode(idle)), 3*len(idle)+32)
+	require.LessOrEqual(t, len(deltaenc.Encode(busy)), 8*len(busy)+32)
+}
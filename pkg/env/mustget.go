@@ -1,13 +1,16 @@
 package env
 
 import (
-	"os"
-
 	"github.com/rs/zerolog/log"
 )
 
+// MustGet returns the value of key resolved by DefaultResolver, exiting when it is not set or
+// cannot be resolved.
 func MustGet(key string) string {
-	v, ok := os.LookupEnv(key)
+	v, ok, err := DefaultResolver().Lookup(key)
+	if nil != err {
+		log.Fatal().Err(err).Str("key", key).Msg("failed to resolve environment variable")
+	}
 	if !ok || len(v) == 0 {
 		log.Fatal().Str("key", key).Msg("environment variable must be set with a value")
 	}
@@ -0,0 +1,103 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecretsDirKey is the environment variable holding the directory the default resolver looks
+// up secret files in, e.g., /run/secrets where Docker and Kubernetes mount secrets.
+const SecretsDirKey = "WIREUSE_SECRETS_DIR"
+
+// Resolver looks up values that can be secrets. A value of KEY is resolved from, in order:
+//   - the KEY environment variable
+//   - the file named by the KEY_FILE environment variable
+//   - the KEY, or lower-cased key, file in the secrets directory
+//
+// Files are read on every lookup, so rotated secrets are picked up by the next lookup. A single
+// trailing line break is trimmed from their content.
+type Resolver struct {
+	dir string
+}
+
+// NewResolver creates a resolver using the process environment, and looking up secret files in
+// dir, if it is not empty.
+func NewResolver(dir string) *Resolver {
+	return &Resolver{dir: dir}
+}
+
+// DefaultResolver creates a resolver that looks up secret files in the SecretsDirKey directory.
+func DefaultResolver() *Resolver {
+	return NewResolver(os.Getenv(SecretsDirKey))
+}
+
+// Lookup returns the value of key, and whether it is set. An error is returned when key is set
+// more than once, or its file cannot be read.
+func (r *Resolver) Lookup(key string) (string, bool, error) {
+	v, ok := os.LookupEnv(key)
+	fileName, fileOK := os.LookupEnv(key + "_FILE")
+	if ok && fileOK {
+		return "", false, fmt.Errorf("only one of %s and %s_FILE environment variables can be set", key, key)
+	}
+	if ok {
+		return v, true, nil
+	}
+	if fileOK {
+		v, err := r.read(fileName)
+		if nil != err {
+			return "", false, fmt.Errorf("failed to read %s_FILE: %w", key, err)
+		}
+		return v, true, nil
+	}
+	if r.dir == "" {
+		return "", false, nil
+	}
+	for _, name := range []string{key, strings.ToLower(key)} {
+		v, err := r.read(filepath.Join(r.dir, name))
+		if nil != err {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return "", false, fmt.Errorf("failed to read %s secret: %w", key, err)
+		}
+		return v, true, nil
+	}
+	return "", false, nil
+}
+
+func (r *Resolver) read(name string) (string, error) {
+	content, err := os.ReadFile(name)
+	if nil != err {
+		return "", err
+	}
+	v := strings.TrimSuffix(string(content), "\n")
+	return strings.TrimSuffix(v, "\r"), nil
+}
+
+// Watch looks up key every interval until ctx is done, and calls onChange with the new value
+// whenever it differs from the previous lookup, e.g., when a secret file is rotated. Failed
+// lookups are skipped.
+func (r *Resolver) Watch(ctx context.Context, key string, interval time.Duration, onChange func(value string)) {
+	last, _, _ := r.Lookup(key)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v, ok, err := r.Lookup(key)
+			if nil != err || !ok || v == last {
+				continue
+			}
+			last = v
+			onChange(v)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package env_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/pkg/env"
+)
+
+// Tests in this file set environment variables, so they cannot run in parallel.
+
+func TestResolverLookup(t *testing.T) {
+	dir := t.TempDir()
+	r := env.NewResolver(dir)
+
+	_, ok, err := r.Lookup("WIREUSE_TEST_SECRET")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wireuse_test_secret"), []byte("from-dir\n"), 0o600))
+	v, ok, err := r.Lookup("WIREUSE_TEST_SECRET")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "from-dir", v)
+
+	fileName := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(fileName, []byte("from-file\r\n"), 0o600))
+	t.Setenv("WIREUSE_TEST_SECRET_FILE", fileName)
+	v, _, err = r.Lookup("WIREUSE_TEST_SECRET")
+	require.NoError(t, err)
+	require.Equal(t, "from-file", v)
+
+	t.Setenv("WIREUSE_TEST_SECRET", "from-env")
+	_, _, err = r.Lookup("WIREUSE_TEST_SECRET")
+	require.ErrorContains(t, err, "only one of WIREUSE_TEST_SECRET and WIREUSE_TEST_SECRET_FILE")
+
+	t.Setenv("WIREUSE_TEST_SECRET_FILE", filepath.Join(dir, "missing"))
+	os.Unsetenv("WIREUSE_TEST_SECRET")
+	_, _, err = r.Lookup("WIREUSE_TEST_SECRET")
+	require.ErrorContains(t, err, "failed to read WIREUSE_TEST_SECRET_FILE")
+}
+
+func TestResolverWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fileName := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(fileName, []byte("old"), 0o600))
+	t.Setenv("WIREUSE_TEST_WATCHED_SECRET_FILE", fileName)
+
+	changes := make(chan string, 1)
+	go env.NewResolver("").Watch(ctx, "WIREUSE_TEST_WATCHED_SECRET", time.Millisecond, func(v string) { changes <- v })
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, os.WriteFile(fileName, []byte("new"), 0o600))
+	select {
+	case v := <-changes:
+		require.Equal(t, "new", v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("secret rotation was not detected")
+	}
+}
@@ -0,0 +1,44 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+type OpenSessionsReader interface {
+	OpenSessions(ctx context.Context, interfaceName string) ([]Session, error)
+}
+
+// Handler serves GET /sessions/online, listing the interface's currently online peers.
+// An optional publicKey query parameter narrows the result to a single peer.
+func Handler(sessions OpenSessionsReader, interfaceName string, logger zerolog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions/online", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		open, err := sessions.OpenSessions(r.Context(), interfaceName)
+		if nil != err {
+			logger.Error().Err(err).Msg("failed to query online peers")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		out := make([]Session, 0, len(open))
+		publicKey := r.URL.Query().Get("publicKey")
+		for _, s := range open {
+			if publicKey == "" || s.PublicKey == publicKey {
+				out = append(out, s)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+	return mux
+}
@@ -0,0 +1,157 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// DefaultOnlineThreshold is how long after its latest handshake a peer is still considered online.
+// WireGuard re-handshakes at least every two minutes while a session carries traffic or keepalives.
+const DefaultOnlineThreshold = 3 * time.Minute
+
+// Session is a period a peer was continuously online. End is nil while the session is open.
+type Session struct {
+	Interface string     `json:"interface" bson:"interface"`
+	PublicKey string     `json:"publicKey" bson:"publicKey"`
+	Start     time.Time  `json:"start" bson:"start"`
+	End       *time.Time `json:"end,omitempty" bson:"end"`
+	// StartUpload and StartDownload are the peer's counters when the session started.
+	StartUpload   uint `json:"-" bson:"startUpload"`
+	StartDownload uint `json:"-" bson:"startDownload"`
+	// Upload and Download are the bytes transferred during the session. They are stored when it ends,
+	// while the Tracker keeps them up to date in memory for open sessions.
+	Upload   uint `json:"upload" bson:"upload"`
+	Download uint `json:"download" bson:"download"`
+}
+
+type Store interface {
+	OpenSessions(ctx context.Context, interfaceName string) ([]Session, error)
+	StartSessions(ctx context.Context, sessions []Session) error
+	EndSessions(ctx context.Context, sessions []Session) error
+}
+
+// Tracker derives peers' online state from their latest handshake on each engine tick,
+// and records a session for each period a peer stays online.
+type Tracker struct {
+	interfaceName string
+	threshold     time.Duration
+	store         Store
+	logger        zerolog.Logger
+
+	mu   sync.Mutex
+	open map[string]Session
+}
+
+func NewTracker(interfaceName string, threshold time.Duration, store Store, logger zerolog.Logger) *Tracker {
+	if threshold <= 0 {
+		threshold = DefaultOnlineThreshold
+	}
+	return &Tracker{
+		interfaceName: interfaceName,
+		threshold:     threshold,
+		store:         store,
+		logger:        logger,
+		open:          make(map[string]Session),
+	}
+}
+
+// Load resumes sessions left open by a previous run, so they are not split by a process restart.
+func (t *Tracker) Load(ctx context.Context) error {
+	sessions, err := t.store.OpenSessions(ctx, t.interfaceName)
+	if nil != err {
+		return fmt.Errorf("failed to load open sessions: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range sessions {
+		t.open[s.PublicKey] = s
+	}
+	return nil
+}
+
+// Online returns the currently open sessions.
+func (t *Tracker) Online() []Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Session, 0, len(t.open))
+	for _, s := range t.open {
+		out = append(out, s)
+	}
+	return out
+}
+
+func (t *Tracker) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var started, ended []Session
+	seen := make(map[string]struct{}, len(peersUsage))
+	for _, p := range peersUsage {
+		seen[p.PublicKey] = struct{}{}
+		online := !p.LatestHandshake.IsZero() && gatheredAt.Sub(p.LatestHandshake) <= t.threshold
+		s, wasOnline := t.open[p.PublicKey]
+		switch {
+		case online && !wasOnline:
+			started = append(started, Session{
+				Interface:     t.interfaceName,
+				PublicKey:     p.PublicKey,
+				Start:         p.LatestHandshake,
+				StartUpload:   p.Upload,
+				StartDownload: p.Download,
+			})
+		case online && wasOnline:
+			s.Upload, s.Download = delta(p.Upload, s.StartUpload), delta(p.Download, s.StartDownload)
+			t.open[p.PublicKey] = s
+		case !online && wasOnline:
+			end := p.LatestHandshake
+			s.End = &end
+			s.Upload, s.Download = delta(p.Upload, s.StartUpload), delta(p.Download, s.StartDownload)
+			ended = append(ended, s)
+		}
+	}
+	// Peers removed from the interface are offline as well.
+	for k, s := range t.open {
+		if _, exists := seen[k]; !exists {
+			end := gatheredAt
+			s.End = &end
+			ended = append(ended, s)
+		}
+	}
+
+	if len(started) > 0 {
+		if err := t.store.StartSessions(ctx, started); nil != err {
+			t.logger.Error().Err(err).Msg("failed to store started peer sessions")
+		} else {
+			for _, s := range started {
+				t.open[s.PublicKey] = s
+				t.logger.Info().Str("public_key", s.PublicKey).Time("since", s.Start).Msg("peer came online")
+			}
+		}
+	}
+	if len(ended) > 0 {
+		if err := t.store.EndSessions(ctx, ended); nil != err {
+			t.logger.Error().Err(err).Msg("failed to store ended peer sessions")
+		} else {
+			for _, s := range ended {
+				delete(t.open, s.PublicKey)
+				t.logger.Info().Str("public_key", s.PublicKey).Time("at", *s.End).Uint("upload", s.Upload).Uint("download", s.Download).Msg("peer went offline")
+			}
+		}
+	}
+}
+
+func (t *Tracker) UsageFailed(ctx context.Context, err error, failedAt time.Time) {}
+
+func delta(current, start uint) uint {
+	if current < start {
+		return current
+	}
+	return current - start
+}
@@ -0,0 +1,164 @@
+package presence_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/presence"
+)
+
+type memoryStore struct {
+	sessions []presence.Session
+	err      error
+}
+
+func (m *memoryStore) OpenSessions(ctx context.Context, interfaceName string) ([]presence.Session, error) {
+	var out []presence.Session
+	for _, s := range m.sessions {
+		if s.Interface == interfaceName && nil == s.End {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) StartSessions(ctx context.Context, sessions []presence.Session) error {
+	if nil != m.err {
+		return m.err
+	}
+	m.sessions = append(m.sessions, sessions...)
+	return nil
+}
+
+func (m *memoryStore) EndSessions(ctx context.Context, sessions []presence.Session) error {
+	if nil != m.err {
+		return m.err
+	}
+	for _, ended := range sessions {
+		for i, s := range m.sessions {
+			if s.PublicKey == ended.PublicKey && s.Start.Equal(ended.Start) {
+				m.sessions[i] = ended
+			}
+		}
+	}
+	return nil
+}
+
+func TestTrackerSessions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	store := &memoryStore{}
+	tracker := presence.NewTracker("wg0", time.Minute, store, zerolog.New(io.Discard))
+
+	now := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{Upload: 10, Download: 20, PublicKey: "xyz", LatestHandshake: now.Add(-10 * time.Second)},
+		{Upload: 10, Download: 20, PublicKey: "abc", LatestHandshake: now.Add(-time.Hour)},
+		{Upload: 0, Download: 0, PublicKey: "def"},
+	}, now)
+	require.Len(t, store.sessions, 1)
+	require.Equal(t, "xyz", store.sessions[0].PublicKey)
+	require.Equal(t, now.Add(-10*time.Second), store.sessions[0].Start)
+	require.Nil(t, store.sessions[0].End)
+
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{Upload: 110, Download: 320, PublicKey: "xyz", LatestHandshake: now.Add(50 * time.Second)},
+	}, now.Add(time.Minute))
+	online := tracker.Online()
+	require.Len(t, online, 1)
+	require.Equal(t, uint(100), online[0].Upload)
+	require.Equal(t, uint(300), online[0].Download)
+
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{Upload: 150, Download: 320, PublicKey: "xyz", LatestHandshake: now.Add(50 * time.Second)},
+	}, now.Add(5*time.Minute))
+	require.Empty(t, tracker.Online())
+	require.Len(t, store.sessions, 1)
+	require.NotNil(t, store.sessions[0].End)
+	require.Equal(t, now.Add(50*time.Second), *store.sessions[0].End)
+	require.Equal(t, uint(140), store.sessions[0].Upload)
+	require.Equal(t, uint(300), store.sessions[0].Download)
+}
+
+func TestTrackerRemovedPeerAndResume(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	now := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{sessions: []presence.Session{
+		{Interface: "wg0", PublicKey: "xyz", Start: now.Add(-time.Hour), StartUpload: 100, StartDownload: 100},
+		{Interface: "wg1", PublicKey: "abc", Start: now.Add(-time.Hour)},
+	}}
+	tracker := presence.NewTracker("wg0", time.Minute, store, zerolog.New(io.Discard))
+	require.NoError(t, tracker.Load(ctx))
+	require.Len(t, tracker.Online(), 1)
+
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{Upload: 500, Download: 600, PublicKey: "xyz", LatestHandshake: now},
+	}, now)
+	require.Len(t, store.sessions, 2, "expected resumed session not to be started again")
+
+	tracker.UsageIngested(ctx, nil, now.Add(time.Second))
+	require.Empty(t, tracker.Online())
+	require.Equal(t, now.Add(time.Second), *store.sessions[0].End)
+	require.Equal(t, uint(400), store.sessions[0].Upload)
+	require.Equal(t, uint(500), store.sessions[0].Download)
+}
+
+func TestTrackerRetriesFailedWrites(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	now := time.Now()
+	store := &memoryStore{err: errors.New("connection refused")}
+	tracker := presence.NewTracker("wg0", time.Minute, store, zerolog.New(io.Discard))
+
+	peers := []ingest.PeerUsage{{PublicKey: "xyz", LatestHandshake: now}}
+	tracker.UsageIngested(ctx, peers, now)
+	require.Empty(t, tracker.Online())
+
+	store.err = nil
+	tracker.UsageIngested(ctx, peers, now.Add(time.Second))
+	require.Len(t, tracker.Online(), 1)
+	require.Len(t, store.sessions, 1)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	store := &memoryStore{sessions: []presence.Session{
+		{Interface: "wg0", PublicKey: "xyz", Start: now},
+		{Interface: "wg0", PublicKey: "abc", Start: now},
+		{Interface: "wg0", PublicKey: "def", Start: now, End: &now},
+	}}
+	srv := httptest.NewServer(presence.Handler(store, "wg0", zerolog.New(io.Discard)))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/sessions/online")
+	require.NoError(t, err)
+	var all []presence.Session
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&all))
+	res.Body.Close()
+	require.Len(t, all, 2)
+
+	res, err = http.Get(srv.URL + "/sessions/online?publicKey=abc")
+	require.NoError(t, err)
+	var one []presence.Session
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&one))
+	res.Body.Close()
+	require.Len(t, one, 1)
+	require.Equal(t, "abc", one[0].PublicKey)
+	require.Equal(t, now, one[0].Start)
+}
@@ -0,0 +1,66 @@
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+type createPeerRequest struct {
+	Name string `json:"name"`
+}
+
+// Handler serves the provisioning API:
+//
+//	GET  /peers  lists provisioned peers
+//	POST /peers  creates a peer from a {"name": "..."} body and returns its client config and QR code
+func Handler(p *Provisioner, logger zerolog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			peers, err := p.Peers(r.Context())
+			if nil != err {
+				logger.Error().Err(err).Msg("failed to list peers")
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list peers"})
+				return
+			}
+			if nil == peers {
+				peers = []Peer{}
+			}
+			writeJSON(w, http.StatusOK, peers)
+		case http.MethodPost:
+			var req createPeerRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); nil != err {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+				return
+			}
+			provisioned, err := p.Create(r.Context(), req.Name)
+			if nil != err {
+				switch {
+				case errors.Is(err, ErrInvalidName):
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				case errors.Is(err, ErrPoolExhausted):
+					writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+				default:
+					logger.Error().Err(err).Msg("failed to provision peer")
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to provision peer"})
+				}
+				return
+			}
+			writeJSON(w, http.StatusCreated, provisioned)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,333 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/skip2/go-qrcode"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	ErrPoolExhausted = errors.New("address pool is exhausted")
+	ErrInvalidName   = errors.New("peer name cannot be empty")
+)
+
+type Config struct {
+	// Pool is the prefix client addresses are allocated from, e.g. 10.8.0.0/24.
+	Pool string `json:"pool"`
+	// Reserved lists addresses within Pool that must never be allocated, such as the server's own address.
+	Reserved []string `json:"reserved,omitempty"`
+	// Endpoint is the public host:port clients connect to.
+	Endpoint string `json:"endpoint"`
+	// DNS servers written to client configurations.
+	DNS []string `json:"dns,omitempty"`
+	// ClientAllowedIPs are the routes written to client configurations. Defaults to all traffic.
+	ClientAllowedIPs []string `json:"clientAllowedIPs,omitempty"`
+	// PersistentKeepalive in seconds, written to both the client configuration and the server-side peer.
+	PersistentKeepalive int `json:"persistentKeepalive,omitempty"`
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if _, err := netip.ParsePrefix(c.Pool); nil != err {
+		errs = append(errs, fmt.Errorf("invalid pool: %w", err))
+	}
+	for _, r := range c.Reserved {
+		if _, err := netip.ParseAddr(r); nil != err {
+			errs = append(errs, fmt.Errorf("invalid reserved address: %w", err))
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); nil != err {
+		errs = append(errs, fmt.Errorf("invalid endpoint: %w", err))
+	}
+	for _, r := range c.ClientAllowedIPs {
+		if _, err := netip.ParsePrefix(r); nil != err {
+			errs = append(errs, fmt.Errorf("invalid client allowed ip: %w", err))
+		}
+	}
+	if c.PersistentKeepalive < 0 {
+		errs = append(errs, errors.New("persistent keepalive cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read provisioning config file: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(content, &c); nil != err {
+		return nil, fmt.Errorf("failed to parse provisioning config file: %w", err)
+	}
+	if err := c.Validate(); nil != err {
+		return nil, fmt.Errorf("invalid provisioning config: %w", err)
+	}
+	return &c, nil
+}
+
+// Peer is the server-side record of a provisioned peer. Its private key is never stored.
+type Peer struct {
+	Interface           string    `json:"interface" bson:"interface"`
+	Name                string    `json:"name" bson:"name"`
+	PublicKey           string    `json:"publicKey" bson:"publicKey"`
+	PresharedKey        string    `json:"-" bson:"presharedKey"`
+	AllowedIPs          []string  `json:"allowedIPs" bson:"allowedIPs"`
+	PersistentKeepalive int       `json:"persistentKeepalive,omitempty" bson:"persistentKeepalive,omitempty"`
+	CreatedAt           time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+type PeerStore interface {
+	ListPeers(ctx context.Context, interfaceName string) ([]Peer, error)
+	InsertPeer(ctx context.Context, peer Peer) error
+}
+
+type Device interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+type Provisioned struct {
+	Peer Peer `json:"peer"`
+	// ClientConfig is a wg-quick configuration file containing the peer's private key.
+	ClientConfig string `json:"clientConfig"`
+	// QRCode is a PNG encoding of ClientConfig, suitable for mobile clients.
+	QRCode []byte `json:"qrCode"`
+}
+
+type Provisioner struct {
+	interfaceName string
+	cfg           Config
+	pool          netip.Prefix
+	device        Device
+	store         PeerStore
+	logger        zerolog.Logger
+
+	mu sync.Mutex
+}
+
+func NewProvisioner(interfaceName string, cfg Config, device Device, store PeerStore, logger zerolog.Logger) (*Provisioner, error) {
+	if err := cfg.Validate(); nil != err {
+		return nil, err
+	}
+	if len(cfg.ClientAllowedIPs) == 0 {
+		cfg.ClientAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+	return &Provisioner{
+		interfaceName: interfaceName,
+		cfg:           cfg,
+		pool:          netip.MustParsePrefix(cfg.Pool).Masked(),
+		device:        device,
+		store:         store,
+		logger:        logger,
+	}, nil
+}
+
+func (p *Provisioner) Peers(ctx context.Context) ([]Peer, error) {
+	return p.store.ListPeers(ctx, p.interfaceName)
+}
+
+// Create generates keys for a new peer, allocates it an address, adds it to the device and stores it.
+func (p *Provisioner) Create(ctx context.Context, name string) (*Provisioned, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidName
+	}
+
+	// Serializes allocations, so concurrent requests cannot be given the same address.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dev, err := p.device.Device(p.interfaceName)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read device: %w", err)
+	}
+	stored, err := p.store.ListPeers(ctx, p.interfaceName)
+	if nil != err {
+		return nil, fmt.Errorf("failed to list stored peers: %w", err)
+	}
+	addr, err := p.allocate(dev, stored)
+	if nil != err {
+		return nil, err
+	}
+
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	if nil != err {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	presharedKey, err := wgtypes.GenerateKey()
+	if nil != err {
+		return nil, fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+
+	peer := Peer{
+		Interface:           p.interfaceName,
+		Name:                name,
+		PublicKey:           privateKey.PublicKey().String(),
+		PresharedKey:        presharedKey.String(),
+		AllowedIPs:          []string{netip.PrefixFrom(addr, addr.BitLen()).String()},
+		PersistentKeepalive: p.cfg.PersistentKeepalive,
+		CreatedAt:           time.Now(),
+	}
+
+	peerCfg, err := PeerConfig(peer)
+	if nil != err {
+		return nil, err
+	}
+	if err := p.device.ConfigureDevice(p.interfaceName, wgtypes.Config{Peers: []wgtypes.PeerConfig{peerCfg}}); nil != err {
+		return nil, fmt.Errorf("failed to add peer to device: %w", err)
+	}
+	if err := p.store.InsertPeer(ctx, peer); nil != err {
+		if err := p.device.ConfigureDevice(p.interfaceName, wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: privateKey.PublicKey(), Remove: true}}}); nil != err {
+			p.logger.Error().Err(err).Str("public_key", peer.PublicKey).Msg("failed to remove unstored peer from device")
+		}
+		return nil, fmt.Errorf("failed to store peer: %w", err)
+	}
+
+	clientCfg, err := p.render(dev.PublicKey, privateKey, presharedKey, addr)
+	if nil != err {
+		return nil, err
+	}
+	qr, err := qrcode.Encode(clientCfg, qrcode.Medium, 512)
+	if nil != err {
+		return nil, fmt.Errorf("failed to encode client config qr code: %w", err)
+	}
+
+	p.logger.Info().Str("public_key", peer.PublicKey).Str("name", name).Str("address", addr.String()).Msg("peer provisioned")
+	return &Provisioned{Peer: peer, ClientConfig: clientCfg, QRCode: qr}, nil
+}
+
+// allocate returns the first address of the pool that is neither reserved nor contained in the
+// allowed IPs of a device or stored peer, which may be wider than a single address, e.g., the
+// subnet routed to a site-to-site peer.
+func (p *Provisioner) allocate(dev *wgtypes.Device, stored []Peer) (netip.Addr, error) {
+	used := make(map[netip.Addr]struct{})
+	var routed []netip.Prefix
+	use := func(prefix netip.Prefix) {
+		if prefix.IsSingleIP() {
+			used[prefix.Addr()] = struct{}{}
+		} else if prefix.Overlaps(p.pool) {
+			routed = append(routed, prefix)
+		}
+	}
+	for _, r := range p.cfg.Reserved {
+		used[netip.MustParseAddr(r)] = struct{}{}
+	}
+	for _, peer := range dev.Peers {
+		for _, ipNet := range peer.AllowedIPs {
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ones, bits := ipNet.Mask.Size()
+			if addr = addr.Unmap(); addr.Is4() && bits == 128 {
+				ones -= 96
+			}
+			if prefix, err := addr.Prefix(ones); nil == err {
+				use(prefix)
+			}
+		}
+	}
+	for _, peer := range stored {
+		for _, allowedIP := range peer.AllowedIPs {
+			if prefix, err := netip.ParsePrefix(strings.TrimSpace(allowedIP)); nil == err {
+				use(prefix.Masked())
+			}
+		}
+	}
+
+	taken := func(addr netip.Addr) bool {
+		if _, exists := used[addr]; exists {
+			return true
+		}
+		for _, prefix := range routed {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Skips the network address, and the broadcast address of IPv4 pools.
+	for addr := p.pool.Addr().Next(); p.pool.Contains(addr); addr = addr.Next() {
+		if addr.Is4() && !p.pool.Contains(addr.Next()) && p.pool.Bits() < 31 {
+			break
+		}
+		if !taken(addr) {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, ErrPoolExhausted
+}
+
+// PeerConfig converts a stored peer to its server-side device configuration.
+func PeerConfig(peer Peer) (wgtypes.PeerConfig, error) {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if nil != err {
+		return wgtypes.PeerConfig{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	out := wgtypes.PeerConfig{PublicKey: publicKey, ReplaceAllowedIPs: true}
+	if peer.PresharedKey != "" {
+		presharedKey, err := wgtypes.ParseKey(peer.PresharedKey)
+		if nil != err {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid preshared key: %w", err)
+		}
+		out.PresharedKey = &presharedKey
+	}
+	for _, allowedIP := range peer.AllowedIPs {
+		_, ipNet, err := net.ParseCIDR(allowedIP)
+		if nil != err {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed ip: %w", err)
+		}
+		out.AllowedIPs = append(out.AllowedIPs, *ipNet)
+	}
+	keepalive := time.Duration(peer.PersistentKeepalive) * time.Second
+	out.PersistentKeepaliveInterval = &keepalive
+	return out, nil
+}
+
+var clientConfigTemplate = template.Must(template.New("client").Parse(`[Interface]
+PrivateKey = {{.PrivateKey}}
+Address = {{.Address}}
+{{- if .DNS}}
+DNS = {{.DNS}}
+{{- end}}
+
+[Peer]
+PublicKey = {{.ServerPublicKey}}
+PresharedKey = {{.PresharedKey}}
+Endpoint = {{.Endpoint}}
+AllowedIPs = {{.AllowedIPs}}
+{{- if .PersistentKeepalive}}
+PersistentKeepalive = {{.PersistentKeepalive}}
+{{- end}}
+`))
+
+func (p *Provisioner) render(serverPublicKey, privateKey, presharedKey wgtypes.Key, addr netip.Addr) (string, error) {
+	var b bytes.Buffer
+	err := clientConfigTemplate.Execute(&b, map[string]any{
+		"PrivateKey":          privateKey.String(),
+		"Address":             netip.PrefixFrom(addr, p.pool.Bits()).String(),
+		"DNS":                 strings.Join(p.cfg.DNS, ", "),
+		"ServerPublicKey":     serverPublicKey.String(),
+		"PresharedKey":        presharedKey.String(),
+		"Endpoint":            p.cfg.Endpoint,
+		"AllowedIPs":          strings.Join(p.cfg.ClientAllowedIPs, ", "),
+		"PersistentKeepalive": p.cfg.PersistentKeepalive,
+	})
+	if nil != err {
+		return "", fmt.Errorf("failed to render client config: %w", err)
+	}
+	return b.String(), nil
+}
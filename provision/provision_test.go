@@ -0,0 +1,239 @@
+package provision_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/xeptore/wireuse/provision"
+)
+
+type fakeDevice struct {
+	mu      sync.Mutex
+	dev     wgtypes.Device
+	configs []wgtypes.Config
+}
+
+func newFakeDevice(t *testing.T, allowedIPs ...string) *fakeDevice {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	d := &fakeDevice{dev: wgtypes.Device{Name: "wg0", PrivateKey: key, PublicKey: key.PublicKey()}}
+	for _, a := range allowedIPs {
+		_, ipNet, err := net.ParseCIDR(a)
+		require.NoError(t, err)
+		d.dev.Peers = append(d.dev.Peers, wgtypes.Peer{AllowedIPs: []net.IPNet{*ipNet}})
+	}
+	return d
+}
+
+func (d *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dev := d.dev
+	return &dev, nil
+}
+
+func (d *fakeDevice) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.configs = append(d.configs, cfg)
+	for _, p := range cfg.Peers {
+		peers := make([]wgtypes.Peer, 0, len(d.dev.Peers)+1)
+		for _, existing := range d.dev.Peers {
+			if existing.PublicKey != p.PublicKey {
+				peers = append(peers, existing)
+			}
+		}
+		d.dev.Peers = peers
+		if p.Remove {
+			continue
+		}
+		peer := wgtypes.Peer{PublicKey: p.PublicKey, AllowedIPs: p.AllowedIPs}
+		if nil != p.PresharedKey {
+			peer.PresharedKey = *p.PresharedKey
+		}
+		if nil != p.PersistentKeepaliveInterval {
+			peer.PersistentKeepaliveInterval = *p.PersistentKeepaliveInterval
+		}
+		d.dev.Peers = append(d.dev.Peers, peer)
+	}
+	return nil
+}
+
+type memoryPeers struct {
+	mu    sync.Mutex
+	peers []provision.Peer
+	err   error
+}
+
+func (m *memoryPeers) ListPeers(ctx context.Context, interfaceName string) ([]provision.Peer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]provision.Peer(nil), m.peers...), nil
+}
+
+func (m *memoryPeers) InsertPeer(ctx context.Context, peer provision.Peer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if nil != m.err {
+		return m.err
+	}
+	m.peers = append(m.peers, peer)
+	return nil
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dev := newFakeDevice(t, "10.8.0.2/32")
+	peers := &memoryPeers{peers: []provision.Peer{{Interface: "wg0", AllowedIPs: []string{"10.8.0.3/32"}}}}
+	cfg := provision.Config{
+		Pool:                "10.8.0.0/24",
+		Reserved:            []string{"10.8.0.1"},
+		Endpoint:            "vpn.example.com:51820",
+		DNS:                 []string{"1.1.1.1"},
+		PersistentKeepalive: 25,
+	}
+	p, err := provision.NewProvisioner("wg0", cfg, dev, peers, zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	out, err := p.Create(ctx, "alice-phone")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.8.0.4/32"}, out.Peer.AllowedIPs)
+	require.Equal(t, "alice-phone", out.Peer.Name)
+	require.Len(t, peers.peers, 2)
+	require.Equal(t, out.Peer, peers.peers[1])
+
+	require.Len(t, dev.configs, 1)
+	require.Len(t, dev.configs[0].Peers, 1)
+	peerCfg := dev.configs[0].Peers[0]
+	require.Equal(t, out.Peer.PublicKey, peerCfg.PublicKey.String())
+	require.Equal(t, out.Peer.PresharedKey, peerCfg.PresharedKey.String())
+	require.Equal(t, "10.8.0.4/32", peerCfg.AllowedIPs[0].String())
+
+	require.Contains(t, out.ClientConfig, "Address = 10.8.0.4/24\n")
+	require.Contains(t, out.ClientConfig, "DNS = 1.1.1.1\n")
+	require.Contains(t, out.ClientConfig, "PublicKey = "+dev.dev.PublicKey.String()+"\n")
+	require.Contains(t, out.ClientConfig, "PresharedKey = "+out.Peer.PresharedKey+"\n")
+	require.Contains(t, out.ClientConfig, "Endpoint = vpn.example.com:51820\n")
+	require.Contains(t, out.ClientConfig, "AllowedIPs = 0.0.0.0/0, ::/0\n")
+	require.Contains(t, out.ClientConfig, "PersistentKeepalive = 25\n")
+
+	var privateKey string
+	for _, line := range strings.Split(out.ClientConfig, "\n") {
+		if strings.HasPrefix(line, "PrivateKey = ") {
+			privateKey = strings.TrimPrefix(line, "PrivateKey = ")
+		}
+	}
+	key, err := wgtypes.ParseKey(privateKey)
+	require.NoError(t, err)
+	require.Equal(t, out.Peer.PublicKey, key.PublicKey().String())
+
+	_, err = png.Decode(bytes.NewReader(out.QRCode))
+	require.NoError(t, err)
+}
+
+func TestCreatePoolExhausted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dev := newFakeDevice(t)
+	cfg := provision.Config{Pool: "10.8.0.0/30", Reserved: []string{"10.8.0.1"}, Endpoint: "vpn.example.com:51820"}
+	p, err := provision.NewProvisioner("wg0", cfg, dev, &memoryPeers{}, zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	out, err := p.Create(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.8.0.2/32"}, out.Peer.AllowedIPs)
+
+	_, err = p.Create(ctx, "second")
+	require.ErrorIs(t, err, provision.ErrPoolExhausted)
+}
+
+func TestCreateSkipsRoutedSubnets(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// A site-to-site peer is routed the first half of the pool, and a stored one 10.8.0.128/30.
+	dev := newFakeDevice(t, "10.8.0.0/25")
+	peers := &memoryPeers{peers: []provision.Peer{{Interface: "wg0", AllowedIPs: []string{"10.8.0.130/30"}}}}
+	cfg := provision.Config{Pool: "10.8.0.0/24", Endpoint: "vpn.example.com:51820"}
+	p, err := provision.NewProvisioner("wg0", cfg, dev, peers, zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	out, err := p.Create(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.8.0.132/32"}, out.Peer.AllowedIPs)
+}
+
+func TestCreateRollsBackDeviceOnStoreFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dev := newFakeDevice(t)
+	cfg := provision.Config{Pool: "10.8.0.0/24", Endpoint: "vpn.example.com:51820"}
+	p, err := provision.NewProvisioner("wg0", cfg, dev, &memoryPeers{err: errors.New("duplicate key")}, zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	_, err = p.Create(ctx, "alice")
+	require.ErrorContains(t, err, "duplicate key")
+	require.Len(t, dev.configs, 2)
+	require.True(t, dev.configs[1].Peers[0].Remove)
+	require.Equal(t, dev.configs[0].Peers[0].PublicKey, dev.configs[1].Peers[0].PublicKey)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	dev := newFakeDevice(t)
+	cfg := provision.Config{Pool: "10.8.0.0/24", Endpoint: "vpn.example.com:51820"}
+	p, err := provision.NewProvisioner("wg0", cfg, dev, &memoryPeers{}, zerolog.New(io.Discard))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(provision.Handler(p, zerolog.New(io.Discard)))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/peers", "application/json", strings.NewReader(`{"name":""}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Post(srv.URL+"/peers", "application/json", strings.NewReader(`{"name":"alice"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		Peer         map[string]any `json:"peer"`
+		ClientConfig string         `json:"clientConfig"`
+		QRCode       []byte         `json:"qrCode"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	res.Body.Close()
+	require.Equal(t, "alice", created.Peer["name"])
+	require.NotContains(t, created.Peer, "presharedKey")
+	require.Contains(t, created.ClientConfig, "[Interface]")
+	require.NotEmpty(t, created.QRCode)
+
+	res, err = http.Get(srv.URL + "/peers")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var listed []provision.Peer
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+	res.Body.Close()
+	require.Len(t, listed, 1)
+	require.Equal(t, "alice", listed[0].Name)
+}
@@ -0,0 +1,193 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Diff lists the changes needed to bring a device in line with the stored peers.
+type Diff struct {
+	Add    []Peer
+	Update []Peer
+	Remove []string
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Add) == 0 && len(d.Update) == 0 && len(d.Remove) == 0
+}
+
+// Plan compares the device's peers against the stored peers, which are the source of truth.
+func Plan(dev *wgtypes.Device, stored []Peer) Diff {
+	current := make(map[string]wgtypes.Peer, len(dev.Peers))
+	for _, p := range dev.Peers {
+		current[p.PublicKey.String()] = p
+	}
+
+	var diff Diff
+	desired := make(map[string]struct{}, len(stored))
+	for _, p := range stored {
+		desired[p.PublicKey] = struct{}{}
+		c, exists := current[p.PublicKey]
+		if !exists {
+			diff.Add = append(diff.Add, p)
+			continue
+		}
+		if !matches(c, p) {
+			diff.Update = append(diff.Update, p)
+		}
+	}
+	for k := range current {
+		if _, exists := desired[k]; !exists {
+			diff.Remove = append(diff.Remove, k)
+		}
+	}
+	sort.Strings(diff.Remove)
+	return diff
+}
+
+func matches(c wgtypes.Peer, p Peer) bool {
+	if c.PersistentKeepaliveInterval != time.Duration(p.PersistentKeepalive)*time.Second {
+		return false
+	}
+	if p.PresharedKey != "" && c.PresharedKey.String() != p.PresharedKey {
+		return false
+	}
+	if len(c.AllowedIPs) != len(p.AllowedIPs) {
+		return false
+	}
+	// Stored allowed IPs are compared in their canonical form, as the device reports them.
+	allowedIPs := make(map[netip.Prefix]struct{}, len(c.AllowedIPs))
+	for _, ipNet := range c.AllowedIPs {
+		if prefix, err := netip.ParsePrefix(ipNet.String()); nil == err {
+			allowedIPs[prefix.Masked()] = struct{}{}
+		}
+	}
+	for _, a := range p.AllowedIPs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(a))
+		if nil != err {
+			return false
+		}
+		if _, exists := allowedIPs[prefix.Masked()]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrRemovingAllPeers is returned by Reconcile when it would remove every peer of the interface,
+// e.g., as the stored peers were lost or read from the wrong database, unless it is allowed to.
+var ErrRemovingAllPeers = errors.New("refusing to remove all peers of the interface")
+
+type Reconciler struct {
+	interfaceName string
+	device        Device
+	store         PeerStore
+	dryRun        bool
+	removeAll     bool
+	logger        zerolog.Logger
+
+	mu *sync.Mutex
+}
+
+func NewReconciler(interfaceName string, device Device, store PeerStore, dryRun bool, logger zerolog.Logger) *Reconciler {
+	return &Reconciler{
+		interfaceName: interfaceName,
+		device:        device,
+		store:         store,
+		dryRun:        dryRun,
+		logger:        logger,
+		mu:            &sync.Mutex{},
+	}
+}
+
+// AllowRemovingAll lets Reconcile remove every peer of the interface when no peer is stored. It
+// must be called before the reconciler is used.
+func (r *Reconciler) AllowRemovingAll() {
+	r.removeAll = true
+}
+
+// Reconciler returns a reconciler of p's interface that never runs concurrently with Create,
+// which would otherwise see a freshly added peer as unknown before it is stored.
+func (p *Provisioner) Reconciler(dryRun bool) *Reconciler {
+	r := NewReconciler(p.interfaceName, p.device, p.store, dryRun, p.logger)
+	r.mu = &p.mu
+	return r
+}
+
+// Reconcile applies a single pass, returning the diff it found. In dry-run mode the diff is only logged.
+func (r *Reconciler) Reconcile(ctx context.Context) (Diff, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dev, err := r.device.Device(r.interfaceName)
+	if nil != err {
+		return Diff{}, fmt.Errorf("failed to read device: %w", err)
+	}
+	stored, err := r.store.ListPeers(ctx, r.interfaceName)
+	if nil != err {
+		return Diff{}, fmt.Errorf("failed to list stored peers: %w", err)
+	}
+
+	diff := Plan(dev, stored)
+	if len(stored) == 0 && len(diff.Remove) > 0 && !r.removeAll {
+		return diff, fmt.Errorf("%w: none of its %d peers is stored", ErrRemovingAllPeers, len(diff.Remove))
+	}
+	for _, p := range diff.Add {
+		r.logger.Info().Bool("dry_run", r.dryRun).Str("public_key", p.PublicKey).Strs("allowed_ips", p.AllowedIPs).Msg("reconcile: adding missing peer")
+	}
+	for _, p := range diff.Update {
+		r.logger.Info().Bool("dry_run", r.dryRun).Str("public_key", p.PublicKey).Strs("allowed_ips", p.AllowedIPs).Int("persistent_keepalive", p.PersistentKeepalive).Msg("reconcile: updating drifted peer")
+	}
+	for _, k := range diff.Remove {
+		r.logger.Info().Bool("dry_run", r.dryRun).Str("public_key", k).Msg("reconcile: removing unknown peer")
+	}
+	if diff.Empty() || r.dryRun {
+		r.logger.Debug().Bool("dry_run", r.dryRun).Int("add", len(diff.Add)).Int("update", len(diff.Update)).Int("remove", len(diff.Remove)).Msg("reconcile pass finished")
+		return diff, nil
+	}
+
+	peerCfgs := make([]wgtypes.PeerConfig, 0, len(diff.Add)+len(diff.Update)+len(diff.Remove))
+	for _, p := range append(append([]Peer(nil), diff.Add...), diff.Update...) {
+		peerCfg, err := PeerConfig(p)
+		if nil != err {
+			return diff, fmt.Errorf("invalid stored peer %s: %w", p.PublicKey, err)
+		}
+		peerCfgs = append(peerCfgs, peerCfg)
+	}
+	for _, k := range diff.Remove {
+		key, err := wgtypes.ParseKey(k)
+		if nil != err {
+			return diff, fmt.Errorf("invalid device peer key %s: %w", k, err)
+		}
+		peerCfgs = append(peerCfgs, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+	if err := r.device.ConfigureDevice(r.interfaceName, wgtypes.Config{Peers: peerCfgs}); nil != err {
+		return diff, fmt.Errorf("failed to configure device: %w", err)
+	}
+	r.logger.Info().Int("add", len(diff.Add)).Int("update", len(diff.Update)).Int("remove", len(diff.Remove)).Msg("reconcile pass applied")
+	return diff, nil
+}
+
+// Run reconciles on every tick until tick is closed or ctx is canceled.
+func (r *Reconciler) Run(ctx context.Context, tick <-chan struct{}) error {
+	for range tick {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			if _, err := r.Reconcile(ctx); nil != err {
+				r.logger.Error().Err(err).Msg("failed to reconcile peers")
+			}
+		}
+	}
+	return nil
+}
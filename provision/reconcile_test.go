@@ -0,0 +1,168 @@
+package provision_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/xeptore/wireuse/provision"
+)
+
+func generatePeer(t *testing.T, allowedIP string, keepalive int) provision.Peer {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	return provision.Peer{
+		Interface:           "wg0",
+		PublicKey:           key.PublicKey().String(),
+		PresharedKey:        psk.String(),
+		AllowedIPs:          []string{allowedIP},
+		PersistentKeepalive: keepalive,
+	}
+}
+
+func devicePeer(t *testing.T, p provision.Peer) wgtypes.Peer {
+	t.Helper()
+	cfg, err := provision.PeerConfig(p)
+	require.NoError(t, err)
+	return wgtypes.Peer{
+		PublicKey:                   cfg.PublicKey,
+		PresharedKey:                *cfg.PresharedKey,
+		AllowedIPs:                  cfg.AllowedIPs,
+		PersistentKeepaliveInterval: *cfg.PersistentKeepaliveInterval,
+	}
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	inSync := generatePeer(t, "10.8.0.2/32", 25)
+	missing := generatePeer(t, "10.8.0.3/32", 25)
+	wrongIPs := generatePeer(t, "10.8.0.4/32", 25)
+	wrongKeepalive := generatePeer(t, "10.8.0.5/32", 25)
+	unknown := generatePeer(t, "10.8.0.6/32", 0)
+
+	wrongIPsOnDevice := devicePeer(t, wrongIPs)
+	_, ipNet, err := net.ParseCIDR("10.8.0.40/32")
+	require.NoError(t, err)
+	wrongIPsOnDevice.AllowedIPs = append(wrongIPsOnDevice.AllowedIPs, *ipNet)
+	wrongKeepaliveOnDevice := devicePeer(t, wrongKeepalive)
+	wrongKeepaliveOnDevice.PersistentKeepaliveInterval = 0
+
+	dev := &wgtypes.Device{Peers: []wgtypes.Peer{
+		devicePeer(t, inSync),
+		wrongIPsOnDevice,
+		wrongKeepaliveOnDevice,
+		devicePeer(t, unknown),
+	}}
+	diff := provision.Plan(dev, []provision.Peer{inSync, missing, wrongIPs, wrongKeepalive})
+
+	require.Equal(t, []provision.Peer{missing}, diff.Add)
+	require.Equal(t, []provision.Peer{wrongIPs, wrongKeepalive}, diff.Update)
+	require.Equal(t, []string{unknown.PublicKey}, diff.Remove)
+	require.True(t, provision.Plan(dev, []provision.Peer{inSync}).Update == nil)
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	stored := generatePeer(t, "10.8.0.2/32", 25)
+	dev := newFakeDevice(t, "10.8.0.9/32")
+	peers := &memoryPeers{peers: []provision.Peer{stored}}
+
+	dryRun := provision.NewReconciler("wg0", dev, peers, true, zerolog.New(io.Discard))
+	diff, err := dryRun.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Len(t, diff.Add, 1)
+	require.Len(t, diff.Remove, 1)
+	require.Empty(t, dev.configs, "expected dry-run not to configure the device")
+
+	r := provision.NewReconciler("wg0", dev, peers, false, zerolog.New(io.Discard))
+	diff, err = r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.False(t, diff.Empty())
+	require.Len(t, dev.configs, 1)
+	require.Len(t, dev.dev.Peers, 1)
+	require.Equal(t, stored.PublicKey, dev.dev.Peers[0].PublicKey.String())
+	require.Equal(t, 25*time.Second, dev.dev.Peers[0].PersistentKeepaliveInterval)
+
+	diff, err = r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+	require.Len(t, dev.configs, 1, "expected in-sync device not to be configured again")
+}
+
+func TestPlanMatchesNonCanonicalAllowedIPs(t *testing.T) {
+	t.Parallel()
+
+	v4 := generatePeer(t, "10.8.0.2/32", 25)
+	v6 := generatePeer(t, "fd00::2/128", 25)
+	dev := &wgtypes.Device{Peers: []wgtypes.Peer{devicePeer(t, v4), devicePeer(t, v6)}}
+
+	v4.AllowedIPs = []string{" 10.8.0.2/32 "}
+	v6.AllowedIPs = []string{"fd00:0:0:0::2/128"}
+	require.True(t, provision.Plan(dev, []provision.Peer{v4, v6}).Empty())
+
+	v4.AllowedIPs = []string{"10.8.0.3/32"}
+	require.Equal(t, []provision.Peer{v4}, provision.Plan(dev, []provision.Peer{v4, v6}).Update)
+}
+
+func TestReconcileRefusesToRemoveAllPeers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dev := newFakeDevice(t)
+	dev.dev.Peers = []wgtypes.Peer{devicePeer(t, generatePeer(t, "10.8.0.2/32", 25)), devicePeer(t, generatePeer(t, "10.8.0.3/32", 25))}
+	r := provision.NewReconciler("wg0", dev, &memoryPeers{}, false, zerolog.New(io.Discard))
+	_, err := r.Reconcile(ctx)
+	require.ErrorIs(t, err, provision.ErrRemovingAllPeers)
+	require.Empty(t, dev.configs)
+	require.Len(t, dev.dev.Peers, 2)
+
+	r.AllowRemovingAll()
+	diff, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Len(t, diff.Remove, 2)
+	require.Empty(t, dev.dev.Peers)
+}
+
+func TestProvisionerReconcilerKeepsCreatedPeers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dev := newFakeDevice(t)
+	peers := &memoryPeers{}
+	p, err := provision.NewProvisioner("wg0", provision.Config{Pool: "10.8.0.0/24", Endpoint: "vpn.example.com:51820"}, dev, peers, zerolog.New(io.Discard))
+	require.NoError(t, err)
+	r := p.Reconciler(false)
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if _, err := r.Reconcile(ctx); nil != err {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := p.Create(ctx, "peer")
+		require.NoError(t, err)
+	}
+	require.NoError(t, <-done)
+
+	diff, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+	require.Len(t, dev.dev.Peers, 20)
+}
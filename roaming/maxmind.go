@@ -0,0 +1,77 @@
+package roaming
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type maxMindRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// MaxMind enriches endpoints from local MaxMind-format database files, such as
+// GeoLite2-Country and GeoLite2-ASN. Data found in multiple databases is merged.
+type MaxMind struct {
+	readers []*maxminddb.Reader
+}
+
+func OpenMaxMind(filenames ...string) (*MaxMind, error) {
+	m := &MaxMind{}
+	for _, f := range filenames {
+		r, err := maxminddb.Open(f)
+		if nil != err {
+			_ = m.Close()
+			return nil, fmt.Errorf("failed to open geoip database %s: %w", f, err)
+		}
+		m.readers = append(m.readers, r)
+	}
+	return m, nil
+}
+
+// NewMaxMind creates an enricher from in-memory database contents.
+func NewMaxMind(databases ...[]byte) (*MaxMind, error) {
+	m := &MaxMind{}
+	for i, b := range databases {
+		r, err := maxminddb.FromBytes(b)
+		if nil != err {
+			return nil, fmt.Errorf("failed to load geoip database #%d: %w", i, err)
+		}
+		m.readers = append(m.readers, r)
+	}
+	return m, nil
+}
+
+func (m *MaxMind) Lookup(ip net.IP) (Geo, error) {
+	var geo Geo
+	for _, r := range m.readers {
+		var rec maxMindRecord
+		if err := r.Lookup(ip, &rec); nil != err {
+			return Geo{}, fmt.Errorf("failed to look up %s: %w", ip, err)
+		}
+		if rec.Country.ISOCode != "" {
+			geo.Country = rec.Country.ISOCode
+		}
+		if rec.ASN != 0 {
+			geo.ASN = rec.ASN
+			geo.ASOrg = rec.ASOrg
+		}
+	}
+	return geo, nil
+}
+
+func (m *MaxMind) Close() error {
+	var errs []error
+	for _, r := range m.readers {
+		if err := r.Close(); nil != err {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package roaming
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+type Geo struct {
+	Country string `json:"country,omitempty" bson:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty" bson:"asn,omitempty"`
+	ASOrg   string `json:"asOrg,omitempty" bson:"asOrg,omitempty"`
+}
+
+type Enricher interface {
+	Lookup(ip net.IP) (Geo, error)
+}
+
+// Event records a peer's endpoint changing to Endpoint. Previous is empty for a peer's first known endpoint.
+type Event struct {
+	Interface string    `json:"interface" bson:"interface"`
+	PublicKey string    `json:"publicKey" bson:"publicKey"`
+	Endpoint  string    `json:"endpoint" bson:"endpoint"`
+	Previous  string    `json:"previous,omitempty" bson:"previous,omitempty"`
+	At        time.Time `json:"at" bson:"at"`
+	Geo       *Geo      `json:"geo,omitempty" bson:"geo,omitempty"`
+}
+
+type Store interface {
+	LastEndpoints(ctx context.Context, interfaceName string) (map[string]string, error)
+	InsertEndpointEvents(ctx context.Context, events []Event) error
+}
+
+// Tracker records an event each time a peer's endpoint changes, optionally enriched with GeoIP data.
+type Tracker struct {
+	interfaceName string
+	enricher      Enricher
+	store         Store
+	logger        zerolog.Logger
+
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// NewTracker creates a tracker. enricher may be nil, in which case events are not enriched.
+func NewTracker(interfaceName string, enricher Enricher, store Store, logger zerolog.Logger) *Tracker {
+	return &Tracker{
+		interfaceName: interfaceName,
+		enricher:      enricher,
+		store:         store,
+		logger:        logger,
+		last:          make(map[string]string),
+	}
+}
+
+// Load restores the last recorded endpoint of each peer, so a process restart does not record them again.
+func (t *Tracker) Load(ctx context.Context) error {
+	last, err := t.store.LastEndpoints(ctx, t.interfaceName)
+	if nil != err {
+		return fmt.Errorf("failed to load last endpoints: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range last {
+		t.last[k] = v
+	}
+	return nil
+}
+
+func (t *Tracker) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []Event
+	for _, p := range peersUsage {
+		if p.Endpoint == "" || t.last[p.PublicKey] == p.Endpoint {
+			continue
+		}
+		events = append(events, Event{
+			Interface: t.interfaceName,
+			PublicKey: p.PublicKey,
+			Endpoint:  p.Endpoint,
+			Previous:  t.last[p.PublicKey],
+			At:        gatheredAt,
+			Geo:       t.lookup(p.Endpoint),
+		})
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	if err := t.store.InsertEndpointEvents(ctx, events); nil != err {
+		t.logger.Error().Err(err).Msg("failed to store peer endpoint events")
+		return
+	}
+	for _, e := range events {
+		t.last[e.PublicKey] = e.Endpoint
+		l := t.logger.Info().Str("public_key", e.PublicKey).Str("endpoint", e.Endpoint).Str("previous", e.Previous)
+		if nil != e.Geo {
+			l = l.Str("country", e.Geo.Country).Uint("asn", e.Geo.ASN)
+		}
+		l.Msg("peer endpoint changed")
+	}
+}
+
+func (t *Tracker) UsageFailed(ctx context.Context, err error, failedAt time.Time) {}
+
+func (t *Tracker) lookup(endpoint string) *Geo {
+	if nil == t.enricher {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if nil != err {
+		t.logger.Warn().Err(err).Str("endpoint", endpoint).Msg("failed to parse peer endpoint")
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if nil == ip {
+		return nil
+	}
+	geo, err := t.enricher.Lookup(ip)
+	if nil != err {
+		t.logger.Warn().Err(err).Str("endpoint", endpoint).Msg("failed to look up peer endpoint geo data")
+		return nil
+	}
+	if geo == (Geo{}) {
+		return nil
+	}
+	return &geo
+}
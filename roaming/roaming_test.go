@@ -0,0 +1,223 @@
+package roaming_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/roaming"
+)
+
+type memoryStore struct {
+	events []roaming.Event
+	err    error
+}
+
+func (m *memoryStore) LastEndpoints(ctx context.Context, interfaceName string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, e := range m.events {
+		if e.Interface == interfaceName {
+			out[e.PublicKey] = e.Endpoint
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) InsertEndpointEvents(ctx context.Context, events []roaming.Event) error {
+	if nil != m.err {
+		return m.err
+	}
+	m.events = append(m.events, events...)
+	return nil
+}
+
+type staticEnricher map[string]roaming.Geo
+
+func (s staticEnricher) Lookup(ip net.IP) (roaming.Geo, error) {
+	return s[ip.String()], nil
+}
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	store := &memoryStore{events: []roaming.Event{{Interface: "wg0", PublicKey: "abc", Endpoint: "198.51.100.1:51820"}}}
+	enricher := staticEnricher{"203.0.113.7": {Country: "NL", ASN: 64500, ASOrg: "Example"}}
+	tracker := roaming.NewTracker("wg0", enricher, store, zerolog.New(io.Discard))
+	require.NoError(t, tracker.Load(ctx))
+
+	now := time.Now()
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{PublicKey: "xyz", Endpoint: "203.0.113.7:40000"},
+		{PublicKey: "abc", Endpoint: "198.51.100.1:51820"},
+		{PublicKey: "def"},
+	}, now)
+	require.Len(t, store.events, 2)
+	require.Equal(t, roaming.Event{
+		Interface: "wg0",
+		PublicKey: "xyz",
+		Endpoint:  "203.0.113.7:40000",
+		At:        now,
+		Geo:       &roaming.Geo{Country: "NL", ASN: 64500, ASOrg: "Example"},
+	}, store.events[1])
+
+	tracker.UsageIngested(ctx, []ingest.PeerUsage{
+		{PublicKey: "xyz", Endpoint: "203.0.113.7:40000"},
+		{PublicKey: "abc", Endpoint: "[2001:db8::1]:51820"},
+	}, now.Add(time.Second))
+	require.Len(t, store.events, 3)
+	require.Equal(t, "abc", store.events[2].PublicKey)
+	require.Equal(t, "[2001:db8::1]:51820", store.events[2].Endpoint)
+	require.Equal(t, "198.51.100.1:51820", store.events[2].Previous)
+	require.Nil(t, store.events[2].Geo)
+}
+
+func TestTrackerRetriesFailedWrites(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	store := &memoryStore{err: errors.New("connection refused")}
+	tracker := roaming.NewTracker("wg0", nil, store, zerolog.New(io.Discard))
+
+	peers := []ingest.PeerUsage{{PublicKey: "xyz", Endpoint: "203.0.113.7:40000"}}
+	tracker.UsageIngested(ctx, peers, time.Now())
+	require.Empty(t, store.events)
+
+	store.err = nil
+	tracker.UsageIngested(ctx, peers, time.Now())
+	require.Len(t, store.events, 1)
+}
+
+func TestMaxMind(t *testing.T) {
+	t.Parallel()
+
+	country := buildIPv4Database(t, "GeoLite2-Country", net.ParseIP("203.0.113.0"), 24, map[string]any{
+		"country": map[string]any{"iso_code": "NL"},
+	})
+	asn := buildIPv4Database(t, "GeoLite2-ASN", net.ParseIP("203.0.0.0"), 16, map[string]any{
+		"autonomous_system_number":       uint32(64500),
+		"autonomous_system_organization": "Example",
+	})
+	m, err := roaming.NewMaxMind(country, asn)
+	require.NoError(t, err)
+	defer m.Close()
+
+	geo, err := m.Lookup(net.ParseIP("203.0.113.7"))
+	require.NoError(t, err)
+	require.Equal(t, roaming.Geo{Country: "NL", ASN: 64500, ASOrg: "Example"}, geo)
+
+	geo, err = m.Lookup(net.ParseIP("203.0.1.1"))
+	require.NoError(t, err)
+	require.Equal(t, roaming.Geo{ASN: 64500, ASOrg: "Example"}, geo)
+
+	geo, err = m.Lookup(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	require.Equal(t, roaming.Geo{}, geo)
+}
+
+// buildIPv4Database builds a MaxMind DB file, with 24-bit records, mapping a single network to data.
+func buildIPv4Database(t *testing.T, databaseType string, network net.IP, bits int, data map[string]any) []byte {
+	t.Helper()
+
+	ip := network.To4()
+	nodeCount := uint32(bits)
+	var tree bytes.Buffer
+	for i := 0; i < bits; i++ {
+		next := uint32(i + 1)
+		if i == bits-1 {
+			next = nodeCount + 16
+		}
+		left, right := nodeCount, nodeCount
+		if ip[i/8]&(0x80>>(i%8)) == 0 {
+			left = next
+		} else {
+			right = next
+		}
+		tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(encode(t, data))
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	out.Write(encode(t, map[string]any{
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               databaseType,
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"description":                 map[string]any{"en": "test"},
+	}))
+	return out.Bytes()
+}
+
+func encode(t *testing.T, v any) []byte {
+	t.Helper()
+
+	control := func(typ int, size int) []byte {
+		require.Less(t, size, 29+256)
+		var extra []byte
+		if size >= 29 {
+			size, extra = 29, []byte{byte(size - 29)}
+		}
+		out := []byte{byte(size)}
+		if typ <= 7 {
+			out[0] |= byte(typ << 5)
+		} else {
+			out = append(out, byte(typ-7))
+		}
+		return append(out, extra...)
+	}
+	unsigned := func(typ int, n uint64, width int) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+		b = bytes.TrimLeft(b[8-width:], "\x00")
+		return append(control(typ, len(b)), b...)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case uint16:
+		return unsigned(5, uint64(v), 2)
+	case uint32:
+		return unsigned(6, uint64(v), 4)
+	case uint64:
+		return unsigned(9, v, 8)
+	case []any:
+		out := control(11, len(v))
+		for _, e := range v {
+			out = append(out, encode(t, e)...)
+		}
+		return out
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := control(7, len(v))
+		for _, k := range keys {
+			out = append(out, encode(t, k)...)
+			out = append(out, encode(t, v[k])...)
+		}
+		return out
+	default:
+		t.Fatalf("unsupported type %T", v)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/anomaly"
+	"github.com/xeptore/wireuse/pkg/funcutils"
+)
+
+const AnomaliesCollectionName = "anomalies"
+
+type MongoAnomalies struct {
+	collection *mongo.Collection
+	// host scopes the anomalies when hosts share the database, and is empty otherwise.
+	host string
+}
+
+// NewMongoAnomalies creates an anomalies store of host, which is empty unless hosts share the
+// database, as in the shared usage layout.
+func NewMongoAnomalies(collection *mongo.Collection, host string) *MongoAnomalies {
+	return &MongoAnomalies{collection: collection, host: host}
+}
+
+func (m *MongoAnomalies) EnsureIndexes(ctx context.Context) ([]string, error) {
+	return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "host", Value: 1}, {Key: "interface", Value: 1}, {Key: "publicKey", Value: 1}, {Key: "at", Value: 1}}},
+		{Keys: bson.D{{Key: "at", Value: 1}}},
+	})
+}
+
+func (m *MongoAnomalies) InsertAnomalies(ctx context.Context, events []anomaly.Event) error {
+	docs := funcutils.Map(events, func(e anomaly.Event) any { return hosted[anomaly.Event]{Host: m.host, Doc: e} })
+	if _, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); nil != err {
+		return fmt.Errorf("failed to insert anomaly events: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/pkg/deltaenc"
+)
+
+// DefaultBucketSize is the number of samples per compacted bucket, an hour of samples taken at
+// the default polling interval.
+const DefaultBucketSize = 720
+
+// compactWrites is the maximum number of documents updated by each bulk write of Compact.
+const compactWrites = 100
+
+// usageBucket is a sequence of a peer's samples, from and to being the times of its first and
+// last samples, packed with deltaenc. Compacted samples are kept in the buckets field of the
+// peer's document, while the newer ones stay in its usage field.
+type usageBucket struct {
+	From  int64  `bson:"from"`
+	To    int64  `bson:"to"`
+	Count int    `bson:"count"`
+	Data  []byte `bson:"data"`
+}
+
+func encodeBucket(samples []usageSample) usageBucket {
+	packed := make([]deltaenc.Sample, len(samples))
+	for i, s := range samples {
+		packed[i] = deltaenc.Sample{At: s.At, Upload: uint64(s.Upload), Download: uint64(s.Download)}
+	}
+	return usageBucket{
+		From:  samples[0].At,
+		To:    samples[len(samples)-1].At,
+		Count: len(samples),
+		Data:  deltaenc.Encode(packed),
+	}
+}
+
+func (b *usageBucket) samples() ([]usageSample, error) {
+	packed, err := deltaenc.Decode(b.Data)
+	if nil != err {
+		return nil, fmt.Errorf("failed to decode usage bucket from %d: %w", b.From, err)
+	}
+	out := make([]usageSample, len(packed))
+	for i, s := range packed {
+		out[i] = usageSample{Upload: uint(s.Upload), Download: uint(s.Download), At: s.At}
+	}
+	return out, nil
+}
+
+// SetDeltaEncoding makes Compact pack the samples of each peer into delta-encoded buckets of
+// bucketSize samples. Samples are stored as they are until compacted, and the read paths decode
+// compacted samples regardless of this setting. It must be called before the store is used.
+func (m *Mongo) SetDeltaEncoding(bucketSize int) {
+	m.bucketSize = bucketSize
+}
+
+// BucketSize returns the number of samples per compacted bucket, or 0 if compaction is disabled.
+func (m *Mongo) BucketSize() int {
+	return m.bucketSize
+}
+
+// Compact packs the oldest samples of the peers with more than a bucket of samples into buckets,
+// always leaving their last sample as it is, and returns the number of compacted peers. Samples
+// ingested meanwhile are kept, and peers compacted concurrently are skipped.
+func (m *Mongo) Compact(ctx context.Context) (int, error) {
+	if m.bucketSize <= 0 {
+		return 0, nil
+	}
+
+	filter := bson.M{fmt.Sprintf("usage.%d", m.bucketSize): bson.M{"$exists": true}}
+	for k, v := range m.scope {
+		filter[k] = v
+	}
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "usage": 1}))
+	if nil != err {
+		return 0, fmt.Errorf("failed to query peers to compact: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	compacted := 0
+	models := make([]mongo.WriteModel, 0, compactWrites)
+	write := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := m.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if nil != err {
+			return fmt.Errorf("failed to compact peers usage: %v", err)
+		}
+		compacted += int(res.ModifiedCount)
+		models = models[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID    any           `bson:"_id"`
+			Usage []usageSample `bson:"usage"`
+		}
+		if err := cursor.Decode(&doc); nil != err {
+			return compacted, fmt.Errorf("failed to decode peer usage: %v", err)
+		}
+		n := (len(doc.Usage) - 1) / m.bucketSize * m.bucketSize
+		if n <= 0 {
+			continue
+		}
+		buckets := make(bson.A, 0, n/m.bucketSize)
+		for start := 0; start < n; start += m.bucketSize {
+			buckets = append(buckets, encodeBucket(doc.Usage[start:start+m.bucketSize]))
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			// The first sample is only different if the peer was compacted since it was read.
+			SetFilter(bson.M{"_id": doc.ID, "usage.0.at": doc.Usage[0].At}).
+			SetUpdate(bson.A{bson.M{"$set": bson.M{
+				"buckets": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$buckets", bson.A{}}}, bson.M{"$literal": buckets}}},
+				"usage":   bson.M{"$slice": bson.A{"$usage", n, math.MaxInt32}},
+			}}}))
+		if len(models) == compactWrites {
+			if err := write(); nil != err {
+				return compacted, err
+			}
+		}
+	}
+	if err := cursor.Err(); nil != err {
+		return compacted, fmt.Errorf("failed to iterate peers to compact: %v", err)
+	}
+	if err := write(); nil != err {
+		return compacted, err
+	}
+	return compacted, nil
+}
+
+// Compactor is an engine observer compacting a store in the background every so many ticks.
+type Compactor struct {
+	usage   *Mongo
+	every   int
+	ticks   int
+	running atomic.Bool
+	logger  zerolog.Logger
+}
+
+// NewCompactor creates a Compactor compacting usage every bucket size ticks, i.e., about when each
+// peer has another bucket of samples to compact.
+func NewCompactor(usage *Mongo, logger zerolog.Logger) *Compactor {
+	return &Compactor{usage: usage, every: usage.BucketSize(), logger: logger}
+}
+
+func (c *Compactor) UsageIngested(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) {
+	c.ticks++
+	if c.ticks < c.every || !c.running.CompareAndSwap(false, true) {
+		return
+	}
+	c.ticks = 0
+	go func() {
+		defer c.running.Store(false)
+		start := time.Now()
+		n, err := c.usage.Compact(ctx)
+		if nil != err {
+			c.logger.Error().Err(err).Int("peers", n).Msg("failed to compact peers usage")
+			return
+		}
+		c.logger.Info().Int("peers", n).Dur("took", time.Since(start)).Msg("compacted peers usage")
+	}()
+}
+
+func (c *Compactor) UsageFailed(ctx context.Context, err error, failedAt time.Time) {}
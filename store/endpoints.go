@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/pkg/funcutils"
+	"github.com/xeptore/wireuse/roaming"
+)
+
+const EndpointsCollectionName = "endpoints"
+
+type MongoEndpoints struct {
+	collection *mongo.Collection
+	// host scopes the endpoint events when hosts share the database, and is empty otherwise.
+	host string
+}
+
+// NewMongoEndpoints creates an endpoint events store of host, which is empty unless hosts share
+// the database, as in the shared usage layout.
+func NewMongoEndpoints(collection *mongo.Collection, host string) *MongoEndpoints {
+	return &MongoEndpoints{collection: collection, host: host}
+}
+
+func (m *MongoEndpoints) EnsureIndexes(ctx context.Context) ([]string, error) {
+	return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "host", Value: 1}, {Key: "interface", Value: 1}, {Key: "publicKey", Value: 1}, {Key: "at", Value: 1}}},
+		{Keys: bson.D{{Key: "geo.country", Value: 1}, {Key: "at", Value: 1}}},
+	})
+}
+
+func (m *MongoEndpoints) LastEndpoints(ctx context.Context, interfaceName string) (map[string]string, error) {
+	cursor, err := m.collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": hostFilter(m.host, bson.M{"interface": interfaceName})},
+		bson.M{"$sort": bson.D{{Key: "publicKey", Value: 1}, {Key: "at", Value: 1}}},
+		bson.M{"$group": bson.M{"_id": "$publicKey", "endpoint": bson.M{"$last": "$endpoint"}}},
+	})
+	if nil != err {
+		return nil, fmt.Errorf("failed to query last endpoints: %v", err)
+	}
+	var results []struct {
+		PublicKey string `bson:"_id"`
+		Endpoint  string `bson:"endpoint"`
+	}
+	if err := cursor.All(ctx, &results); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+	out := make(map[string]string, len(results))
+	for _, r := range results {
+		out[r.PublicKey] = r.Endpoint
+	}
+	return out, nil
+}
+
+func (m *MongoEndpoints) InsertEndpointEvents(ctx context.Context, events []roaming.Event) error {
+	docs := funcutils.Map(events, func(e roaming.Event) any { return hosted[roaming.Event]{Host: m.host, Doc: e} })
+	if _, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); nil != err {
+		return fmt.Errorf("failed to insert endpoint events: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package store
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// hostFilter adds host to filter, unless host is empty as the documents are not shared by hosts.
+func hostFilter(host string, filter bson.M) bson.M {
+	if host != "" {
+		filter["host"] = host
+	}
+	return filter
+}
+
+// hosted is a document of a host, which is omitted when the documents are not shared by hosts.
+type hosted[T any] struct {
+	Host string `bson:"host,omitempty"`
+	Doc  T      `bson:",inline"`
+}
@@ -0,0 +1,52 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/presence"
+	"github.com/xeptore/wireuse/roaming"
+	"github.com/xeptore/wireuse/store"
+)
+
+func TestSinkStoresAreScopedToHost(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessions := func(host string) *store.MongoSessions {
+		return store.NewMongoSessions(db.Collection(store.SessionsCollectionName), host)
+	}
+	endpoints := func(host string) *store.MongoEndpoints {
+		return store.NewMongoEndpoints(db.Collection(store.EndpointsCollectionName), host)
+	}
+	_, err := sessions("a").EnsureIndexes(ctx)
+	require.NoError(t, err)
+	_, err = endpoints("a").EnsureIndexes(ctx)
+	require.NoError(t, err)
+
+	// Both hosts have a wg0 interface with the same peer, which connected at the same time.
+	for _, host := range []string{"a", "b"} {
+		session := presence.Session{Interface: "wg0", PublicKey: "xyz", Start: start}
+		require.NoError(t, sessions(host).StartSessions(ctx, []presence.Session{session}))
+		event := roaming.Event{Interface: "wg0", PublicKey: "xyz", Endpoint: host + ":51820", At: start}
+		require.NoError(t, endpoints(host).InsertEndpointEvents(ctx, []roaming.Event{event}))
+	}
+	end := start.Add(time.Hour)
+	require.NoError(t, sessions("b").EndSessions(ctx, []presence.Session{{Interface: "wg0", PublicKey: "xyz", Start: start, End: &end}}))
+
+	open, err := sessions("a").OpenSessions(ctx, "wg0")
+	require.NoError(t, err)
+	require.Len(t, open, 1)
+	open, err = sessions("b").OpenSessions(ctx, "wg0")
+	require.NoError(t, err)
+	require.Empty(t, open)
+
+	last, err := endpoints("a").LastEndpoints(ctx, "wg0")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"xyz": "a:51820"}, last)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Memory is an in-memory usage store, which only keeps the last ingested usage and the baseline
+// of each peer. It is the reference implementation of the ingest.Store semantics that the
+// storetest suite checks other implementations against.
+type Memory struct {
+	mu      sync.Mutex
+	last    map[string]ingest.PeerUsage
+	offsets map[string]ingest.PeerUsage
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		last:    make(map[string]ingest.PeerUsage),
+		offsets: make(map[string]ingest.PeerUsage),
+	}
+}
+
+func (m *Memory) LoadBaselines(ctx context.Context) (map[string]ingest.Baseline, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]ingest.Baseline, len(m.last))
+	for publicKey, last := range m.last {
+		offset := m.offsets[publicKey]
+		offset.PublicKey = publicKey
+		out[publicKey] = ingest.Baseline{Offset: offset, Last: last}
+	}
+	return out, nil
+}
+
+func (m *Memory) ResetBaselines(ctx context.Context, publicKeys []string) (map[string]ingest.PeerUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(publicKeys) == 0 {
+		publicKeys = make([]string, 0, len(m.last))
+		for publicKey := range m.last {
+			publicKeys = append(publicKeys, publicKey)
+		}
+	}
+	out := make(map[string]ingest.PeerUsage, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		if last, exists := m.last[publicKey]; exists {
+			m.offsets[publicKey] = last
+			out[publicKey] = last
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) IngestUsage(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range peersUsage {
+		m.last[p.PublicKey] = ingest.PeerUsage{Upload: p.Upload, Download: p.Download, PublicKey: p.PublicKey}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/store"
+	"github.com/xeptore/wireuse/store/storetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	t.Parallel()
+	storetest.Run(t, func(t *testing.T) ingest.Store {
+		return store.NewMemory()
+	})
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MetadataCollectionName is the collection keeping the schema version and the migration lock.
+const MetadataCollectionName = "metadata"
+
+const (
+	schemaVersionID = "schema_version"
+	migrationLockID = "migration_lock"
+)
+
+// Migration is a schema change. Up must be idempotent, as a migration that failed halfway, or whose
+// version failed to be recorded, is applied again.
+type Migration struct {
+	Version     int
+	Description string
+	// Up applies the migration to db, where usage are the usage stores of the configured interfaces.
+	Up func(ctx context.Context, db *mongo.Database, usage []*Mongo) error
+}
+
+// Migrations are the schema migrations, ordered by version. A migration must never be changed
+// once released, as databases already at its version will not apply it again.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create usage, peers, sessions, endpoints and anomalies indexes",
+		Up: func(ctx context.Context, db *mongo.Database, usage []*Mongo) error {
+			type indexer interface {
+				EnsureIndexes(ctx context.Context) ([]string, error)
+			}
+			indexers := []indexer{
+				NewMongoPeers(db.Collection(PeersCollectionName)),
+				NewMongoSessions(db.Collection(SessionsCollectionName), ""),
+				NewMongoEndpoints(db.Collection(EndpointsCollectionName), ""),
+				NewMongoAnomalies(db.Collection(AnomaliesCollectionName), ""),
+			}
+			for _, u := range usage {
+				indexers = append(indexers, u)
+			}
+			for _, i := range indexers {
+				if _, err := i.EnsureIndexes(ctx); nil != err {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// ErrSchemaTooNew is returned when the database schema is newer than the latest known migration,
+// i.e., it was migrated by a newer release whose documents this one must not write.
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// Migrator applies the pending migrations while holding a lock in the metadata collection, so
+// that processes starting at the same time do not apply them concurrently.
+type Migrator struct {
+	db         *mongo.Database
+	metadata   *mongo.Collection
+	migrations []Migration
+	usage      []*Mongo
+	owner      string
+	lockTTL    time.Duration
+	retryEvery time.Duration
+	logger     zerolog.Logger
+}
+
+// NewMigrator creates a Migrator applying migrations to db, where usage are the usage stores of
+// the configured interfaces.
+func NewMigrator(db *mongo.Database, migrations []Migration, usage []*Mongo, logger zerolog.Logger) *Migrator {
+	hostname, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		metadata:   db.Collection(MetadataCollectionName),
+		migrations: migrations,
+		usage:      usage,
+		owner:      fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		lockTTL:    10 * time.Minute,
+		retryEvery: time.Second,
+		logger:     logger,
+	}
+}
+
+// SetLockTTL sets how long the migration lock is held without being renewed, which is 10 minutes
+// by default. It must be called before the migrator is used.
+func (m *Migrator) SetLockTTL(ttl time.Duration) {
+	m.lockTTL = ttl
+}
+
+type schemaVersion struct {
+	Version int `bson:"version"`
+}
+
+// Version returns the current schema version of the database, which is 0 before any migration.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var v schemaVersion
+	if err := m.metadata.FindOne(ctx, bson.M{"_id": schemaVersionID}).Decode(&v); nil != err {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to query schema version: %v", err)
+	}
+	return v.Version, nil
+}
+
+// Latest returns the version the database is at after all migrations are applied.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Migrate waits for the migration lock and applies the pending migrations in order, returning the
+// versions it applied. It fails with ErrSchemaTooNew if the database is ahead of the migrations.
+func (m *Migrator) Migrate(ctx context.Context) ([]int, error) {
+	ctx, unlock, err := m.lock(ctx)
+	if nil != err {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := m.Version(ctx)
+	if nil != err {
+		return nil, err
+	}
+	if latest := m.Latest(); current > latest {
+		return nil, fmt.Errorf("%w: database is at version %d while the latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+
+	var applied []int
+	for _, migration := range m.migrations {
+		if migration.Version <= current {
+			continue
+		}
+		log := m.logger.With().Int("version", migration.Version).Str("description", migration.Description).Logger()
+		log.Info().Msg("applying schema migration")
+		if err := migration.Up(ctx, m.db, m.usage); nil != err {
+			return applied, fmt.Errorf("failed to apply schema migration %d: %w", migration.Version, err)
+		}
+		if err := m.setVersion(ctx, migration); nil != err {
+			return applied, err
+		}
+		applied = append(applied, migration.Version)
+		log.Info().Msg("applied schema migration")
+	}
+	return applied, nil
+}
+
+// ConvertToShared copies the usage of host interfaces from their per-interface collections into
+// the shared layout collection, while holding the migration lock. Samples already in the shared
+// collection are kept, and only older samples, plain or compacted, are copied before them, so it
+// can be run again, or after the shared layout is already in use. Peers baselines are copied unless
+// the shared collection already has one. The per-interface collections are left intact. The
+// sessions, endpoint events and anomalies of the interfaces without a host are assigned to host.
+func (m *Migrator) ConvertToShared(ctx context.Context, host string, interfaces []string) error {
+	ctx, unlock, err := m.lock(ctx)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	shared := m.db.Collection(UsageCollectionName)
+	for _, name := range interfaces {
+		if _, err := NewSharedMongo(shared, host, name).EnsureIndexes(ctx); nil != err {
+			return fmt.Errorf("failed to create shared usage collection indexes: %v", err)
+		}
+	}
+
+	for _, name := range interfaces {
+		log := m.logger.With().Str("interface", name).Str("host", host).Logger()
+		log.Info().Msg("copying interface usage into the shared usage collection")
+		// oldest is the time of the oldest sample, plain or compacted, already in the shared collection.
+		oldest := bson.M{"$ifNull": bson.A{bson.M{"$min": bson.A{bson.M{"$min": "$usage.at"}, bson.M{"$min": "$buckets.from"}}}, math.MaxInt64}}
+		cursor, err := m.db.Collection(name).Aggregate(ctx, bson.A{
+			bson.M{"$project": bson.M{"_id": 0, "publicKey": 1, "usage": 1, "buckets": 1, "baseline": 1, "host": bson.M{"$literal": host}, "interface": bson.M{"$literal": name}}},
+			bson.M{"$merge": bson.M{
+				"into": UsageCollectionName,
+				"on":   bson.A{"host", "interface", "publicKey"},
+				"whenMatched": bson.A{
+					bson.M{"$set": bson.M{
+						"usage": bson.M{"$concatArrays": bson.A{
+							bson.M{"$filter": bson.M{"input": "$$new.usage", "cond": bson.M{"$lt": bson.A{"$$this.at", oldest}}}},
+							bson.M{"$ifNull": bson.A{"$usage", bson.A{}}},
+						}},
+						"buckets": bson.M{"$concatArrays": bson.A{
+							bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{"$$new.buckets", bson.A{}}}, "cond": bson.M{"$lt": bson.A{"$$this.to", oldest}}}},
+							bson.M{"$ifNull": bson.A{"$buckets", bson.A{}}},
+						}},
+						// The samples already in the shared collection were ingested against its
+						// baseline, if it has one.
+						"baseline": bson.M{"$ifNull": bson.A{"$baseline", "$$new.baseline"}},
+					}},
+				},
+				"whenNotMatched": "insert",
+			}},
+		})
+		if nil != err {
+			return fmt.Errorf("failed to copy %s interface usage: %v", name, err)
+		}
+		if err := cursor.Close(ctx); nil != err {
+			return fmt.Errorf("failed to copy %s interface usage: %v", name, err)
+		}
+		log.Info().Msg("copied interface usage into the shared usage collection")
+	}
+
+	for _, name := range []string{SessionsCollectionName, EndpointsCollectionName, AnomaliesCollectionName} {
+		_, err := m.db.Collection(name).UpdateMany(
+			ctx,
+			bson.M{"interface": bson.M{"$in": interfaces}, "host": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"host": host}},
+		)
+		if nil != err {
+			return fmt.Errorf("failed to assign %s to host: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) setVersion(ctx context.Context, migration Migration) error {
+	now := time.Now()
+	_, err := m.metadata.UpdateOne(
+		ctx,
+		bson.M{"_id": schemaVersionID},
+		bson.M{
+			"$set":  bson.M{"version": migration.Version, "updatedAt": now},
+			"$push": bson.M{"history": bson.M{"version": migration.Version, "description": migration.Description, "appliedAt": now}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if nil != err {
+		return fmt.Errorf("failed to record schema version %d: %v", migration.Version, err)
+	}
+	return nil
+}
+
+// lock acquires the migration lock, retrying while another owner holds it, and renews it until
+// unlock is called, which releases it. The returned context is canceled if the lock is lost, e.g.,
+// as it could not be renewed before it expired. A lock that is not released, e.g., by a crashed
+// process, expires after lockTTL.
+func (m *Migrator) lock(ctx context.Context) (_ context.Context, unlock func(), _ error) {
+	for {
+		now := time.Now()
+		_, err := m.metadata.UpdateOne(
+			ctx,
+			bson.M{"_id": migrationLockID, "$or": bson.A{bson.M{"owner": m.owner}, bson.M{"expiresAt": bson.M{"$lt": now}}}},
+			bson.M{"$set": bson.M{"owner": m.owner, "acquiredAt": now, "expiresAt": now.Add(m.lockTTL)}},
+			options.Update().SetUpsert(true),
+		)
+		if nil == err {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, fmt.Errorf("failed to acquire migration lock: %v", err)
+		}
+		m.logger.Info().Msg("waiting for migration lock held by another process")
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("failed to acquire migration lock: %w", ctx.Err())
+		case <-time.After(m.retryEvery):
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.renew(ctx, cancel)
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+		m.unlock()
+	}, nil
+}
+
+// renew extends the migration lock every third of lockTTL until ctx is done, calling lost if the
+// lock is no longer held.
+func (m *Migrator) renew(ctx context.Context, lost context.CancelFunc) {
+	ticker := time.NewTicker(m.lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		res, err := m.metadata.UpdateOne(
+			ctx,
+			bson.M{"_id": migrationLockID, "owner": m.owner},
+			bson.M{"$set": bson.M{"expiresAt": time.Now().Add(m.lockTTL)}},
+		)
+		switch {
+		case nil != err:
+			if nil == ctx.Err() {
+				m.logger.Warn().Err(err).Msg("failed to renew migration lock")
+			}
+		case res.MatchedCount == 0:
+			m.logger.Error().Msg("lost migration lock, stopping migration")
+			lost()
+			return
+		}
+	}
+}
+
+func (m *Migrator) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := m.metadata.DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": m.owner}); nil != err {
+		m.logger.Error().Err(err).Msg("failed to release migration lock")
+	}
+}
@@ -0,0 +1,209 @@
+package store_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/presence"
+	"github.com/xeptore/wireuse/store"
+)
+
+func TestConvertToSharedCopiesBaselines(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+
+	usage := store.NewMongo(db.Collection("wg0"))
+	_, err := usage.EnsureIndexes(ctx)
+	require.NoError(t, err)
+	at := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, usage.IngestUsage(ctx, []ingest.PeerUsage{{PublicKey: "xyz", Upload: 100, Download: 1000}, {PublicKey: "abc", Upload: 1, Download: 2}}, at))
+	_, err = usage.ResetBaselines(ctx, []string{"xyz"})
+	require.NoError(t, err)
+	require.NoError(t, usage.IngestUsage(ctx, []ingest.PeerUsage{{PublicKey: "xyz", Upload: 110, Download: 1100}, {PublicKey: "abc", Upload: 3, Download: 4}}, at.Add(time.Minute)))
+	want, err := usage.LoadBaselines(ctx)
+	require.NoError(t, err)
+
+	migrator := store.NewMigrator(db, store.Migrations, nil, zerolog.New(io.Discard))
+	require.NoError(t, migrator.ConvertToShared(ctx, "host", []string{"wg0"}))
+
+	got, err := store.NewSharedMongo(db.Collection(store.UsageCollectionName), "host", "wg0").LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, ingest.PeerUsage{PublicKey: "xyz", Upload: 100, Download: 1000}, got["xyz"].Offset)
+}
+
+func countingMigrations(applied *atomic.Int32, versions ...int) []store.Migration {
+	out := make([]store.Migration, 0, len(versions))
+	for _, v := range versions {
+		out = append(out, store.Migration{
+			Version: v,
+			Up: func(ctx context.Context, db *mongo.Database, usage []*store.Mongo) error {
+				applied.Add(1)
+				return nil
+			},
+		})
+	}
+	return out
+}
+
+func TestMigratorAppliesPendingMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+	logger := zerolog.New(io.Discard)
+
+	var applied atomic.Int32
+	migrator := store.NewMigrator(db, countingMigrations(&applied, 1, 2), nil, logger)
+	versions, err := migrator.Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, versions)
+	require.Equal(t, int32(2), applied.Load())
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	versions, err = migrator.Migrate(ctx)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+	require.Equal(t, int32(2), applied.Load())
+
+	versions, err = store.NewMigrator(db, countingMigrations(&applied, 1, 2, 3), nil, logger).Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{3}, versions)
+	require.Equal(t, int32(3), applied.Load())
+
+	_, err = store.NewMigrator(db, countingMigrations(&applied, 1), nil, logger).Migrate(ctx)
+	require.ErrorIs(t, err, store.ErrSchemaTooNew)
+	require.Equal(t, int32(3), applied.Load())
+}
+
+func TestMigratorLockIsExclusiveAndRenewed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+	logger := zerolog.New(io.Discard)
+
+	var applied atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := []store.Migration{{
+		Version: 1,
+		Up: func(ctx context.Context, db *mongo.Database, usage []*store.Mongo) error {
+			applied.Add(1)
+			close(started)
+			<-release
+			return nil
+		},
+	}}
+
+	first := store.NewMigrator(db, slow, nil, logger)
+	first.SetLockTTL(300 * time.Millisecond)
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := first.Migrate(ctx)
+		firstDone <- err
+	}()
+	<-started
+
+	second := store.NewMigrator(db, countingMigrations(&applied, 1), nil, logger)
+	second.SetLockTTL(300 * time.Millisecond)
+	var secondVersions []int
+	secondDone := make(chan error, 1)
+	go func() {
+		var err error
+		secondVersions, err = second.Migrate(ctx)
+		secondDone <- err
+	}()
+
+	// The first migrator holds the lock for several of its TTLs, which it must keep renewing.
+	select {
+	case <-secondDone:
+		t.Fatal("migrated while the lock was held")
+	case <-time.After(2 * time.Second):
+	}
+
+	close(release)
+	require.NoError(t, <-firstDone)
+	require.NoError(t, <-secondDone)
+	require.Empty(t, secondVersions)
+	require.Equal(t, int32(1), applied.Load())
+}
+
+func TestConvertToSharedNeitherLosesNorDuplicatesSamples(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+	migrator := store.NewMigrator(db, store.Migrations, nil, zerolog.New(io.Discard))
+	at := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(minutes int) ingest.PeerUsage {
+		return ingest.PeerUsage{PublicKey: "xyz", Upload: uint(minutes), Download: uint(minutes * 10)}
+	}
+
+	usage := store.NewMongo(db.Collection("wg0"))
+	_, err := usage.EnsureIndexes(ctx)
+	require.NoError(t, err)
+	for _, m := range []int{0, 1} {
+		require.NoError(t, usage.IngestUsage(ctx, []ingest.PeerUsage{sample(m)}, at.Add(time.Duration(m)*time.Minute)))
+	}
+	// The process was switched to the shared layout, and ingested into it, before converting.
+	shared := store.NewSharedMongo(db.Collection(store.UsageCollectionName), "host", "wg0")
+	_, err = shared.EnsureIndexes(ctx)
+	require.NoError(t, err)
+	require.NoError(t, shared.IngestUsage(ctx, []ingest.PeerUsage{sample(2)}, at.Add(2*time.Minute)))
+
+	samples := func() []int64 {
+		var doc struct {
+			Usage []struct {
+				At int64 `bson:"at"`
+			} `bson:"usage"`
+		}
+		filter := bson.M{"host": "host", "interface": "wg0", "publicKey": "xyz"}
+		require.NoError(t, db.Collection(store.UsageCollectionName).FindOne(ctx, filter).Decode(&doc))
+		out := make([]int64, 0, len(doc.Usage))
+		for _, u := range doc.Usage {
+			out = append(out, (u.At-at.UnixMilli())/time.Minute.Milliseconds())
+		}
+		return out
+	}
+
+	require.NoError(t, migrator.ConvertToShared(ctx, "host", []string{"wg0"}))
+	require.Equal(t, []int64{0, 1, 2}, samples())
+
+	require.NoError(t, migrator.ConvertToShared(ctx, "host", []string{"wg0"}))
+	require.Equal(t, []int64{0, 1, 2}, samples())
+
+	require.NoError(t, shared.IngestUsage(ctx, []ingest.PeerUsage{sample(3)}, at.Add(3*time.Minute)))
+	require.NoError(t, migrator.ConvertToShared(ctx, "host", []string{"wg0"}))
+	require.Equal(t, []int64{0, 1, 2, 3}, samples())
+
+	got, err := shared.UsageBetween(ctx, at.Add(time.Minute), at.Add(4*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []ingest.PeerUsage{{PublicKey: "xyz", Upload: 3, Download: 30}}, got)
+}
+
+func TestConvertToSharedAssignsSinksToHost(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	session := presence.Session{Interface: "wg0", PublicKey: "xyz", Start: start}
+	require.NoError(t, store.NewMongoSessions(db.Collection(store.SessionsCollectionName), "").StartSessions(ctx, []presence.Session{session}))
+	require.NoError(t, store.NewMongo(db.Collection("wg0")).IngestUsage(ctx, []ingest.PeerUsage{{PublicKey: "xyz", Upload: 1}}, start))
+
+	migrator := store.NewMigrator(db, store.Migrations, nil, zerolog.New(io.Discard))
+	require.NoError(t, migrator.ConvertToShared(ctx, "host", []string{"wg0"}))
+
+	open, err := store.NewMongoSessions(db.Collection(store.SessionsCollectionName), "host").OpenSessions(ctx, "wg0")
+	require.NoError(t, err)
+	require.Len(t, open, 1)
+}
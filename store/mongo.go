@@ -0,0 +1,434 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// UsageCollectionName is the collection keeping the usage of all interfaces in the shared layout.
+const UsageCollectionName = "usage"
+
+const (
+	// DefaultIngestBatchSize and DefaultIngestConcurrency bound the bulk writes of IngestUsage.
+	DefaultIngestBatchSize   = 1000
+	DefaultIngestConcurrency = 4
+)
+
+// Mongo stores peers usage samples, appended to a document per peer. In the per-interface layout
+// each interface has its own collection named after it, while in the shared layout the documents
+// of every host and interface are kept in one collection, carrying host and interface fields.
+type Mongo struct {
+	collection *mongo.Collection
+	// scope is the host and interface of the documents in the shared layout, and nil otherwise.
+	scope bson.M
+	// batchSize is the maximum number of peers written by each bulk write of IngestUsage, and
+	// concurrency the maximum number of bulk writes in flight.
+	batchSize   int
+	concurrency int
+	// bucketSize is the number of samples Compact packs into each bucket, or 0 if disabled.
+	bucketSize int
+}
+
+// NewMongo creates a usage store with the per-interface layout, where collection is dedicated to
+// a single interface.
+func NewMongo(collection *mongo.Collection) *Mongo {
+	return &Mongo{collection: collection, batchSize: DefaultIngestBatchSize, concurrency: DefaultIngestConcurrency}
+}
+
+// NewSharedMongo creates a usage store with the shared layout, storing interfaceName of host
+// usage in collection, which is usually UsageCollectionName.
+func NewSharedMongo(collection *mongo.Collection, host, interfaceName string) *Mongo {
+	return &Mongo{
+		collection:  collection,
+		scope:       bson.M{"host": host, "interface": interfaceName},
+		batchSize:   DefaultIngestBatchSize,
+		concurrency: DefaultIngestConcurrency,
+	}
+}
+
+// SetIngestBatches makes IngestUsage write at most batchSize peers per bulk write, with at most
+// concurrency bulk writes in flight. It must be called before the store is used.
+func (m *Mongo) SetIngestBatches(batchSize, concurrency int) {
+	m.batchSize = batchSize
+	m.concurrency = concurrency
+}
+
+func (m *Mongo) EnsureIndexes(ctx context.Context) ([]string, error) {
+	if nil != m.scope {
+		return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "host", Value: 1}, {Key: "interface", Value: 1}, {Key: "publicKey", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "interface", Value: 1}, {Key: "publicKey", Value: 1}},
+			},
+		})
+	}
+	return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "publicKey", Value: "hashed"}},
+		},
+		{
+			Keys:    bson.D{{Key: "publicKey", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+}
+
+// pipeline returns stages prefixed with a match of the documents in scope.
+func (m *Mongo) pipeline(stages ...bson.M) bson.A {
+	out := make(bson.A, 0, len(stages)+1)
+	if nil != m.scope {
+		out = append(out, bson.M{"$match": m.scope})
+	}
+	for _, stage := range stages {
+		out = append(out, stage)
+	}
+	return out
+}
+
+// filter returns the filter of publicKey document, which also sets its scope fields on upserts.
+func (m *Mongo) filter(publicKey string) bson.M {
+	out := bson.M{"publicKey": publicKey}
+	for k, v := range m.scope {
+		out[k] = v
+	}
+	return out
+}
+
+type usageBaseline struct {
+	PublicKey string       `bson:"publicKey"`
+	Baseline  usageSample  `bson:"baseline"`
+	Last      *usageSample `bson:"last"`
+}
+
+// LoadBaselines returns the baseline of each peer, and its last ingested usage. Peers without a
+// baseline, i.e., whose counters were never reset, have a zero offset.
+func (m *Mongo) LoadBaselines(ctx context.Context) (map[string]ingest.Baseline, error) {
+	cursor, err := m.collection.Aggregate(ctx, m.pipeline(
+		bson.M{"$project": bson.M{"_id": 0, "publicKey": 1, "baseline": 1, "last": bson.M{"$last": "$usage"}}},
+	))
+	if nil != err {
+		return nil, fmt.Errorf("failed to query peers baselines: %v", err)
+	}
+
+	var results []usageBaseline
+	if err := cursor.All(ctx, &results); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+
+	out := make(map[string]ingest.Baseline, len(results))
+	for _, r := range results {
+		b := ingest.Baseline{
+			Offset: ingest.PeerUsage{Upload: r.Baseline.Upload, Download: r.Baseline.Download, PublicKey: r.PublicKey},
+			Last:   ingest.PeerUsage{PublicKey: r.PublicKey},
+		}
+		if nil != r.Last {
+			b.Last.Upload, b.Last.Download = r.Last.Upload, r.Last.Download
+		}
+		out[r.PublicKey] = b
+	}
+	return out, nil
+}
+
+// ResetBaselines sets the baseline of the peers with publicKeys, or of every peer if publicKeys is
+// empty, to their last ingested usage. As a peer's baseline is kept in the same document as its
+// samples, each baseline is updated atomically with respect to the samples it is derived from.
+func (m *Mongo) ResetBaselines(ctx context.Context, publicKeys []string) (map[string]ingest.PeerUsage, error) {
+	filter := bson.M{"usage.0": bson.M{"$exists": true}}
+	for k, v := range m.scope {
+		filter[k] = v
+	}
+	if len(publicKeys) > 0 {
+		filter["publicKey"] = bson.M{"$in": publicKeys}
+	}
+	_, err := m.collection.UpdateMany(ctx, filter, bson.A{
+		bson.M{"$set": bson.M{"baseline": bson.M{"$let": bson.M{
+			"vars": bson.M{"last": bson.M{"$last": "$usage"}},
+			"in":   bson.M{"upload": "$$last.upload", "download": "$$last.download", "at": "$$last.at"},
+		}}}},
+	})
+	if nil != err {
+		return nil, fmt.Errorf("failed to reset peers baselines: %v", err)
+	}
+
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 0, "publicKey": 1, "baseline": 1}))
+	if nil != err {
+		return nil, fmt.Errorf("failed to query reset peers baselines: %v", err)
+	}
+	var results []usageBaseline
+	if err := cursor.All(ctx, &results); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+
+	out := make(map[string]ingest.PeerUsage, len(results))
+	for _, r := range results {
+		out[r.PublicKey] = ingest.PeerUsage{Upload: r.Baseline.Upload, Download: r.Baseline.Download, PublicKey: r.PublicKey}
+	}
+	return out, nil
+}
+
+// IngestUsage appends a sample to each peer's document, in concurrent bulk writes of up to the
+// configured batch size. When it fails, the samples of some peers may have been written, which
+// only results in them having an extra sample once the engine ingests the next one.
+func (m *Mongo) IngestUsage(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) error {
+	if len(peersUsage) <= m.batchSize {
+		return m.ingestBatch(ctx, peersUsage, gatheredAt)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(m.concurrency)
+	for start := 0; start < len(peersUsage); start += m.batchSize {
+		end := start + m.batchSize
+		if end > len(peersUsage) {
+			end = len(peersUsage)
+		}
+		batch := peersUsage[start:end]
+		g.Go(func() error {
+			return m.ingestBatch(ctx, batch, gatheredAt)
+		})
+	}
+	return g.Wait()
+}
+
+func (m *Mongo) ingestBatch(ctx context.Context, peersUsage []ingest.PeerUsage, gatheredAt time.Time) error {
+	if len(peersUsage) == 0 {
+		return nil
+	}
+	at := gatheredAt.UnixMilli()
+	models := make([]mongo.WriteModel, len(peersUsage))
+	for i := range peersUsage {
+		p := &peersUsage[i]
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(m.filter(p.PublicKey)).
+			SetUpdate(bson.D{{Key: "$push", Value: bson.D{{Key: "usage", Value: bson.D{
+				{Key: "upload", Value: p.Upload},
+				{Key: "download", Value: p.Download},
+				{Key: "at", Value: at},
+			}}}}}).
+			SetUpsert(true)
+	}
+	opts := options.BulkWrite().SetOrdered(false).SetBypassDocumentValidation(true)
+	if _, err := m.collection.BulkWrite(ctx, models, opts); nil != err {
+		return fmt.Errorf("failed to upsert peer models: %v", err)
+	}
+	return nil
+}
+
+// UsageBetween returns the usage of each peer within [from, to). Usage is calculated against
+// the last sample taken before from, or from zero for peers that first appeared in the period.
+func (m *Mongo) UsageBetween(ctx context.Context, from, to time.Time) ([]ingest.PeerUsage, error) {
+	fromMs, toMs := from.UnixMilli(), to.UnixMilli()
+	// latestBucket returns the latest of the peer's buckets matching cond. Buckets of a peer do
+	// not overlap, so the latest one starting before a time has its latest sample before it.
+	latestBucket := func(cond bson.M) bson.M {
+		return bson.M{"$reduce": bson.M{
+			"input":        bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{"$buckets", bson.A{}}}, "cond": cond}},
+			"initialValue": nil,
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$or": bson.A{bson.M{"$eq": bson.A{"$$value", nil}}, bson.M{"$gt": bson.A{"$$this.from", "$$value.from"}}}},
+				"$$this",
+				"$$value",
+			}},
+		}}
+	}
+	cursor, err := m.collection.Aggregate(ctx, m.pipeline(
+		bson.M{"$project": bson.M{
+			"_id":       0,
+			"publicKey": 1,
+			"before":    bson.M{"$last": bson.M{"$filter": bson.M{"input": "$usage", "cond": bson.M{"$lt": bson.A{"$$this.at", fromMs}}}}},
+			"last": bson.M{"$last": bson.M{"$filter": bson.M{"input": "$usage", "cond": bson.M{"$and": bson.A{
+				bson.M{"$gte": bson.A{"$$this.at", fromMs}},
+				bson.M{"$lt": bson.A{"$$this.at", toMs}},
+			}}}}},
+			"beforeBucket": latestBucket(bson.M{"$lt": bson.A{"$$this.from", fromMs}}),
+			"lastBucket": latestBucket(bson.M{"$and": bson.A{
+				bson.M{"$lt": bson.A{"$$this.from", toMs}},
+				bson.M{"$gte": bson.A{"$$this.to", fromMs}},
+			}}),
+		}},
+		bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{"last": bson.M{"$exists": true}},
+			bson.M{"lastBucket": bson.M{"$ne": nil}},
+		}}},
+	))
+	if nil != err {
+		return nil, fmt.Errorf("failed to query period usage data: %v", err)
+	}
+
+	var results []periodUsage
+	if err := cursor.All(ctx, &results); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+
+	out := make([]ingest.PeerUsage, 0, len(results))
+	for _, r := range results {
+		before, err := latestSample(r.Before, r.BeforeBucket, math.MinInt64, fromMs)
+		if nil != err {
+			return nil, err
+		}
+		last, err := latestSample(r.Last, r.LastBucket, fromMs, toMs)
+		if nil != err {
+			return nil, err
+		}
+		if nil == last {
+			continue
+		}
+		usage := ingest.PeerUsage{Upload: last.Upload, Download: last.Download, PublicKey: r.PublicKey}
+		if nil != before {
+			usage = usage.Sub(ingest.PeerUsage{Upload: before.Upload, Download: before.Download})
+		}
+		out = append(out, usage)
+	}
+	return out, nil
+}
+
+// latestSample returns the latest of sample and the samples of bucket taken in [from, to), or nil
+// if there is none.
+func latestSample(sample *usageSample, bucket *usageBucket, from, to int64) (*usageSample, error) {
+	if nil == bucket {
+		return sample, nil
+	}
+	samples, err := bucket.samples()
+	if nil != err {
+		return nil, err
+	}
+	for i := len(samples) - 1; i >= 0; i-- {
+		if s := samples[i]; s.At >= from && s.At < to {
+			if nil == sample || s.At > sample.At {
+				return &s, nil
+			}
+			break
+		}
+	}
+	return sample, nil
+}
+
+const (
+	// watchBackoff is the delay before reopening a failed usage change stream, doubled on each
+	// consecutive failure up to maxWatchBackoff.
+	watchBackoff    = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// WatchUsage calls onUsage with each peer usage sample ingested into the collection, as reported
+// by a change stream, until ctx is canceled. A failed stream is reopened with a backoff, resuming
+// after the last change it reported, unless that change is no longer in the oplog. Change streams
+// require the database to be deployed as a replica set.
+func (m *Mongo) WatchUsage(ctx context.Context, onUsage func(peerUsage ingest.PeerUsage, gatheredAt time.Time), logger zerolog.Logger) {
+	var resumeAfter bson.Raw
+	backoff := watchBackoff
+	for {
+		reported, err := m.watchUsage(ctx, &resumeAfter, onUsage, logger)
+		if nil != ctx.Err() {
+			return
+		}
+		if reported {
+			backoff = watchBackoff
+		}
+		var serverErr mongo.ServerError
+		if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamHistoryLost) {
+			logger.Warn().Err(err).Msg("usage changes are no longer in the oplog, resuming from now")
+			resumeAfter = nil
+		} else {
+			logger.Warn().Err(err).Dur("backoff", backoff).Msg("usage change stream failed, reopening")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
+}
+
+// changeStreamHistoryLost is the server error code of resuming a change stream after a change
+// that is no longer in the oplog.
+const changeStreamHistoryLost = 286
+
+// watchUsage opens a change stream resuming after *resumeAfter, if set, and reports its changes
+// until it fails, keeping *resumeAfter up to date. reported is whether it reported any change.
+func (m *Mongo) watchUsage(ctx context.Context, resumeAfter *bson.Raw, onUsage func(peerUsage ingest.PeerUsage, gatheredAt time.Time), logger zerolog.Logger) (reported bool, _ error) {
+	match := bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}}}
+	for k, v := range m.scope {
+		match["fullDocument."+k] = v
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if nil != *resumeAfter {
+		opts.SetResumeAfter(*resumeAfter)
+	}
+	stream, err := m.collection.Watch(
+		ctx,
+		bson.A{
+			bson.M{"$match": match},
+			bson.M{"$project": bson.M{
+				"fullDocument.publicKey": 1,
+				"fullDocument.last":      bson.M{"$last": "$fullDocument.usage"},
+			}},
+		},
+		opts,
+	)
+	if nil != err {
+		return false, fmt.Errorf("failed to watch usage changes: %w", err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		*resumeAfter = stream.ResumeToken()
+		reported = true
+		var event struct {
+			FullDocument *struct {
+				PublicKey string `bson:"publicKey"`
+				Last      *struct {
+					Upload   uint  `bson:"upload"`
+					Download uint  `bson:"download"`
+					At       int64 `bson:"at"`
+				} `bson:"last"`
+			} `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); nil != err {
+			logger.Error().Err(err).Msg("failed to decode usage change, skipping it")
+			continue
+		}
+		if nil == event.FullDocument || nil == event.FullDocument.Last {
+			continue
+		}
+		last := event.FullDocument.Last
+		onUsage(ingest.PeerUsage{PublicKey: event.FullDocument.PublicKey, Upload: last.Upload, Download: last.Download}, time.UnixMilli(last.At))
+	}
+	if err := stream.Err(); nil != err {
+		return reported, fmt.Errorf("usage change stream failed: %w", err)
+	}
+	return reported, errors.New("usage change stream closed")
+}
+
+type usageSample struct {
+	Upload   uint  `bson:"upload"`
+	Download uint  `bson:"download"`
+	At       int64 `bson:"at"`
+}
+
+type periodUsage struct {
+	PublicKey string       `bson:"publicKey"`
+	Before    *usageSample `bson:"before"`
+	Last      *usageSample `bson:"last"`
+	// BeforeBucket and LastBucket are the compacted buckets that may contain newer before and last
+	// samples.
+	BeforeBucket *usageBucket `bson:"beforeBucket"`
+	LastBucket   *usageBucket `bson:"lastBucket"`
+}
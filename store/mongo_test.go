@@ -0,0 +1,239 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/store"
+	"github.com/xeptore/wireuse/store/storetest"
+)
+
+// testDatabase returns an empty database of the deployment WIREUSE_TEST_MONGODB_URI points to,
+// skipping the test if it is not set.
+func testDatabase(t testing.TB) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("WIREUSE_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("WIREUSE_TEST_MONGODB_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetServerSelectionTimeout(5*time.Second))
+	require.NoError(t, err)
+	db := client.Database(fmt.Sprintf("wireuse_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		require.NoError(t, db.Drop(ctx))
+		require.NoError(t, client.Disconnect(ctx))
+	})
+	return db
+}
+
+func TestMongoConformance(t *testing.T) {
+	t.Parallel()
+	db := testDatabase(t)
+
+	var n atomic.Int64
+	newStore := func(shared bool) func(t *testing.T) ingest.Store {
+		return func(t *testing.T) ingest.Store {
+			name := fmt.Sprintf("wg%d", n.Add(1))
+			usage := store.NewMongo(db.Collection(name))
+			if shared {
+				usage = store.NewSharedMongo(db.Collection(store.UsageCollectionName), "host", name)
+			}
+			_, err := usage.EnsureIndexes(context.Background())
+			require.NoError(t, err)
+			return usage
+		}
+	}
+	t.Run("PerInterface", func(t *testing.T) {
+		t.Parallel()
+		storetest.Run(t, newStore(false))
+	})
+	t.Run("Shared", func(t *testing.T) {
+		t.Parallel()
+		storetest.Run(t, newStore(true))
+	})
+}
+
+// BenchmarkMongoIngestUsage100kPeers ingests the usage of 100k peers per op, in the default bulk
+// writes and in a single one.
+func BenchmarkMongoIngestUsage100kPeers(b *testing.B) {
+	ctx := context.Background()
+	db := testDatabase(b)
+
+	peersUsage := make([]ingest.PeerUsage, 100_000)
+	for i := range peersUsage {
+		peersUsage[i] = ingest.PeerUsage{Upload: uint(i), Download: uint(i), PublicKey: fmt.Sprintf("peer-%d", i)}
+	}
+	for name, batches := range map[string][2]int{
+		"Batches":         {store.DefaultIngestBatchSize, store.DefaultIngestConcurrency},
+		"SingleBulkWrite": {len(peersUsage), 1},
+	} {
+		b.Run(name, func(b *testing.B) {
+			usage := store.NewMongo(db.Collection(name))
+			usage.SetIngestBatches(batches[0], batches[1])
+			_, err := usage.EnsureIndexes(ctx)
+			require.NoError(b, err)
+
+			at := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				at = at.Add(5 * time.Second)
+				if err := usage.IngestUsage(ctx, peersUsage, at); nil != err {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type fakeDevice struct {
+	peers []ingest.PeerUsage
+	at    time.Time
+}
+
+func (d *fakeDevice) Usage(ctx context.Context) ([]ingest.PeerUsage, time.Time, error) {
+	return append([]ingest.PeerUsage(nil), d.peers...), d.at, nil
+}
+
+type fakeRestartMark struct {
+	marked bool
+}
+
+func (m *fakeRestartMark) Read(filename string) ([1]byte, error) {
+	if !m.marked {
+		return [1]byte{0}, os.ErrNotExist
+	}
+	return [1]byte{1}, nil
+}
+
+func (m *fakeRestartMark) Remove(filename string) error {
+	m.marked = false
+	return nil
+}
+
+func TestMongoPreservesTotalsAcrossRestarts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+
+	for _, usage := range []*store.Mongo{
+		store.NewMongo(db.Collection("wg0")),
+		store.NewSharedMongo(db.Collection(store.UsageCollectionName), "host", "wg0"),
+	} {
+		_, err := usage.EnsureIndexes(ctx)
+		require.NoError(t, err)
+
+		start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		device := &fakeDevice{at: start}
+		mark := &fakeRestartMark{}
+		engine := ingest.NewEngine(mark, device, usage, zerolog.New(io.Discard))
+		sample := func(e *ingest.Engine, peers ...ingest.PeerUsage) {
+			t.Helper()
+			device.peers = peers
+			device.at = device.at.Add(time.Minute)
+			require.NoError(t, e.Flush(ctx, "mark"))
+		}
+
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 100, Download: 1000}, ingest.PeerUsage{PublicKey: "abc", Upload: 10, Download: 20})
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 150, Download: 1500}, ingest.PeerUsage{PublicKey: "abc", Upload: 30, Download: 40})
+
+		// The interface restarts, and its counters start over.
+		mark.marked = true
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 5, Download: 50}, ingest.PeerUsage{PublicKey: "abc", Upload: 1, Download: 2})
+		require.False(t, mark.marked)
+
+		// The process restarts, and resumes from the stored baselines.
+		engine = ingest.NewEngine(mark, device, usage, zerolog.New(io.Discard))
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 10, Download: 100}, ingest.PeerUsage{PublicKey: "abc", Upload: 3, Download: 4})
+
+		// abc is removed from the interface and added back, while xyz keeps going.
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 20, Download: 200})
+		sample(&engine, ingest.PeerUsage{PublicKey: "xyz", Upload: 30, Download: 300}, ingest.PeerUsage{PublicKey: "abc", Upload: 2, Download: 2})
+
+		got, err := usage.UsageBetween(ctx, start, device.at.Add(time.Second))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []ingest.PeerUsage{
+			{PublicKey: "xyz", Upload: 150 + 30, Download: 1500 + 300},
+			{PublicKey: "abc", Upload: 30 + 3 + 2, Download: 40 + 4 + 2},
+		}, got)
+
+		baselines, err := usage.LoadBaselines(ctx)
+		require.NoError(t, err)
+		require.Equal(t, ingest.PeerUsage{PublicKey: "abc", Upload: 30 + 3, Download: 40 + 4}, baselines["abc"].Offset)
+		require.Equal(t, ingest.PeerUsage{PublicKey: "abc", Upload: 30 + 3 + 2, Download: 40 + 4 + 2}, baselines["abc"].Last)
+	}
+}
+
+func TestMongoCompactPreservesUsage(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := testDatabase(t)
+
+	for _, usage := range []*store.Mongo{
+		store.NewMongo(db.Collection("wg0")),
+		store.NewSharedMongo(db.Collection(store.UsageCollectionName), "host", "wg0"),
+	} {
+		_, err := usage.EnsureIndexes(ctx)
+		require.NoError(t, err)
+		usage.SetDeltaEncoding(4)
+
+		start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		at := start
+		for i := 1; i <= 11; i++ {
+			at = at.Add(time.Minute)
+			peers := []ingest.PeerUsage{{PublicKey: "xyz", Upload: uint(10 * i), Download: uint(100 * i)}}
+			if i <= 6 {
+				peers = append(peers, ingest.PeerUsage{PublicKey: "abc", Upload: uint(i), Download: uint(2 * i)})
+			}
+			require.NoError(t, usage.IngestUsage(ctx, peers, at))
+		}
+
+		between := func(from, to time.Time) []ingest.PeerUsage {
+			t.Helper()
+			got, err := usage.UsageBetween(ctx, from, to)
+			require.NoError(t, err)
+			return got
+		}
+		windows := [][2]time.Time{
+			{start, at.Add(time.Second)},
+			{start.Add(3 * time.Minute), start.Add(5 * time.Minute)},
+			{start.Add(5 * time.Minute), at.Add(time.Second)},
+			{start.Add(9 * time.Minute), at.Add(time.Second)},
+		}
+		want := make([][]ingest.PeerUsage, len(windows))
+		for i, w := range windows {
+			want[i] = between(w[0], w[1])
+		}
+		wantBaselines, err := usage.LoadBaselines(ctx)
+		require.NoError(t, err)
+
+		n, err := usage.Compact(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		n, err = usage.Compact(ctx)
+		require.NoError(t, err)
+		require.Zero(t, n, "expected compacted samples not to be compacted again")
+
+		for i, w := range windows {
+			require.ElementsMatch(t, want[i], between(w[0], w[1]), "window %d", i)
+		}
+		baselines, err := usage.LoadBaselines(ctx)
+		require.NoError(t, err)
+		require.Equal(t, wantBaselines, baselines)
+	}
+}
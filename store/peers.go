@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/provision"
+)
+
+const PeersCollectionName = "peers"
+
+type MongoPeers struct {
+	collection *mongo.Collection
+}
+
+func NewMongoPeers(collection *mongo.Collection) *MongoPeers {
+	return &MongoPeers{collection: collection}
+}
+
+func (m *MongoPeers) EnsureIndexes(ctx context.Context) ([]string, error) {
+	return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "publicKey", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "interface", Value: 1}, {Key: "allowedIPs", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+}
+
+func (m *MongoPeers) ListPeers(ctx context.Context, interfaceName string) ([]provision.Peer, error) {
+	cursor, err := m.collection.Find(ctx, bson.M{"interface": interfaceName}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if nil != err {
+		return nil, fmt.Errorf("failed to query peers: %v", err)
+	}
+	var peers []provision.Peer
+	if err := cursor.All(ctx, &peers); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+	return peers, nil
+}
+
+func (m *MongoPeers) InsertPeer(ctx context.Context, peer provision.Peer) error {
+	if _, err := m.collection.InsertOne(ctx, peer); nil != err {
+		return fmt.Errorf("failed to insert peer: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/xeptore/wireuse/pkg/funcutils"
+	"github.com/xeptore/wireuse/presence"
+)
+
+const SessionsCollectionName = "sessions"
+
+type MongoSessions struct {
+	collection *mongo.Collection
+	// host scopes the sessions when hosts share the database, and is empty otherwise.
+	host string
+}
+
+// NewMongoSessions creates a sessions store of host, which is empty unless hosts share the
+// database, as in the shared usage layout.
+func NewMongoSessions(collection *mongo.Collection, host string) *MongoSessions {
+	return &MongoSessions{collection: collection, host: host}
+}
+
+func (m *MongoSessions) EnsureIndexes(ctx context.Context) ([]string, error) {
+	return m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "host", Value: 1}, {Key: "interface", Value: 1}, {Key: "publicKey", Value: 1}, {Key: "start", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "host", Value: 1}, {Key: "interface", Value: 1}, {Key: "end", Value: 1}},
+		},
+	})
+}
+
+func (m *MongoSessions) OpenSessions(ctx context.Context, interfaceName string) ([]presence.Session, error) {
+	cursor, err := m.collection.Find(ctx, hostFilter(m.host, bson.M{"interface": interfaceName, "end": nil}))
+	if nil != err {
+		return nil, fmt.Errorf("failed to query open sessions: %v", err)
+	}
+	var sessions []presence.Session
+	if err := cursor.All(ctx, &sessions); nil != err {
+		return nil, fmt.Errorf("failed to read all documents: %v", err)
+	}
+	return sessions, nil
+}
+
+func (m *MongoSessions) StartSessions(ctx context.Context, sessions []presence.Session) error {
+	models := funcutils.Map(sessions, func(s presence.Session) mongo.WriteModel {
+		return mongo.NewUpdateOneModel().
+			SetFilter(hostFilter(m.host, bson.M{"interface": s.Interface, "publicKey": s.PublicKey, "start": s.Start})).
+			SetUpdate(bson.M{"$setOnInsert": hosted[presence.Session]{Host: m.host, Doc: s}}).
+			SetUpsert(true)
+	})
+	if _, err := m.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); nil != err {
+		return fmt.Errorf("failed to insert sessions: %v", err)
+	}
+	return nil
+}
+
+func (m *MongoSessions) EndSessions(ctx context.Context, sessions []presence.Session) error {
+	models := funcutils.Map(sessions, func(s presence.Session) mongo.WriteModel {
+		return mongo.NewUpdateOneModel().
+			SetFilter(hostFilter(m.host, bson.M{"interface": s.Interface, "publicKey": s.PublicKey, "start": s.Start})).
+			SetUpdate(bson.M{"$set": bson.M{"end": s.End, "upload": s.Upload, "download": s.Download}})
+	})
+	if _, err := m.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); nil != err {
+		return fmt.Errorf("failed to end sessions: %v", err)
+	}
+	return nil
+}
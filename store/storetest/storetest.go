@@ -0,0 +1,210 @@
+// Package storetest is a conformance test suite for ingest.Store implementations.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// LargeBatchPeers is the number of peers ingested at once by the large batch test.
+const LargeBatchPeers = 50_000
+
+// Run runs the suite against the stores returned by newStore, which must return an empty store
+// every time it is called.
+func Run(t *testing.T, newStore func(t *testing.T) ingest.Store) {
+	t.Helper()
+	tests := map[string]func(t *testing.T, s ingest.Store){
+		"Empty":             testEmpty,
+		"Ordering":          testOrdering,
+		"RepeatedBaselines": testRepeatedBaselines,
+		"RestartBaselines":  testRestartBaselines,
+		"LargeBatch":        testLargeBatch,
+		"ConcurrentWriters": testConcurrentWriters,
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			test(t, newStore(t))
+		})
+	}
+}
+
+var start = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func usage(publicKey string, upload, download uint) ingest.PeerUsage {
+	return ingest.PeerUsage{Upload: upload, Download: download, PublicKey: publicKey}
+}
+
+func baseline(publicKey string, offset, last [2]uint) ingest.Baseline {
+	return ingest.Baseline{Offset: usage(publicKey, offset[0], offset[1]), Last: usage(publicKey, last[0], last[1])}
+}
+
+func testEmpty(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Empty(t, baselines)
+
+	offsets, err := s.ResetBaselines(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, offsets)
+
+	require.NoError(t, s.IngestUsage(ctx, nil, start))
+	baselines, err = s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Empty(t, baselines)
+}
+
+// testOrdering checks that the last ingested usage of each peer is the one of the latest batch it
+// was part of.
+func testOrdering(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.IngestUsage(ctx, []ingest.PeerUsage{usage("a", 1, 2), usage("b", 3, 4)}, start))
+	require.NoError(t, s.IngestUsage(ctx, []ingest.PeerUsage{usage("b", 5, 6), usage("a", 7, 8), usage("c", 9, 10)}, start.Add(time.Minute)))
+	require.NoError(t, s.IngestUsage(ctx, []ingest.PeerUsage{usage("c", 11, 12)}, start.Add(2*time.Minute)))
+
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingest.Baseline{
+		"a": baseline("a", [2]uint{}, [2]uint{7, 8}),
+		"b": baseline("b", [2]uint{}, [2]uint{5, 6}),
+		"c": baseline("c", [2]uint{}, [2]uint{11, 12}),
+	}, baselines)
+}
+
+// testRepeatedBaselines checks that repeating a batch, a reset or a load leaves the baselines
+// unchanged. It does not check the stored samples, which the Store interface cannot read, and
+// which a store may keep repeated batches of.
+func testRepeatedBaselines(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+
+	batch := []ingest.PeerUsage{usage("a", 1, 2), usage("b", 3, 4)}
+	require.NoError(t, s.IngestUsage(ctx, batch, start))
+	require.NoError(t, s.IngestUsage(ctx, batch, start))
+	expected := map[string]ingest.Baseline{
+		"a": baseline("a", [2]uint{}, [2]uint{1, 2}),
+		"b": baseline("b", [2]uint{}, [2]uint{3, 4}),
+	}
+	for i := 0; i < 2; i++ {
+		baselines, err := s.LoadBaselines(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, baselines)
+	}
+
+	for i := 0; i < 2; i++ {
+		offsets, err := s.ResetBaselines(ctx, []string{"a"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]ingest.PeerUsage{"a": usage("a", 1, 2)}, offsets)
+	}
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	expected["a"] = baseline("a", [2]uint{1, 2}, [2]uint{1, 2})
+	require.Equal(t, expected, baselines)
+}
+
+// testRestartBaselines checks that resetting baselines sets the offsets of the reset peers to
+// their last ingested usage, and keeps them until they are reset again.
+func testRestartBaselines(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.IngestUsage(ctx, []ingest.PeerUsage{usage("a", 10, 20), usage("b", 30, 40)}, start))
+	offsets, err := s.ResetBaselines(ctx, []string{"a", "unknown"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingest.PeerUsage{"a": usage("a", 10, 20)}, offsets)
+
+	// The engine ingests the counters of a after they were reset, along with their offset.
+	require.NoError(t, s.IngestUsage(ctx, []ingest.PeerUsage{usage("a", 11, 22), usage("b", 35, 45)}, start.Add(time.Minute)))
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingest.Baseline{
+		"a": baseline("a", [2]uint{10, 20}, [2]uint{11, 22}),
+		"b": baseline("b", [2]uint{}, [2]uint{35, 45}),
+	}, baselines)
+
+	offsets, err = s.ResetBaselines(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingest.PeerUsage{"a": usage("a", 11, 22), "b": usage("b", 35, 45)}, offsets)
+	baselines, err = s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingest.Baseline{
+		"a": baseline("a", [2]uint{11, 22}, [2]uint{11, 22}),
+		"b": baseline("b", [2]uint{35, 45}, [2]uint{35, 45}),
+	}, baselines)
+
+	offsets, err = s.ResetBaselines(ctx, []string{"unknown"})
+	require.NoError(t, err)
+	require.Empty(t, offsets)
+}
+
+func testLargeBatch(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+
+	batch := make([]ingest.PeerUsage, LargeBatchPeers)
+	for i := range batch {
+		batch[i] = usage(fmt.Sprintf("peer-%d", i), uint(i), uint(2*i))
+	}
+	require.NoError(t, s.IngestUsage(ctx, batch, start))
+
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Len(t, baselines, LargeBatchPeers)
+	for i, p := range batch {
+		require.Equal(t, baseline(p.PublicKey, [2]uint{}, [2]uint{uint(i), uint(2 * i)}), baselines[p.PublicKey])
+	}
+
+	offsets, err := s.ResetBaselines(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, offsets, LargeBatchPeers)
+}
+
+// testConcurrentWriters checks that batches of distinct peers ingested concurrently, e.g., by the
+// engines of several interfaces sharing a store, are all kept.
+func testConcurrentWriters(t *testing.T, s ingest.Store) {
+	ctx := context.Background()
+	const (
+		writers = 8
+		batches = 10
+		peers   = 100
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*batches)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 1; b <= batches; b++ {
+				batch := make([]ingest.PeerUsage, peers)
+				for p := range batch {
+					batch[p] = usage(fmt.Sprintf("writer-%d-peer-%d", w, p), uint(b), uint(b*p))
+				}
+				errs <- s.IngestUsage(ctx, batch, start.Add(time.Duration(b)*time.Minute))
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	baselines, err := s.LoadBaselines(ctx)
+	require.NoError(t, err)
+	require.Len(t, baselines, writers*peers)
+	for w := 0; w < writers; w++ {
+		for p := 0; p < peers; p++ {
+			publicKey := fmt.Sprintf("writer-%d-peer-%d", w, p)
+			require.Equal(t, baseline(publicKey, [2]uint{}, [2]uint{batches, batches * uint(p)}), baselines[publicKey])
+		}
+	}
+}
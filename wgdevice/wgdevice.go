@@ -0,0 +1,92 @@
+// Package wgdevice reads the peers usage of WireGuard interfaces through wgctrl.
+package wgdevice
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Client reads WireGuard interfaces, e.g., a *wgctrl.Client.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+}
+
+// Peers reads the peers usage of an interface. It caches the encoded public keys and endpoints of
+// the peers, which rarely change, so that reading large interfaces does not allocate strings for
+// every peer on every read. Usage must not be called concurrently.
+type Peers struct {
+	client     Client
+	deviceName string
+	peers      map[wgtypes.Key]*peer
+	// reads counts the reads, marking the cached peers seen by the latest read.
+	reads uint64
+}
+
+type peer struct {
+	publicKey string
+	endpoint  *net.UDPAddr
+	address   string
+	seenAt    uint64
+}
+
+func NewPeers(client Client, deviceName string) *Peers {
+	return &Peers{client: client, deviceName: deviceName, peers: make(map[wgtypes.Key]*peer)}
+}
+
+func (p *Peers) Usage(ctx context.Context) ([]ingest.PeerUsage, time.Time, error) {
+	dev, err := p.client.Device(p.deviceName)
+	gatheredAt := time.Now()
+	if nil != err {
+		return nil, gatheredAt, err
+	}
+	return p.convert(dev.Peers), gatheredAt, nil
+}
+
+func (p *Peers) convert(peers []wgtypes.Peer) []ingest.PeerUsage {
+	p.reads++
+	out := make([]ingest.PeerUsage, len(peers))
+	for i := range peers {
+		wp := &peers[i]
+		cached, exists := p.peers[wp.PublicKey]
+		if !exists {
+			cached = &peer{publicKey: wp.PublicKey.String()}
+			p.peers[wp.PublicKey] = cached
+		}
+		cached.seenAt = p.reads
+		if !sameEndpoint(cached.endpoint, wp.Endpoint) {
+			cached.endpoint = wp.Endpoint
+			cached.address = ""
+			if nil != wp.Endpoint {
+				cached.address = wp.Endpoint.String()
+			}
+		}
+		out[i] = ingest.PeerUsage{
+			Upload:          uint(wp.TransmitBytes),
+			Download:        uint(wp.ReceiveBytes),
+			PublicKey:       cached.publicKey,
+			LatestHandshake: wp.LastHandshakeTime,
+			Endpoint:        cached.address,
+		}
+	}
+
+	if len(p.peers) > len(peers) {
+		for key, cached := range p.peers {
+			if cached.seenAt != p.reads {
+				delete(p.peers, key)
+			}
+		}
+	}
+	return out
+}
+
+func sameEndpoint(a, b *net.UDPAddr) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+	return a.Port == b.Port && a.Zone == b.Zone && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,89 @@
+package wgdevice_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/wgdevice"
+)
+
+type fakeClient struct {
+	device *wgtypes.Device
+}
+
+func (c *fakeClient) Device(name string) (*wgtypes.Device, error) {
+	return c.device, nil
+}
+
+func key(i int) wgtypes.Key {
+	var k wgtypes.Key
+	k[0], k[1], k[2] = byte(i>>16), byte(i>>8), byte(i)
+	return k
+}
+
+func devicePeers(n int) []wgtypes.Peer {
+	peers := make([]wgtypes.Peer, n)
+	for i := range peers {
+		peers[i] = wgtypes.Peer{
+			PublicKey:     key(i),
+			Endpoint:      &net.UDPAddr{IP: net.IPv4(198, 51, byte(i>>8), byte(i)), Port: 51820},
+			TransmitBytes: int64(i),
+			ReceiveBytes:  int64(2 * i),
+		}
+	}
+	return peers
+}
+
+func TestPeers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	handshake := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	client := &fakeClient{device: &wgtypes.Device{Peers: []wgtypes.Peer{
+		{PublicKey: key(1), Endpoint: &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 51820}, TransmitBytes: 10, ReceiveBytes: 20, LastHandshakeTime: handshake},
+		{PublicKey: key(2), TransmitBytes: 30, ReceiveBytes: 40},
+	}}}
+	peers := wgdevice.NewPeers(client, "wg0")
+
+	usage, _, err := peers.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ingest.PeerUsage{
+		{Upload: 10, Download: 20, PublicKey: key(1).String(), LatestHandshake: handshake, Endpoint: "198.51.100.1:51820"},
+		{Upload: 30, Download: 40, PublicKey: key(2).String()},
+	}, usage)
+
+	client.device = &wgtypes.Device{Peers: []wgtypes.Peer{
+		{PublicKey: key(2), Endpoint: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51820}, TransmitBytes: 31, ReceiveBytes: 41},
+		{PublicKey: key(1), Endpoint: &net.UDPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 51820}, TransmitBytes: 11, ReceiveBytes: 21},
+	}}
+	usage, _, err = peers.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ingest.PeerUsage{
+		{Upload: 31, Download: 41, PublicKey: key(2).String(), Endpoint: "[2001:db8::1]:51820"},
+		{Upload: 11, Download: 21, PublicKey: key(1).String(), Endpoint: "198.51.100.2:51820"},
+	}, usage)
+
+	client.device = &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: key(1), TransmitBytes: 12, ReceiveBytes: 22}}}
+	usage, _, err = peers.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ingest.PeerUsage{{Upload: 12, Download: 22, PublicKey: key(1).String()}}, usage)
+}
+
+func BenchmarkPeers100k(b *testing.B) {
+	ctx := context.Background()
+	peers := wgdevice.NewPeers(&fakeClient{device: &wgtypes.Device{Peers: devicePeers(100_000)}}, "wg0")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := peers.Usage(ctx); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
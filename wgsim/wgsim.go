@@ -0,0 +1,235 @@
+// Package wgsim simulates a WireGuard interface for tests and load tests. A Device implements
+// ingest.WgPeers, and its peers traffic, membership, restarts and read errors are driven by the
+// test, along with its clock.
+package wgsim
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xeptore/wireuse/ingest"
+)
+
+// Pattern generates the traffic of a peer.
+type Pattern interface {
+	// Traffic returns the bytes a peer uploads and downloads during the elapsed duration ending at now.
+	Traffic(now time.Time, elapsed time.Duration) (upload, download uint)
+}
+
+// Constant transfers Upload and Download bytes per second.
+type Constant struct {
+	Upload   uint
+	Download uint
+}
+
+func (c Constant) Traffic(now time.Time, elapsed time.Duration) (uint, uint) {
+	return perSecond(c.Upload, elapsed), perSecond(c.Download, elapsed)
+}
+
+// Bursty transfers at Rate during the first For of every Every, which must be positive, and is
+// idle otherwise.
+type Bursty struct {
+	Rate  Constant
+	Every time.Duration
+	For   time.Duration
+}
+
+func (b Bursty) Traffic(now time.Time, elapsed time.Duration) (uint, uint) {
+	if time.Duration(now.UnixNano())%b.Every >= b.For {
+		return 0, 0
+	}
+	return b.Rate.Traffic(now, elapsed)
+}
+
+// Random transfers up to Max bytes per second, using a seeded source so runs are reproducible.
+type Random struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	max  Constant
+}
+
+func NewRandom(seed int64, max Constant) *Random {
+	return &Random{rand: rand.New(rand.NewSource(seed)), max: max}
+}
+
+func (r *Random) Traffic(now time.Time, elapsed time.Duration) (uint, uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload := perSecond(uint(r.rand.Int63n(int64(r.max.Upload)+1)), elapsed)
+	download := perSecond(uint(r.rand.Int63n(int64(r.max.Download)+1)), elapsed)
+	return upload, download
+}
+
+// Idle never transfers anything.
+var Idle Pattern = Constant{}
+
+func perSecond(rate uint, elapsed time.Duration) uint {
+	return uint(float64(rate) * elapsed.Seconds())
+}
+
+// Key returns the i-th generated peer public key, which has the form of a WireGuard key.
+func Key(i int) string {
+	var key [32]byte
+	binary.BigEndian.PutUint64(key[:], uint64(i))
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+type peer struct {
+	pattern  Pattern
+	counters ingest.PeerUsage
+}
+
+// Device is a simulated interface. Its peers traffic only moves when its clock is advanced.
+type Device struct {
+	mu    sync.Mutex
+	now   time.Time
+	keys  []string
+	peers map[string]*peer
+	// totals are the peers usage across restarts and removals, which ingested totals must match.
+	totals   map[string]ingest.PeerUsage
+	nextKey  int
+	failures []error
+}
+
+// NewDevice creates a device without peers, whose clock starts at now.
+func NewDevice(now time.Time) *Device {
+	return &Device{
+		now:    now,
+		peers:  make(map[string]*peer),
+		totals: make(map[string]ingest.PeerUsage),
+	}
+}
+
+// AddPeer adds a peer, whose counters start from zero, as after a peer is added to an interface.
+func (d *Device) AddPeer(publicKey string, pattern Pattern) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addPeer(publicKey, pattern)
+}
+
+func (d *Device) addPeer(publicKey string, pattern Pattern) error {
+	if _, exists := d.peers[publicKey]; exists {
+		return fmt.Errorf("peer %s already exists", publicKey)
+	}
+	d.keys = append(d.keys, publicKey)
+	d.peers[publicKey] = &peer{pattern: pattern, counters: ingest.PeerUsage{PublicKey: publicKey}}
+	return nil
+}
+
+// AddPeers adds n peers with keys generated by Key, following the ones generated by previous
+// calls, and returns their keys.
+func (d *Device) AddPeers(n int, pattern Pattern) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0, n)
+	for len(keys) < n {
+		key := Key(d.nextKey)
+		d.nextKey++
+		if err := d.addPeer(key, pattern); nil != err {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RemovePeer removes a peer. Its counters start from zero if it is added again.
+func (d *Device) RemovePeer(publicKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.peers[publicKey]; !exists {
+		return fmt.Errorf("peer %s does not exist", publicKey)
+	}
+	delete(d.peers, publicKey)
+	for i, key := range d.keys {
+		if key == publicKey {
+			d.keys = append(d.keys[:i], d.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Restart resets the counters of every peer, as recreating the interface does.
+func (d *Device) Restart() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for publicKey, p := range d.peers {
+		p.counters = ingest.PeerUsage{PublicKey: publicKey}
+	}
+}
+
+// FailReads makes the next reads fail with errs, in order.
+func (d *Device) FailReads(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, errs...)
+}
+
+// Advance moves the clock forward by elapsed, and adds the traffic of the peers in the meantime.
+// Peers with traffic have their latest handshake set to the new time.
+func (d *Device) Advance(elapsed time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.now = d.now.Add(elapsed)
+	for _, publicKey := range d.keys {
+		p := d.peers[publicKey]
+		upload, download := p.pattern.Traffic(d.now, elapsed)
+		if upload == 0 && download == 0 {
+			continue
+		}
+		p.counters.Upload += upload
+		p.counters.Download += download
+		p.counters.LatestHandshake = d.now
+		total := d.totals[publicKey]
+		total.PublicKey = publicKey
+		total.Upload += upload
+		total.Download += download
+		d.totals[publicKey] = total
+	}
+}
+
+// Now returns the current time of the clock.
+func (d *Device) Now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.now
+}
+
+// Totals returns the usage of each peer that ever transferred anything, across restarts and
+// removals.
+func (d *Device) Totals() map[string]ingest.PeerUsage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]ingest.PeerUsage, len(d.totals))
+	for publicKey, total := range d.totals {
+		out[publicKey] = total
+	}
+	return out
+}
+
+// ErrRead is a read error for FailReads.
+var ErrRead = errors.New("simulated device read error")
+
+// Usage returns the peers counters, in the order they were added, as gathered at the current time
+// of the clock, unless the read is set to fail.
+func (d *Device) Usage(ctx context.Context) ([]ingest.PeerUsage, time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.failures) > 0 {
+		err := d.failures[0]
+		d.failures = d.failures[1:]
+		return nil, d.now, err
+	}
+	out := make([]ingest.PeerUsage, 0, len(d.keys))
+	for _, publicKey := range d.keys {
+		out = append(out, d.peers[publicKey].counters)
+	}
+	return out, d.now, nil
+}
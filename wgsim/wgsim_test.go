@@ -0,0 +1,78 @@
+package wgsim_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xeptore/wireuse/ingest"
+	"github.com/xeptore/wireuse/wgsim"
+)
+
+func TestDevice(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	start := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	d := wgsim.NewDevice(start)
+	keys := d.AddPeers(2, wgsim.Constant{Upload: 10, Download: 20})
+	require.Equal(t, []string{wgsim.Key(0), wgsim.Key(1)}, keys)
+	require.NoError(t, d.AddPeer("idle", wgsim.Idle))
+	require.Error(t, d.AddPeer("idle", wgsim.Idle))
+
+	d.Advance(5 * time.Second)
+	usage, gatheredAt, err := d.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, start.Add(5*time.Second), gatheredAt)
+	require.Equal(t, []ingest.PeerUsage{
+		{Upload: 50, Download: 100, PublicKey: keys[0], LatestHandshake: gatheredAt},
+		{Upload: 50, Download: 100, PublicKey: keys[1], LatestHandshake: gatheredAt},
+		{PublicKey: "idle"},
+	}, usage)
+
+	d.Restart()
+	require.NoError(t, d.RemovePeer(keys[0]))
+	d.Advance(time.Second)
+	require.NoError(t, d.AddPeer(keys[0], wgsim.Constant{Upload: 1, Download: 1}))
+	d.Advance(time.Second)
+	d.FailReads(wgsim.ErrRead)
+	_, _, err = d.Usage(ctx)
+	require.ErrorIs(t, err, wgsim.ErrRead)
+	usage, gatheredAt, err = d.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ingest.PeerUsage{
+		{Upload: 20, Download: 40, PublicKey: keys[1], LatestHandshake: gatheredAt},
+		{PublicKey: "idle"},
+		{Upload: 1, Download: 1, PublicKey: keys[0], LatestHandshake: gatheredAt},
+	}, usage)
+	require.Equal(t, map[string]ingest.PeerUsage{
+		keys[0]: {Upload: 51, Download: 101, PublicKey: keys[0]},
+		keys[1]: {Upload: 70, Download: 140, PublicKey: keys[1]},
+	}, d.Totals())
+
+	require.Equal(t, []string{wgsim.Key(2)}, d.AddPeers(1, wgsim.Idle))
+}
+
+func TestPatterns(t *testing.T) {
+	t.Parallel()
+
+	bursty := wgsim.Bursty{Rate: wgsim.Constant{Upload: 10, Download: 10}, Every: time.Minute, For: 10 * time.Second}
+	upload, download := bursty.Traffic(time.Date(2023, 3, 1, 0, 0, 5, 0, time.UTC), time.Second)
+	require.Equal(t, uint(10), upload)
+	require.Equal(t, uint(10), download)
+	upload, download = bursty.Traffic(time.Date(2023, 3, 1, 0, 0, 30, 0, time.UTC), time.Second)
+	require.Zero(t, upload)
+	require.Zero(t, download)
+
+	a, b := wgsim.NewRandom(1, wgsim.Constant{Upload: 100, Download: 100}), wgsim.NewRandom(1, wgsim.Constant{Upload: 100, Download: 100})
+	for i := 0; i < 10; i++ {
+		ua, da := a.Traffic(time.Time{}, time.Second)
+		ub, db := b.Traffic(time.Time{}, time.Second)
+		require.Equal(t, ua, ub)
+		require.Equal(t, da, db)
+		require.LessOrEqual(t, ua, uint(100))
+		require.LessOrEqual(t, da, uint(100))
+	}
+}